@@ -11,6 +11,8 @@ type AoiManager struct {
 	quadtree *Quadtree
 	objMap   map[uint64]IPoint
 	mapLock  sync.RWMutex
+
+	viewRange float32
 }
 
 func NewQuadtreeAoiManager(minX, maxX, minZ, maxZ float32, capacity int, maxDepth int) ziface.IAoiManager {
@@ -24,13 +26,24 @@ func NewQuadtreeAoiManager(minX, maxX, minZ, maxZ float32, capacity int, maxDept
 	return &AoiManager{
 		quadtree: qt,
 		objMap:   make(map[uint64]IPoint),
+
+		viewRange: 50,
 	}
 }
 
-func (m *AoiManager) GetSurroundingObjectIDs(x, z float32) []uint64 {
+// SetViewRange changes the half-width of the square GetSurroundingObjectIDs
+// queries. It can be called while the manager is in use.
+func (m *AoiManager) SetViewRange(viewRange float32) {
+	m.mapLock.Lock()
+	defer m.mapLock.Unlock()
+	m.viewRange = viewRange
+}
 
-	viewRange := float32(50.0)
+func (m *AoiManager) GetSurroundingObjectIDs(x, z float32) []uint64 {
+	m.mapLock.RLock()
+	defer m.mapLock.RUnlock()
 
+	viewRange := m.viewRange
 	queryRect := Rect{
 		MinX: x - viewRange,
 		MinZ: z - viewRange,
@@ -38,9 +51,6 @@ func (m *AoiManager) GetSurroundingObjectIDs(x, z float32) []uint64 {
 		MaxZ: z + viewRange,
 	}
 
-	m.mapLock.RLock()
-	defer m.mapLock.RUnlock()
-
 	return m.quadtree.QueryRange(queryRect)
 }
 
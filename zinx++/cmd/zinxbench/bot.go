@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+type bot struct {
+	id     int
+	script *Script
+	opts   *benchOptions
+	stats  *counters
+	lat    *latencies
+	client ziface.IClient
+
+	// pending holds send times per request msgID, oldest first.
+	pending     map[uint32][]time.Time
+	pendingLock sync.Mutex
+	loggedIn    atomic.Bool
+}
+
+type replyRouter struct {
+	znet.BaseRouter
+	b       *bot
+	request uint32
+}
+
+func (r *replyRouter) Handle(request ziface.IRequest) {
+	r.b.stats.recvMsgs.Add(1)
+	r.b.stats.recvBytes.Add(uint64(len(request.GetData())))
+
+	if sentAt, ok := r.b.popPending(r.request); ok {
+		r.b.lat.record(r.request, time.Since(sentAt))
+		if r.b.script.Login != nil && r.request == r.b.script.Login.MsgID {
+			r.b.loggedIn.Store(true)
+		}
+	}
+}
+
+type countRouter struct {
+	znet.BaseRouter
+	stats *counters
+}
+
+func (r *countRouter) Handle(request ziface.IRequest) {
+	r.stats.recvMsgs.Add(1)
+	r.stats.recvBytes.Add(uint64(len(request.GetData())))
+}
+
+func newBot(id int, script *Script, opts *benchOptions, stats *counters) *bot {
+	b := &bot{
+		id:      id,
+		script:  script,
+		opts:    opts,
+		stats:   stats,
+		lat:     newLatencies(),
+		pending: make(map[uint32][]time.Time),
+	}
+
+	clientOpts := []znet.ClientOption{
+		znet.WithDialTimeout(opts.dialTimeout),
+		znet.WithClientDataPack(opts.dataPack),
+		znet.WithClientOnConnStart(b.onConnStart),
+		znet.WithClientOnConnStop(b.onConnStop),
+	}
+	if opts.reconnect {
+		clientOpts = append(clientOpts, znet.WithReconnect(500*time.Millisecond, 10*time.Second))
+	} else {
+		clientOpts = append(clientOpts, znet.WithReconnect(0, 0))
+	}
+	if opts.heartbeatMsgID != 0 {
+		clientOpts = append(clientOpts, znet.WithHeartbeat(opts.heartbeatInterval, 0, opts.heartbeatMsgID, nil))
+	}
+	b.client = znet.NewClient(opts.network, opts.addr, clientOpts...)
+
+	for reply, request := range script.replyIDs() {
+		b.client.AddRouter(reply, &replyRouter{b: b, request: request})
+	}
+	for _, msgID := range opts.countMsgIDs {
+		if _, ok := script.replyIDs()[msgID]; !ok {
+			b.client.AddRouter(msgID, &countRouter{stats: stats})
+		}
+	}
+	return b
+}
+
+func (b *bot) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	b.stats.dials.Add(1)
+	if err := b.client.Dial(ctx); err != nil {
+		b.stats.dialErrors.Add(1)
+		return
+	}
+	defer b.client.Stop()
+
+	var actions sync.WaitGroup
+	for i := range b.script.Actions {
+		actions.Add(1)
+		go b.runAction(ctx, &b.script.Actions[i], &actions)
+	}
+	actions.Wait()
+	<-ctx.Done()
+}
+
+func (b *bot) runAction(ctx context.Context, step *Step, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	rnd := rand.New(rand.NewSource(int64(b.id)<<8 ^ int64(step.MsgID)))
+	interval := time.Duration(float64(time.Second) / step.RatePerSec)
+
+	// Spread the first send so bots spawned together do not fire in bursts.
+	select {
+	case <-time.After(time.Duration(rnd.Int63n(int64(interval)) + 1)):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if b.loggedIn.Load() && b.client.IsConnected() {
+			b.expirePending(step.MsgID)
+			b.send(step, rnd)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *bot) onConnStart(conn ziface.IConnection) {
+	b.stats.connected.Add(1)
+	b.loggedIn.Store(false)
+	if b.script.Login == nil {
+		b.loggedIn.Store(true)
+		return
+	}
+	rnd := rand.New(rand.NewSource(int64(b.id)))
+	b.sendOn(conn, b.script.Login, rnd)
+	if b.script.Login.ReplyMsgID == 0 {
+		b.loggedIn.Store(true)
+	}
+}
+
+func (b *bot) onConnStop(conn ziface.IConnection) {
+	b.stats.connected.Add(-1)
+	b.stats.disconnects.Add(1)
+	b.loggedIn.Store(false)
+
+	b.pendingLock.Lock()
+	b.pending = make(map[uint32][]time.Time)
+	b.pendingLock.Unlock()
+}
+
+func (b *bot) send(step *Step, rnd *rand.Rand) {
+	if conn := b.client.Conn(); conn != nil {
+		b.sendOn(conn, step, rnd)
+	}
+}
+
+func (b *bot) sendOn(conn ziface.IConnection, step *Step, rnd *rand.Rand) {
+	data := step.payload(b.id, rnd)
+	if step.ReplyMsgID != 0 {
+		b.pendingLock.Lock()
+		b.pending[step.MsgID] = append(b.pending[step.MsgID], time.Now())
+		b.pendingLock.Unlock()
+	}
+	if err := conn.SendBuffMsg(step.MsgID, data); err != nil {
+		b.stats.sendErrors.Add(1)
+		if step.ReplyMsgID != 0 {
+			b.dropNewestPending(step.MsgID)
+		}
+		return
+	}
+	b.stats.sentMsgs.Add(1)
+	b.stats.sentBytes.Add(uint64(len(data)) + uint64(b.opts.dataPack.GetHeadLen()))
+}
+
+func (b *bot) popPending(msgID uint32) (time.Time, bool) {
+	b.pendingLock.Lock()
+	defer b.pendingLock.Unlock()
+	q := b.pending[msgID]
+	if len(q) == 0 {
+		return time.Time{}, false
+	}
+	b.pending[msgID] = q[1:]
+	return q[0], true
+}
+
+func (b *bot) dropNewestPending(msgID uint32) {
+	b.pendingLock.Lock()
+	defer b.pendingLock.Unlock()
+	if q := b.pending[msgID]; len(q) > 0 {
+		b.pending[msgID] = q[:len(q)-1]
+	}
+}
+
+// expirePending forgets requests whose reply is overdue so one lost reply
+// does not shift every later measurement.
+func (b *bot) expirePending(msgID uint32) {
+	deadline := time.Now().Add(-b.opts.replyTimeout)
+	b.pendingLock.Lock()
+	defer b.pendingLock.Unlock()
+	q := b.pending[msgID]
+	n := 0
+	for n < len(q) && q[n].Before(deadline) {
+		n++
+	}
+	if n > 0 {
+		b.pending[msgID] = q[n:]
+		b.stats.timeouts.Add(uint64(n))
+	}
+}
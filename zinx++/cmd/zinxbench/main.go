@@ -0,0 +1,251 @@
+// Command zinxbench drives a swarm of simulated clients against a Zinx++
+// server and reports throughput, per-msgID round-trip latency and error
+// counts.
+//
+// Bot behaviour comes from a JSON script (-script) or from the -login, -move
+// and -chat flags:
+//
+//	{
+//	  "login":   {"msgId": 1, "replyMsgId": 101, "payload": "user-{bot}"},
+//	  "actions": [
+//	    {"name": "move", "msgId": 2, "replyMsgId": 102, "ratePerSec": 5, "size": 16},
+//	    {"name": "chat", "msgId": 3, "ratePerSec": 0.2, "payload": "hello from {bot}"}
+//	  ]
+//	}
+//
+// Latency is measured from sending a step to receiving the next message with
+// its replyMsgId on the same connection.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+type benchOptions struct {
+	network string
+	addr    string
+
+	clients  int
+	rampUp   time.Duration
+	duration time.Duration
+
+	dialTimeout  time.Duration
+	replyTimeout time.Duration
+	reconnect    bool
+
+	heartbeatMsgID    uint32
+	heartbeatInterval time.Duration
+
+	countMsgIDs    []uint32
+	reportInterval time.Duration
+	verbose        bool
+
+	// dataPack frames every bot's messages; byte counts use its header size.
+	dataPack ziface.IDataPack
+}
+
+func main() {
+	opts := &benchOptions{}
+	flag.StringVar(&opts.network, "network", "tcp", "tcp, tcp4, tcp6 or unix")
+	flag.StringVar(&opts.addr, "addr", "127.0.0.1:8999", "server address or unix socket path")
+	flag.IntVar(&opts.clients, "clients", 100, "number of simulated clients")
+	flag.DurationVar(&opts.rampUp, "ramp", 10*time.Second, "time over which clients are spawned")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "total test duration including ramp-up")
+	flag.DurationVar(&opts.dialTimeout, "dial-timeout", 5*time.Second, "per-connection dial timeout")
+	flag.DurationVar(&opts.replyTimeout, "reply-timeout", 5*time.Second, "a request without reply after this long counts as timed out")
+	flag.BoolVar(&opts.reconnect, "reconnect", false, "redial dropped connections")
+	heartbeatMsgID := flag.Uint("heartbeat-msg", 0, "msgID sent as heartbeat, 0 disables")
+	flag.DurationVar(&opts.heartbeatInterval, "heartbeat-interval", 10*time.Second, "heartbeat interval")
+	countIDs := flag.String("count-msgs", "", "comma-separated msgIDs (e.g. broadcasts) counted as received traffic")
+	flag.DurationVar(&opts.reportInterval, "report", 5*time.Second, "progress report interval")
+	flag.BoolVar(&opts.verbose, "v", false, "keep framework logging on stdout")
+	maxPacket := flag.Uint("max-packet", 4096, "largest inbound message body accepted")
+
+	scriptPath := flag.String("script", "", "JSON bot script; overrides -login/-move/-chat")
+	login := flag.String("login", "", "login step as msgID[:replyMsgID], payload user-{bot}")
+	move := flag.String("move", "", "move step as msgID[:replyMsgID]")
+	moveRate := flag.Float64("move-rate", 5, "moves per second per client")
+	moveSize := flag.Int("move-size", 16, "move payload bytes")
+	chat := flag.String("chat", "", "chat step as msgID[:replyMsgID]")
+	chatRate := flag.Float64("chat-rate", 0.2, "chat messages per second per client")
+	chatSize := flag.Int("chat-size", 64, "chat payload bytes")
+	flag.Parse()
+
+	opts.heartbeatMsgID = uint32(*heartbeatMsgID)
+	opts.dataPack = znet.NewDataPackWithLimit(uint32(*maxPacket))
+
+	var err error
+	if opts.countMsgIDs, err = parseMsgIDs(*countIDs); err != nil {
+		fatalf("-count-msgs: %v", err)
+	}
+
+	var script *Script
+	if *scriptPath != "" {
+		script, err = loadScript(*scriptPath)
+	} else {
+		script, err = flagScript(*login, *move, *moveRate, *moveSize, *chat, *chatRate, *chatSize)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	// The client library logs every connect and disconnect; with thousands of
+	// bots that drowns the report, so stdout is muted unless -v is given.
+	out := os.Stdout
+	if !opts.verbose {
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+	}
+
+	run(opts, script, out)
+}
+
+func run(opts *benchOptions, script *Script, out *os.File) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			fmt.Fprintln(out, "[zinxbench] interrupted, stopping...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stats := &counters{}
+	bots := make([]*bot, 0, opts.clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	go func() {
+		ticker := time.NewTicker(opts.reportInterval)
+		defer ticker.Stop()
+		prev := stats.snapshot()
+		for {
+			select {
+			case <-ticker.C:
+				prev = stats.progress(out, prev)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	fmt.Fprintf(out, "[zinxbench] %d clients -> %s://%s, ramp %v, duration %v\n",
+		opts.clients, opts.network, opts.addr, opts.rampUp, opts.duration)
+
+	var spawnGap time.Duration
+	if opts.clients > 1 {
+		spawnGap = opts.rampUp / time.Duration(opts.clients)
+	}
+spawn:
+	for i := 0; i < opts.clients; i++ {
+		b := newBot(i, script, opts, stats)
+		bots = append(bots, b)
+		wg.Add(1)
+		go b.run(ctx, &wg)
+
+		if spawnGap > 0 {
+			select {
+			case <-time.After(spawnGap):
+			case <-ctx.Done():
+				break spawn
+			}
+		}
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	all := newLatencies()
+	for _, b := range bots {
+		all.merge(b.lat)
+	}
+
+	fmt.Fprintln(out)
+	stats.summary(out, elapsed)
+	fmt.Fprintln(out)
+	all.report(out, script.labels())
+}
+
+func flagScript(login, move string, moveRate float64, moveSize int, chat string, chatRate float64, chatSize int) (*Script, error) {
+	script := &Script{}
+	if login != "" {
+		msgID, reply, err := parseStep(login)
+		if err != nil {
+			return nil, fmt.Errorf("-login: %w", err)
+		}
+		script.Login = &Step{Name: "login", MsgID: msgID, ReplyMsgID: reply, Payload: "user-{bot}"}
+	}
+	if move != "" {
+		msgID, reply, err := parseStep(move)
+		if err != nil {
+			return nil, fmt.Errorf("-move: %w", err)
+		}
+		script.Actions = append(script.Actions, Step{Name: "move", MsgID: msgID, ReplyMsgID: reply, RatePerSec: moveRate, Size: moveSize})
+	}
+	if chat != "" {
+		msgID, reply, err := parseStep(chat)
+		if err != nil {
+			return nil, fmt.Errorf("-chat: %w", err)
+		}
+		script.Actions = append(script.Actions, Step{Name: "chat", MsgID: msgID, ReplyMsgID: reply, RatePerSec: chatRate, Size: chatSize})
+	}
+	if script.Login == nil && len(script.Actions) == 0 {
+		return nil, fmt.Errorf("nothing to do: give -script or at least one of -login, -move, -chat")
+	}
+	return script, script.validate()
+}
+
+func parseStep(s string) (msgID, reply uint32, err error) {
+	ids, err := parseMsgIDs(strings.Replace(s, ":", ",", 1))
+	if err != nil {
+		return 0, 0, err
+	}
+	switch len(ids) {
+	case 1:
+		return ids[0], 0, nil
+	case 2:
+		return ids[0], ids[1], nil
+	default:
+		return 0, 0, fmt.Errorf("step %q must be msgID[:replyMsgID]", s)
+	}
+}
+
+func parseMsgIDs(s string) ([]uint32, error) {
+	var ids []uint32
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(f, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid msgID %q", f)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "zinxbench: "+format+"\n", args...)
+	os.Exit(2)
+}
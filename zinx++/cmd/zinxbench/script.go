@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Step is one message a bot sends. When ReplyMsgID is set, the next message
+// with that ID received by the same bot completes the request and its
+// round-trip time is recorded under MsgID. Steps with different MsgIDs must
+// not share a ReplyMsgID.
+type Step struct {
+	Name       string  `json:"name"`
+	MsgID      uint32  `json:"msgId"`
+	ReplyMsgID uint32  `json:"replyMsgId"`
+	RatePerSec float64 `json:"ratePerSec"`
+	// Payload is sent as-is after replacing {bot} with the bot index. When
+	// empty, Size random bytes are sent instead.
+	Payload string `json:"payload"`
+	Size    int    `json:"size"`
+}
+
+// Script describes the behaviour of every bot: an optional login step sent
+// once per connection, then each action repeated at its own rate.
+type Script struct {
+	Login   *Step  `json:"login"`
+	Actions []Step `json:"actions"`
+}
+
+func loadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read script %s: %w", path, err)
+	}
+	script := &Script{}
+	if err := json.Unmarshal(data, script); err != nil {
+		return nil, fmt.Errorf("parse script %s: %w", path, err)
+	}
+	return script, script.validate()
+}
+
+func (s *Script) validate() error {
+	if s.Login == nil && len(s.Actions) == 0 {
+		return fmt.Errorf("script has neither a login step nor actions")
+	}
+	if s.Login != nil && s.Login.MsgID == 0 {
+		return fmt.Errorf("login step needs a msgId")
+	}
+	for i, a := range s.Actions {
+		if a.MsgID == 0 {
+			return fmt.Errorf("action %d (%s) needs a msgId", i, a.Name)
+		}
+		if a.RatePerSec <= 0 {
+			return fmt.Errorf("action %d (%s) needs a positive ratePerSec", i, a.Name)
+		}
+	}
+	// A reply carries no request ID, so it could not be told which of two
+	// request msgIDs it answers.
+	replies := make(map[uint32]Step)
+	for _, st := range s.steps() {
+		if st.ReplyMsgID == 0 {
+			continue
+		}
+		if prev, ok := replies[st.ReplyMsgID]; ok && prev.MsgID != st.MsgID {
+			return fmt.Errorf("%s and %s both wait for replyMsgId %d; give each request msgId its own reply",
+				prev.label(), st.label(), st.ReplyMsgID)
+		}
+		replies[st.ReplyMsgID] = st
+	}
+	return nil
+}
+
+// steps returns the login step, if any, followed by the actions.
+func (s *Script) steps() []Step {
+	var steps []Step
+	if s.Login != nil {
+		steps = append(steps, *s.Login)
+	}
+	return append(steps, s.Actions...)
+}
+
+// replyIDs maps each reply msgID to the request msgID waiting on it.
+func (s *Script) replyIDs() map[uint32]uint32 {
+	replies := make(map[uint32]uint32)
+	for _, st := range s.steps() {
+		if st.ReplyMsgID != 0 {
+			replies[st.ReplyMsgID] = st.MsgID
+		}
+	}
+	return replies
+}
+
+func (st *Step) payload(bot int, rnd *rand.Rand) []byte {
+	if st.Payload != "" {
+		return []byte(strings.ReplaceAll(st.Payload, "{bot}", strconv.Itoa(bot)))
+	}
+	data := make([]byte, st.Size)
+	rnd.Read(data)
+	return data
+}
+
+func (st *Step) label() string {
+	if st.Name != "" {
+		return fmt.Sprintf("%s(%d)", st.Name, st.MsgID)
+	}
+	return strconv.FormatUint(uint64(st.MsgID), 10)
+}
+
+func (s *Script) labels() map[uint32]string {
+	labels := make(map[uint32]string)
+	if s.Login != nil {
+		labels[s.Login.MsgID] = s.Login.label()
+	}
+	for i := range s.Actions {
+		labels[s.Actions[i].MsgID] = s.Actions[i].label()
+	}
+	return labels
+}
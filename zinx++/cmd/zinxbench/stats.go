@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type counters struct {
+	connected   atomic.Int64
+	dials       atomic.Uint64
+	dialErrors  atomic.Uint64
+	disconnects atomic.Uint64
+	sentMsgs    atomic.Uint64
+	sentBytes   atomic.Uint64
+	sendErrors  atomic.Uint64
+	recvMsgs    atomic.Uint64
+	recvBytes   atomic.Uint64
+	timeouts    atomic.Uint64
+}
+
+// latencies keeps raw samples per request msgID. Bots record into their own
+// instance and merge at the end, so the hot path takes no shared lock.
+type latencies struct {
+	samples map[uint32][]time.Duration
+	lock    sync.Mutex
+}
+
+func newLatencies() *latencies {
+	return &latencies{samples: make(map[uint32][]time.Duration)}
+}
+
+func (l *latencies) record(msgID uint32, d time.Duration) {
+	l.lock.Lock()
+	l.samples[msgID] = append(l.samples[msgID], d)
+	l.lock.Unlock()
+}
+
+func (l *latencies) merge(other *latencies) {
+	other.lock.Lock()
+	defer other.lock.Unlock()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for id, s := range other.samples {
+		l.samples[id] = append(l.samples[id], s...)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func (l *latencies) report(w io.Writer, labels map[uint32]string) {
+	ids := make([]uint32, 0, len(l.samples))
+	for id := range l.samples {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Fprintf(w, "%-16s %10s %10s %10s %10s %10s %10s\n", "msgID", "count", "p50", "p90", "p99", "p999", "max")
+	for _, id := range ids {
+		s := l.samples[id]
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		fmt.Fprintf(w, "%-16s %10d %10v %10v %10v %10v %10v\n", labels[id], len(s),
+			percentile(s, 0.50).Round(time.Microsecond),
+			percentile(s, 0.90).Round(time.Microsecond),
+			percentile(s, 0.99).Round(time.Microsecond),
+			percentile(s, 0.999).Round(time.Microsecond),
+			s[len(s)-1].Round(time.Microsecond))
+	}
+}
+
+type snapshot struct {
+	at                 time.Time
+	sentMsgs, recvMsgs uint64
+}
+
+func (c *counters) snapshot() snapshot {
+	return snapshot{at: time.Now(), sentMsgs: c.sentMsgs.Load(), recvMsgs: c.recvMsgs.Load()}
+}
+
+func (c *counters) progress(w io.Writer, prev snapshot) snapshot {
+	cur := c.snapshot()
+	secs := cur.at.Sub(prev.at).Seconds()
+	fmt.Fprintf(w, "[zinxbench] conns=%d sent/s=%.0f recv/s=%.0f sendErr=%d dialErr=%d disconnects=%d timeouts=%d\n",
+		c.connected.Load(),
+		float64(cur.sentMsgs-prev.sentMsgs)/secs,
+		float64(cur.recvMsgs-prev.recvMsgs)/secs,
+		c.sendErrors.Load(), c.dialErrors.Load(), c.disconnects.Load(), c.timeouts.Load())
+	return cur
+}
+
+func (c *counters) summary(w io.Writer, elapsed time.Duration) {
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "duration        %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "dials           %d (errors %d)\n", c.dials.Load(), c.dialErrors.Load())
+	fmt.Fprintf(w, "disconnects     %d\n", c.disconnects.Load())
+	fmt.Fprintf(w, "sent            %d msgs, %d bytes (%.0f msg/s, %.2f MB/s)\n",
+		c.sentMsgs.Load(), c.sentBytes.Load(), float64(c.sentMsgs.Load())/secs, float64(c.sentBytes.Load())/secs/1e6)
+	fmt.Fprintf(w, "received        %d msgs, %d bytes (%.0f msg/s, %.2f MB/s)\n",
+		c.recvMsgs.Load(), c.recvBytes.Load(), float64(c.recvMsgs.Load())/secs, float64(c.recvBytes.Load())/secs/1e6)
+	fmt.Fprintf(w, "send errors     %d\n", c.sendErrors.Load())
+	fmt.Fprintf(w, "reply timeouts  %d\n", c.timeouts.Load())
+}
@@ -0,0 +1,24 @@
+package main
+
+import "zinxplusplus/state"
+
+// registerSchemas declares the schemas this tool migrates to. Mirror the
+// registry the game servers pass to state.Codecs.UseSchemas, e.g.
+//
+//	reg.Define("player:", 3).
+//		Migration(1, func(doc map[string]interface{}) error {
+//			doc["gold"] = doc["coins"]
+//			delete(doc, "coins")
+//			return nil
+//		}).
+//		Migration(2, func(doc map[string]interface{}) error {
+//			if _, ok := doc["bag"]; !ok {
+//				doc["bag"] = map[string]interface{}{}
+//			}
+//			return nil
+//		})
+//
+// With no schemas the tool can still move objects to another serializer
+// (-to) and add codec headers to objects written before they existed.
+func registerSchemas(reg *state.SchemaRegistry) {
+}
@@ -0,0 +1,211 @@
+// Command zinxreplay inspects packet recordings made with
+// znet.WithPacketRecording or IConnection.StartRecording and plays the
+// client's side of a recording back against a live server.
+//
+//	zinxreplay -file conn-42-1700000000.zrec -print
+//	zinxreplay -file conn-42-1700000000.zrec -addr 127.0.0.1:8999 -speed 1
+//
+// In replay mode the frames the server originally sent are counted per msgID
+// and compared with what the server sends back this time. To replay into a
+// MsgHandle in-process use znet.ReplayToMsgHandle from a test.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+type counter struct {
+	znet.BaseRouter
+	counts map[uint32]int
+	lock   *sync.Mutex
+	print  bool
+}
+
+func (c *counter) Handle(request ziface.IRequest) {
+	c.lock.Lock()
+	c.counts[request.GetMsgID()]++
+	c.lock.Unlock()
+	if c.print {
+		fmt.Fprintf(os.Stderr, "recv msgID=%d len=%d\n", request.GetMsgID(), len(request.GetData()))
+	}
+}
+
+func main() {
+	file := flag.String("file", "", "recording to read")
+	printOnly := flag.Bool("print", false, "dump the recording instead of replaying it")
+	dumpBytes := flag.Int("bytes", 32, "payload bytes shown per frame with -print")
+	network := flag.String("network", "tcp", "tcp, tcp4, tcp6 or unix")
+	addr := flag.String("addr", "", "server to replay against")
+	speed := flag.Float64("speed", 1, "pacing factor, 0 sends back to back")
+	settle := flag.Duration("settle", 2*time.Second, "time to wait for replies after the last frame")
+	verbose := flag.Bool("v", false, "print every received frame")
+	maxRecord := flag.Uint("max-record", uint(znet.DefaultMaxOutboundRecordLen), "largest frame body read from the recording, in either direction; 0 for no limit")
+	maxPacket := flag.Uint("max-packet", 4096, "largest message body accepted from the server while replaying, raised to fit the recorded replies")
+	flag.Parse()
+
+	if *file == "" {
+		fatalf("-file is required")
+	}
+	src := recording{path: *file, maxLen: uint32(*maxRecord)}
+
+	if *printOnly {
+		if err := dump(src, *dumpBytes); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if *addr == "" {
+		fatalf("-addr is required unless -print is given")
+	}
+	if err := replay(src, *network, *addr, uint32(*maxPacket), *speed, *settle, *verbose); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// recording opens one file with the same frame size limit every time. The
+// limit is not taken from the global config: the recording may come from a
+// server with a larger MaxPacketSize than this tool knows about.
+type recording struct {
+	path   string
+	maxLen uint32
+}
+
+func (r recording) open() (*znet.PacketReader, error) {
+	pr, err := znet.OpenPacketReader(r.path)
+	if err != nil {
+		return nil, err
+	}
+	pr.MaxDataLen = r.maxLen
+	pr.MaxOutboundDataLen = r.maxLen
+	return pr, nil
+}
+
+func dump(src recording, maxBytes int) error {
+	pr, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	fmt.Printf("connID=%d remote=%s started=%s\n", pr.Header.ConnID, pr.Header.RemoteAddr, pr.Header.StartedAt.Format(time.RFC3339Nano))
+	for {
+		rec, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dir := "<-"
+		if rec.Direction == znet.RecordOutbound {
+			dir = "->"
+		}
+		shown := rec.Data
+		if len(shown) > maxBytes {
+			shown = shown[:maxBytes]
+		}
+		fmt.Printf("%12s %s msgID=%-6d len=%-6d %s\n",
+			rec.Time.Sub(pr.Header.StartedAt).Round(time.Microsecond), dir, rec.MsgID, len(rec.Data), hex.EncodeToString(shown))
+	}
+}
+
+// expectedReplies counts the frames the server sent in the original session
+// and returns the largest of their bodies.
+func expectedReplies(src recording) (map[uint32]int, uint32, error) {
+	pr, err := src.open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer pr.Close()
+
+	counts := make(map[uint32]int)
+	var largest uint32
+	for {
+		rec, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return counts, largest, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if rec.Direction == znet.RecordOutbound {
+			counts[rec.MsgID]++
+			largest = max(largest, uint32(len(rec.Data)))
+		}
+	}
+}
+
+func replay(src recording, network, addr string, maxPacket uint32, speed float64, settle time.Duration, verbose bool) error {
+	expected, largest, err := expectedReplies(src)
+	if err != nil {
+		return err
+	}
+	// The server sends without a size limit; let the client take back
+	// whatever it sent the first time.
+	maxPacket = max(maxPacket, largest)
+
+	got := &counter{counts: make(map[uint32]int), lock: &sync.Mutex{}, print: verbose}
+	client := znet.NewClient(network, addr,
+		znet.WithReconnect(0, 0),
+		znet.WithClientDataPack(znet.NewDataPackWithLimit(maxPacket)),
+	)
+	for msgID := range expected {
+		client.AddRouter(msgID, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.Dial(ctx); err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	pr, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	start := time.Now()
+	sent, err := znet.ReplayToConn(ctx, pr, client.Conn(), znet.ReplayOptions{Speed: speed, Direction: znet.RecordInbound})
+	if err != nil {
+		return fmt.Errorf("replay stopped after %d frames: %w", sent, err)
+	}
+	time.Sleep(settle)
+
+	fmt.Fprintf(os.Stderr, "replayed %d frames in %v\n", sent, time.Since(start).Round(time.Millisecond))
+	ids := make([]uint32, 0, len(expected))
+	for id := range expected {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	got.lock.Lock()
+	defer got.lock.Unlock()
+	fmt.Fprintf(os.Stderr, "%-8s %10s %10s\n", "msgID", "recorded", "replayed")
+	for _, id := range ids {
+		mark := ""
+		if expected[id] != got.counts[id] {
+			mark = "  *"
+		}
+		fmt.Fprintf(os.Stderr, "%-8d %10d %10d%s\n", id, expected[id], got.counts[id], mark)
+	}
+	return nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "zinxreplay: "+format+"\n", args...)
+	os.Exit(2)
+}
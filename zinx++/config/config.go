@@ -1,20 +1,29 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"zinxplusplus/state"
 )
 
+// GlobalConfig holds the global config as set at startup, for code written
+// before the config could be reloaded. SetGlobal and LoadConfig update it;
+// reloads do not, so reading it never races with a running server.
+//
+// Deprecated: it misses hot reloads; use Global.
 var GlobalConfig *Config
 
 const DefaultConfigPath = "conf/zinxplusplus.json"
 
 func init() {
+	GlobalConfig = global.Load()
+}
 
-	GlobalConfig = &Config{
+// Defaults returns a fresh copy of the built-in configuration, the lowest
+// layer of every Loader.
+func Defaults() *Config {
+	return &Config{
 		Server: ServerConfig{
 			Name:                   "ZinxPlusServer-Default",
 			IPVersion:              "tcp4",
@@ -28,10 +37,32 @@ func init() {
 			IdleTimeoutMs:          600000,
 			SendMsgTimeoutMs:       3000,
 			SendTaskQueueTimeoutMs: 100,
-			MaxMsgChanLen:          1,
-			MaxMsgBuffChanLen:      1024,
+			MaxOutQueueBytes:       1 << 20,
+			SlowConsumerPolicy:     "drop-lowest",
+			MaxWriteBatchBytes:     64 * 1024,
+			WriteFlushDelayMs:      0,
 			NetpollNumLoops:        0,
 			NetpollLoadBalance:     "round-robin",
+			SessionPolicy:          "kick-old",
+			SessionKickMsgID:       0,
+			SessionKickNotice:      "logged in from another location",
+			SessionResumeGraceMs:   0,
+			SessionReplayBufferLen: 256,
+			DispatchMode:           "conn-id",
+			TickIntervalMs:         50,
+			MaxCatchUpTicks:        5,
+			AuthTimeoutMs:          10000,
+			ProxyHeaderTimeoutMs:   5000,
+		},
+		Admission: AdmissionConfig{
+			AllowCIDRs:            nil,
+			DenyCIDRs:             nil,
+			MaxConnPerIP:          0,
+			ConnRatePerIP:         0,
+			ConnBurstPerIP:        0,
+			ViolationsBeforeBan:   3,
+			BanDurationMs:         600000,
+			AuthFailuresBeforeBan: 0,
 		},
 		Log: LogConfig{
 			Level:      "debug",
@@ -45,10 +76,15 @@ func init() {
 		State: StateConfig{
 			Adapter: "memory",
 			Redis: state.RedisConfig{
-				Addr:     "localhost:6379",
-				Password: "",
-				DB:       0,
-				PoolSize: 10,
+				Mode:           state.RedisModeStandalone,
+				Addr:           "localhost:6379",
+				Password:       "",
+				DB:             0,
+				PoolSize:       10,
+				DialTimeoutMs:  5000,
+				ReadTimeoutMs:  3000,
+				WriteTimeoutMs: 3000,
+				MaxRetries:     3,
 			},
 		},
 		AOI: AOIConfig{
@@ -70,6 +106,12 @@ func init() {
 	}
 }
 
+// DefaultListenerName names the i-th listener when none was given, so
+// listeners can be matched between reloads and told apart in logs.
+func DefaultListenerName(i int) string {
+	return fmt.Sprintf("listener-%d", i)
+}
+
 func InitGlobalConfig(configFilePath string) error {
 	if configFilePath == "" {
 		configFilePath = DefaultConfigPath
@@ -88,28 +130,18 @@ func LoadConfig(filePath string) error {
 	}
 	fmt.Printf("[Config] Absolute config path: %s\n", absPath)
 
-	data, err := os.ReadFile(absPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Printf("[Config Warning] Config file '%s' not found. Using default configuration set during init.\n", absPath)
-
-			if GlobalConfig == nil {
-
-				panic("GlobalConfig is nil after init, cannot proceed without default config.")
-			}
-			return nil
-		}
-		return fmt.Errorf("error reading config file '%s': %w", absPath, err)
+	loader := NewLoader(absPath)
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
+		fmt.Printf("[Config Warning] Config file '%s' not found. Using default configuration set during init.\n", absPath)
+		loader.File = ""
 	}
 
-	configHolder := *GlobalConfig
-
-	err = json.Unmarshal(data, &configHolder)
+	cfg, err := loader.LoadOnto(Global())
 	if err != nil {
-		return fmt.Errorf("error unmarshalling config file '%s': %w", absPath, err)
+		return err
 	}
-
-	GlobalConfig = &configHolder
+	SetGlobal(cfg)
+	setDefaultLoader(loader)
 
 	fmt.Printf("[Config] Config loaded successfully from '%s'.\n", absPath)
 	return nil
@@ -0,0 +1,303 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultEnvPrefix = "ZINX_"
+
+var ErrUnknownFormat = errors.New("unknown config file format")
+
+// Loader builds a Config from layered sources. Later layers win:
+//
+//	base (Defaults or the server's options) < file < environment < flags
+//
+// The file may be JSON, YAML or TOML and uses the same keys as the json tags.
+// Environment variables are named after the key path, e.g.
+// ZINX_SERVER_MAX_CONN or ZINX_STATE_REDIS_POOL_SIZE; flags use
+// -server.max-conn style names. Lists take comma separated values, except
+// lists of objects such as server.listeners which take JSON.
+type Loader struct {
+	// File is optional. A file that was named but does not exist is an error.
+	File string
+	// EnvPrefix enables the environment layer; empty disables it.
+	EnvPrefix string
+
+	flagValues map[string]string
+	lock       sync.Mutex
+}
+
+func NewLoader(file string) *Loader {
+	return &Loader{File: file, EnvPrefix: DefaultEnvPrefix}
+}
+
+var (
+	defaultLoader     *Loader
+	defaultLoaderLock sync.Mutex
+)
+
+// DefaultLoader returns the loader set up by InitGlobalConfig/LoadConfig, or
+// nil when neither was called. NewServer uses it when no loader is given, so
+// a loaded file is not silently overridden by options.
+func DefaultLoader() *Loader {
+	defaultLoaderLock.Lock()
+	defer defaultLoaderLock.Unlock()
+	return defaultLoader
+}
+
+func setDefaultLoader(l *Loader) {
+	defaultLoaderLock.Lock()
+	defer defaultLoaderLock.Unlock()
+	defaultLoader = l
+}
+
+// BindFlags registers -config and one flag per config field on fs. Only flags
+// given on the command line take part in Load.
+func (l *Loader) BindFlags(fs *flag.FlagSet) {
+	fs.Func("config", "config file (JSON, YAML or TOML)", func(path string) error {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		l.File = path
+		return nil
+	})
+
+	defaults := reflect.ValueOf(Defaults()).Elem()
+	walkFields(defaults, nil, func(path []string, field reflect.Value) {
+		key := strings.Join(path, ".")
+		name := flagName(path)
+		fs.Func(name, fmt.Sprintf("%s (default %s)", key, formatField(field)), func(raw string) error {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			if l.flagValues == nil {
+				l.flagValues = make(map[string]string)
+			}
+			l.flagValues[key] = raw
+			return nil
+		})
+	})
+}
+
+// Load layers the sources over Defaults.
+func (l *Loader) Load() (*Config, error) {
+	return l.LoadOnto(Defaults())
+}
+
+// LoadOnto layers the sources over a copy of base and validates the result.
+// All problems found are reported together; on error no Config is returned.
+func (l *Loader) LoadOnto(base *Config) (*Config, error) {
+	l.lock.Lock()
+	file, prefix := l.File, l.EnvPrefix
+	flagValues := make(map[string]string, len(l.flagValues))
+	for k, v := range l.flagValues {
+		flagValues[k] = v
+	}
+	l.lock.Unlock()
+
+	cfg := base.Clone()
+	if file != "" {
+		if err := decodeFile(file, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	root := reflect.ValueOf(cfg).Elem()
+	if prefix != "" {
+		walkFields(root, nil, func(path []string, field reflect.Value) {
+			name := envName(prefix, path)
+			if raw, ok := os.LookupEnv(name); ok {
+				if err := setField(field, raw); err != nil {
+					errs = append(errs, fmt.Errorf("%w: env %s: %v", ErrInvalidConfig, name, err))
+				}
+			}
+		})
+	}
+	if len(flagValues) > 0 {
+		walkFields(root, nil, func(path []string, field reflect.Value) {
+			if raw, ok := flagValues[strings.Join(path, ".")]; ok {
+				if err := setField(field, raw); err != nil {
+					errs = append(errs, fmt.Errorf("%w: flag -%s: %v", ErrInvalidConfig, flagName(path), err))
+				}
+			}
+		})
+	}
+
+	if err := Validate(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeFile decodes path over cfg, so keys missing from the file keep the
+// values already in cfg. YAML and TOML go through a generic map and JSON so
+// all formats share the json tags.
+func decodeFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file '%s': %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", "":
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("error parsing config file '%s': %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("error converting config file '%s': %w", path, err)
+		}
+	case ".toml":
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("error parsing config file '%s': %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("error converting config file '%s': %w", path, err)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, path)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("error unmarshalling config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// walkFields calls fn for every leaf field below v; path holds the json keys.
+func walkFields(v reflect.Value, path []string, fn func(path []string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+		if sf.Type.Kind() == reflect.Struct {
+			walkFields(v.Field(i), fieldPath, fn)
+			continue
+		}
+		fn(fieldPath, v.Field(i))
+	}
+}
+
+func setField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(raw, "[") {
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+			return err
+		}
+		field.Set(ptr.Elem())
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+func formatField(field reflect.Value) string {
+	if field.Kind() == reflect.Slice {
+		if field.Type().Elem().Kind() != reflect.String {
+			data, _ := json.Marshal(field.Interface())
+			return string(data)
+		}
+		items := make([]string, field.Len())
+		for i := range items {
+			items[i] = field.Index(i).String()
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// splitWords turns "maxConnPerIp" or "PoolSize" into {"max","conn","per","ip"}
+// and {"pool","size"}.
+func splitWords(key string) []string {
+	var words []string
+	start := 0
+	runes := []rune(key)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1]) {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(string(runes[start:])))
+}
+
+func envName(prefix string, path []string) string {
+	parts := make([]string, 0, len(path))
+	for _, p := range path {
+		parts = append(parts, strings.ToUpper(strings.Join(splitWords(p), "_")))
+	}
+	return prefix + strings.Join(parts, "_")
+}
+
+func flagName(path []string) string {
+	parts := make([]string, 0, len(path))
+	for _, p := range path {
+		parts = append(parts, strings.Join(splitWords(p), "-"))
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testEnvPrefix = "ZINXTEST_"
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+// TestLoaderPrecedence sets name on every layer, maxConn on all but flags,
+// workerPoolSize on base and file only and maxPacketSize on base only, so each
+// field shows which layer won.
+func TestLoaderPrecedence(t *testing.T) {
+	base := Defaults()
+	base.Server.Name = "base"
+	base.Server.MaxConn = 10
+	base.Server.WorkerPoolSize = 2
+	base.Server.MaxPacketSize = 512
+
+	file := writeFile(t, "zinx.json", `{"server": {"name": "file", "maxConn": 20, "workerPoolSize": 3}}`)
+	t.Setenv(testEnvPrefix+"SERVER_NAME", "env")
+	t.Setenv(testEnvPrefix+"SERVER_MAX_CONN", "30")
+
+	type want struct {
+		name          string
+		maxConn       int
+		workers       uint32
+		maxPacketSize uint32
+	}
+	for _, tc := range []struct {
+		layers string
+		file   string
+		env    string
+		flags  []string
+		want   want
+	}{
+		{layers: "base", want: want{"base", 10, 2, 512}},
+		{layers: "base < file", file: file, want: want{"file", 20, 3, 512}},
+		{layers: "base < file < env", file: file, env: testEnvPrefix, want: want{"env", 30, 3, 512}},
+		{layers: "base < file < env < flags", file: file, env: testEnvPrefix, flags: []string{"-server.name", "flag"},
+			want: want{"flag", 30, 3, 512}},
+		{layers: "base < flags", flags: []string{"-server.name=flag", "-server.max-conn", "40"},
+			want: want{"flag", 40, 2, 512}},
+	} {
+		t.Run(tc.layers, func(t *testing.T) {
+			l := &Loader{File: tc.file, EnvPrefix: tc.env}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			l.BindFlags(fs)
+			if err := fs.Parse(tc.flags); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			cfg, err := l.LoadOnto(base)
+			if err != nil {
+				t.Fatalf("LoadOnto: %v", err)
+			}
+			got := want{cfg.Server.Name, cfg.Server.MaxConn, cfg.Server.WorkerPoolSize, cfg.Server.MaxPacketSize}
+			if got != tc.want {
+				t.Fatalf("server = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	if base.Server.Name != "base" || base.Server.MaxConn != 10 {
+		t.Fatalf("LoadOnto modified its base: %+v", base.Server)
+	}
+}
+
+func TestLoaderDefaults(t *testing.T) {
+	cfg, err := (&Loader{}).Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := Defaults(); cfg.Server.Name != want.Server.Name || cfg.Server.MaxConn != want.Server.MaxConn {
+		t.Fatalf("Load without sources = %+v, want the defaults", cfg.Server)
+	}
+}
+
+func TestLoaderFileFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"zinx.json": `{"server": {"maxConn": 77, "trustedProxyCidrs": ["10.0.0.0/8"]}, "log": {"level": "warn"}}`,
+		"zinx.yaml": "server:\n  maxConn: 77\n  trustedProxyCidrs: [10.0.0.0/8]\nlog:\n  level: warn\n",
+		"zinx.toml": "[server]\nmaxConn = 77\ntrustedProxyCidrs = [\"10.0.0.0/8\"]\n[log]\nlevel = \"warn\"\n",
+	} {
+		t.Run(filepath.Ext(name), func(t *testing.T) {
+			cfg, err := NewLoader(writeFile(t, name, content)).Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Server.MaxConn != 77 || cfg.Log.Level != "warn" ||
+				len(cfg.Server.TrustedProxyCIDRs) != 1 || cfg.Server.TrustedProxyCIDRs[0] != "10.0.0.0/8" {
+				t.Fatalf("decoded maxConn=%d level=%q cidrs=%v", cfg.Server.MaxConn, cfg.Log.Level, cfg.Server.TrustedProxyCIDRs)
+			}
+			// Keys missing from the file keep their defaults.
+			if cfg.Server.WorkerPoolSize != Defaults().Server.WorkerPoolSize {
+				t.Fatalf("workerPoolSize = %d, want the default", cfg.Server.WorkerPoolSize)
+			}
+		})
+	}
+}
+
+func TestLoaderListValues(t *testing.T) {
+	t.Setenv(testEnvPrefix+"ADMISSION_DENY_CIDRS", "10.0.0.0/8, 192.0.2.0/24")
+	t.Setenv(testEnvPrefix+"SERVER_LISTENERS", `[{"name": "ws", "network": "tcp", "address": ":9000"}]`)
+
+	cfg, err := (&Loader{EnvPrefix: testEnvPrefix}).Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := strings.Join(cfg.Admission.DenyCIDRs, ","); got != "10.0.0.0/8,192.0.2.0/24" {
+		t.Fatalf("admission.denyCidrs = %q", got)
+	}
+	if len(cfg.Server.Listeners) != 1 || cfg.Server.Listeners[0].Address != ":9000" {
+		t.Fatalf("server.listeners = %+v", cfg.Server.Listeners)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewLoader(filepath.Join(dir, "missing.json")).Load(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load of a missing file = %v, want ErrNotExist", err)
+	}
+	if _, err := NewLoader(writeFile(t, "zinx.ini", "maxConn=1")).Load(); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("Load of an .ini file = %v, want ErrUnknownFormat", err)
+	}
+	if _, err := NewLoader(writeFile(t, "zinx.json", `{"server": `)).Load(); err == nil {
+		t.Fatal("Load accepted a truncated file")
+	}
+
+	// Bad values from every layer are reported together.
+	t.Setenv(testEnvPrefix+"SERVER_MAX_CONN", "many")
+	l := &Loader{File: writeFile(t, "bad.json", `{"server": {"port": 70000}}`), EnvPrefix: testEnvPrefix}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l.BindFlags(fs)
+	if err := fs.Parse([]string{"-server.worker-pool-size", "-1"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	cfg, err := l.Load()
+	if cfg != nil || !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Load = %v, %v; want ErrInvalidConfig", cfg, err)
+	}
+	for _, part := range []string{"ZINXTEST_SERVER_MAX_CONN", "-server.worker-pool-size", "server.port 70000"} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error does not mention %s: %v", part, err)
+		}
+	}
+}
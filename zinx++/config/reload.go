@@ -0,0 +1,221 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restartRequired lists fields that are only read while the server starts.
+// A reload that changes one keeps the running value and reports the field in
+// ChangeSet.RestartRequired. An entry without a dot covers a whole section.
+var restartRequired = map[string]bool{
+	"server.ipVersion":              true,
+	"server.ip":                     true,
+	"server.port":                   true,
+	"server.workerPoolSize":         true,
+	"server.maxWorkerTaskLen":       true,
+	"server.netpollNumLoops":        true,
+	"server.netpollLoadBalance":     true,
+	"server.sessionPolicy":          true,
+	"server.sessionKickMsgId":       true,
+	"server.sessionKickNotice":      true,
+	"server.sessionResumeGraceMs":   true,
+	"server.sessionReplayBufferLen": true,
+	"server.dispatchMode":           true,
+	"server.tickIntervalMs":         true,
+	"server.maxCatchUpTicks":        true,
+	"server.authTimeoutMs":          true,
+	"server.proxyProtocol":          true,
+	"server.trustedProxyCidrs":      true,
+	"server.listeners":              true,
+	"server.recordDir":              true,
+	"state":                         true,
+	"scripting":                     true,
+	"aoi.minX":                      true,
+	"aoi.maxX":                      true,
+	"aoi.minZ":                      true,
+	"aoi.maxZ":                      true,
+	"aoi.cntsX":                     true,
+	"aoi.cntsZ":                     true,
+	"aoi.capacity":                  true,
+	"aoi.maxDepth":                  true,
+}
+
+func isRestartRequired(path string) bool {
+	if restartRequired[path] {
+		return true
+	}
+	section, _, _ := strings.Cut(path, ".")
+	return restartRequired[section]
+}
+
+// ChangeSet describes one applied reload. Changed and RestartRequired hold
+// "section.field" paths named after the json keys.
+type ChangeSet struct {
+	Old *Config
+	New *Config
+
+	Changed         []string
+	RestartRequired []string
+}
+
+// SectionChanged reports whether any live field of section ("server",
+// "admission", ...) changed.
+func (cs *ChangeSet) SectionChanged(section string) bool {
+	for _, path := range cs.Changed {
+		if path == section || strings.HasPrefix(path, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy, so decoding into it never touches the slices of
+// the original.
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Server.TrustedProxyCIDRs = append([]string(nil), c.Server.TrustedProxyCIDRs...)
+	clone.Server.Listeners = append([]ListenerConfig(nil), c.Server.Listeners...)
+	clone.Admission.AllowCIDRs = append([]string(nil), c.Admission.AllowCIDRs...)
+	clone.Admission.DenyCIDRs = append([]string(nil), c.Admission.DenyCIDRs...)
+	clone.State.Redis.Addrs = append([]string(nil), c.State.Redis.Addrs...)
+	return &clone
+}
+
+// diffConfig walks sections and their fields. Restart-only fields that differ
+// are copied back from old into next.
+func diffConfig(old, next reflect.Value, prefix string, cs *ChangeSet) {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		of, nf := old.Field(i), next.Field(i)
+		if prefix == "" && of.Kind() == reflect.Struct {
+			diffConfig(of, nf, path, cs)
+			continue
+		}
+		if reflect.DeepEqual(of.Interface(), nf.Interface()) {
+			continue
+		}
+		if isRestartRequired(path) {
+			cs.RestartRequired = append(cs.RestartRequired, path)
+			nf.Set(of)
+			continue
+		}
+		cs.Changed = append(cs.Changed, path)
+	}
+}
+
+// Watcher re-runs a Loader when its file's size or modification time changes
+// and applies the result. Every reload starts from the same base, so the
+// environment and flag layers keep overriding the file and values removed
+// from the file fall back to the base. Polling keeps it free of platform
+// specific notification APIs; Reload can also be called directly, e.g. on
+// SIGHUP.
+type Watcher struct {
+	store    *Store
+	loader   *Loader
+	base     *Config
+	interval time.Duration
+
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewWatcher watches loader.File and applies reloads to the global store.
+// base is the layer below the file, nil meaning Defaults.
+func NewWatcher(loader *Loader, base *Config, interval time.Duration) *Watcher {
+	return global.Watch(loader, base, interval)
+}
+
+// Watch is NewWatcher for this store.
+func (s *Store) Watch(loader *Loader, base *Config, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	if base == nil {
+		base = Defaults()
+	}
+	w := &Watcher{
+		store:    s,
+		loader:   loader,
+		base:     base.Clone(),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if fi, err := os.Stat(loader.File); err == nil {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+	}
+	return w
+}
+
+func (w *Watcher) Path() string {
+	return w.loader.File
+}
+
+// Start polls the file until Stop is called.
+func (w *Watcher) Start() {
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.poll()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watcher) poll() {
+	fi, err := os.Stat(w.loader.File)
+	if err != nil {
+		return
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	if _, err := w.Reload(); err != nil {
+		fmt.Printf("[Config] Reload of '%s' rejected: %v\n", w.loader.File, err)
+	}
+}
+
+// Reload loads and applies the layers now. On error the active config is left
+// unchanged.
+func (w *Watcher) Reload() (*ChangeSet, error) {
+	next, err := w.loader.LoadOnto(w.base)
+	if err != nil {
+		return nil, err
+	}
+	return w.store.Apply(next)
+}
+
+func (w *Watcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	select {
+	case <-w.done:
+	case <-time.After(w.interval):
+	}
+}
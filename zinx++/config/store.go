@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds one active Config that can be swapped atomically and notifies
+// subscribers when it changes. Each Server owns a Store so several servers in
+// one process keep separate settings; the package level functions operate on
+// the global store, whose config Global returns.
+type Store struct {
+	cur atomic.Pointer[Config]
+
+	subscribers []subscriber
+	nextSubID   uint64
+	subLock     sync.Mutex
+	applyLock   sync.Mutex
+}
+
+type subscriber struct {
+	id     uint64
+	notify func(cs *ChangeSet)
+}
+
+var global = NewStore(Defaults())
+
+func NewStore(cfg *Config) *Store {
+	s := &Store{}
+	s.cur.Store(cfg)
+	return s
+}
+
+// GlobalStore returns the store used by code that has no server of its own.
+func GlobalStore() *Store {
+	return global
+}
+
+// Load returns the active config. It must be treated as read-only.
+func (s *Store) Load() *Config {
+	return s.cur.Load()
+}
+
+// Global returns the active global config. It must be treated as read-only;
+// a reload swaps in a new value instead of changing it.
+func Global() *Config {
+	return global.Load()
+}
+
+// SetGlobal replaces the global config without validation or notifying
+// subscribers. It is meant for tools setting limits before anything runs,
+// which is also why it may update the deprecated GlobalConfig; use Apply for
+// live changes.
+func SetGlobal(c *Config) {
+	global.applyLock.Lock()
+	defer global.applyLock.Unlock()
+	global.cur.Store(c)
+	GlobalConfig = c
+}
+
+// OnReload registers fn for every applied reload. The returned func removes
+// the subscription.
+func (s *Store) OnReload(fn func(cs *ChangeSet)) (unsubscribe func()) {
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+	s.nextSubID++
+	id := s.nextSubID
+	s.subscribers = append(s.subscribers, subscriber{id: id, notify: fn})
+	return func() {
+		s.subLock.Lock()
+		defer s.subLock.Unlock()
+		for i, sub := range s.subscribers {
+			if sub.id == id {
+				s.subscribers = append(s.subscribers[:i:i], s.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (s *Store) onSection(section string, fn func(old, new *Config)) func() {
+	return s.OnReload(func(cs *ChangeSet) {
+		if cs.SectionChanged(section) {
+			fn(cs.Old, cs.New)
+		}
+	})
+}
+
+// OnServerChange calls fn when a live server field changed.
+func (s *Store) OnServerChange(fn func(old, new ServerConfig)) (unsubscribe func()) {
+	return s.onSection("server", func(old, new *Config) { fn(old.Server, new.Server) })
+}
+
+func (s *Store) OnAdmissionChange(fn func(old, new AdmissionConfig)) (unsubscribe func()) {
+	return s.onSection("admission", func(old, new *Config) { fn(old.Admission, new.Admission) })
+}
+
+func (s *Store) OnLogChange(fn func(old, new LogConfig)) (unsubscribe func()) {
+	return s.onSection("log", func(old, new *Config) { fn(old.Log, new.Log) })
+}
+
+func (s *Store) OnAOIChange(fn func(old, new AOIConfig)) (unsubscribe func()) {
+	return s.onSection("aoi", func(old, new *Config) { fn(old.AOI, new.AOI) })
+}
+
+// Apply validates next, keeps the running value of every restart-only field,
+// swaps it in as the active config and notifies subscribers. next must not
+// be modified afterwards.
+func (s *Store) Apply(next *Config) (*ChangeSet, error) {
+	if err := Validate(next); err != nil {
+		return nil, err
+	}
+
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+
+	old := s.Load()
+	cs := &ChangeSet{Old: old, New: next}
+	diffConfig(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), "", cs)
+	if len(cs.RestartRequired) > 0 {
+		fmt.Printf("[Config] Changes to %s need a restart and were not applied.\n", strings.Join(cs.RestartRequired, ", "))
+	}
+	if len(cs.Changed) == 0 {
+		cs.New = old
+		return cs, nil
+	}
+	s.cur.Store(next)
+	fmt.Printf("[Config] Applied changes: %s\n", strings.Join(cs.Changed, ", "))
+
+	s.subLock.Lock()
+	subs := append([]subscriber(nil), s.subscribers...)
+	s.subLock.Unlock()
+	for _, sub := range subs {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Printf("[Config] Reload subscriber panic: %v\n", err)
+				}
+			}()
+			sub.notify(cs)
+		}()
+	}
+	return cs, nil
+}
+
+func Apply(next *Config) (*ChangeSet, error) {
+	return global.Apply(next)
+}
+
+func OnReload(fn func(cs *ChangeSet)) (unsubscribe func()) {
+	return global.OnReload(fn)
+}
+
+func OnServerChange(fn func(old, new ServerConfig)) (unsubscribe func()) {
+	return global.OnServerChange(fn)
+}
+
+func OnAdmissionChange(fn func(old, new AdmissionConfig)) (unsubscribe func()) {
+	return global.OnAdmissionChange(fn)
+}
+
+func OnLogChange(fn func(old, new LogConfig)) (unsubscribe func()) {
+	return global.OnLogChange(fn)
+}
+
+func OnAOIChange(fn func(old, new AOIConfig)) (unsubscribe func()) {
+	return global.OnAOIChange(fn)
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestGlobalSwap reads the global config while it is replaced; run with -race.
+func TestGlobalSwap(t *testing.T) {
+	orig := Global()
+	defer SetGlobal(orig)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if Global().Server.MaxPacketSize == 0 {
+					t.Error("read a zero MaxPacketSize")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		next := Global().Clone()
+		next.Server.MaxPacketSize = uint32(1024 + i)
+		if i%2 == 0 {
+			SetGlobal(next)
+		} else if _, err := Apply(next); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := Global().Server.MaxPacketSize; got != 1024+199 {
+		t.Fatalf("MaxPacketSize = %d, want %d", got, 1024+199)
+	}
+	if GlobalStore().Load() != Global() {
+		t.Fatal("Global and GlobalStore disagree")
+	}
+}
+
+func TestGlobalConfigSetAtStartup(t *testing.T) {
+	orig := Global()
+	defer SetGlobal(orig)
+
+	if GlobalConfig != Global() {
+		t.Fatal("GlobalConfig does not start out as the global config")
+	}
+
+	next := Global().Clone()
+	next.Server.Name = "set"
+	SetGlobal(next)
+	if GlobalConfig != next {
+		t.Fatal("SetGlobal did not update GlobalConfig")
+	}
+
+	// Reloads leave it alone, so legacy readers do not race with them; run
+	// with -race.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if GlobalConfig.Server.Name != "set" {
+				t.Error("GlobalConfig changed during a reload")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		reloaded := Global().Clone()
+		reloaded.Server.MaxPacketSize++
+		if _, err := Apply(reloaded); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if GlobalConfig != next || Global() == next {
+		t.Fatal("a reload updated GlobalConfig, or did not update Global")
+	}
+}
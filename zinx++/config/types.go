@@ -6,6 +6,7 @@ import (
 
 type Config struct {
 	Server    ServerConfig    `json:"server"`
+	Admission AdmissionConfig `json:"admission"`
 	Log       LogConfig       `json:"log"`
 	State     StateConfig     `json:"state"`
 	AOI       AOIConfig       `json:"aoi"`
@@ -13,23 +14,65 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	Name                   string `json:"name"`
-	IPVersion              string `json:"ipVersion"`
-	IP                     string `json:"ip"`
-	Port                   int    `json:"port"`
-	MaxConn                int    `json:"maxConn"`
-	MaxPacketSize          uint32 `json:"maxPacketSize"`
-	WorkerPoolSize         uint32 `json:"workerPoolSize"`
-	MaxWorkerTaskLen       uint32 `json:"maxWorkerTaskLen"`
-	ReadTimeoutMs          int    `json:"readTimeoutMs"`
-	WriteTimeoutMs         int    `json:"writeTimeoutMs"`
-	IdleTimeoutMs          int    `json:"idleTimeoutMs"`
-	SendMsgTimeoutMs       int    `json:"sendMsgTimeoutMs"`
-	SendTaskQueueTimeoutMs int    `json:"sendTaskQueueTimeoutMs"`
-	MaxMsgChanLen          uint32 `json:"maxMsgChanLen"`
-	MaxMsgBuffChanLen      uint32 `json:"maxMsgBuffChanLen"`
-	NetpollNumLoops        int    `json:"netpollNumLoops"`
-	NetpollLoadBalance     string `json:"netpollLoadBalance"`
+	Name                   string           `json:"name"`
+	IPVersion              string           `json:"ipVersion"`
+	IP                     string           `json:"ip"`
+	Port                   int              `json:"port"`
+	MaxConn                int              `json:"maxConn"`
+	MaxPacketSize          uint32           `json:"maxPacketSize"`
+	WorkerPoolSize         uint32           `json:"workerPoolSize"`
+	MaxWorkerTaskLen       uint32           `json:"maxWorkerTaskLen"`
+	ReadTimeoutMs          int              `json:"readTimeoutMs"`
+	WriteTimeoutMs         int              `json:"writeTimeoutMs"`
+	IdleTimeoutMs          int              `json:"idleTimeoutMs"`
+	SendMsgTimeoutMs       int              `json:"sendMsgTimeoutMs"`
+	SendTaskQueueTimeoutMs int              `json:"sendTaskQueueTimeoutMs"`
+	MaxOutQueueBytes       int              `json:"maxOutQueueBytes"`
+	SlowConsumerPolicy     string           `json:"slowConsumerPolicy"`
+	MaxWriteBatchBytes     int              `json:"maxWriteBatchBytes"`
+	WriteFlushDelayMs      int              `json:"writeFlushDelayMs"`
+	NetpollNumLoops        int              `json:"netpollNumLoops"`
+	NetpollLoadBalance     string           `json:"netpollLoadBalance"`
+	SessionPolicy          string           `json:"sessionPolicy"`
+	SessionKickMsgID       uint32           `json:"sessionKickMsgId"`
+	SessionKickNotice      string           `json:"sessionKickNotice"`
+	SessionResumeGraceMs   int              `json:"sessionResumeGraceMs"`
+	SessionReplayBufferLen int              `json:"sessionReplayBufferLen"`
+	DispatchMode           string           `json:"dispatchMode"`
+	TickIntervalMs         int              `json:"tickIntervalMs"`
+	MaxCatchUpTicks        int              `json:"maxCatchUpTicks"`
+	AuthTimeoutMs          int              `json:"authTimeoutMs"`
+	ProxyProtocol          bool             `json:"proxyProtocol"`
+	TrustedProxyCIDRs      []string         `json:"trustedProxyCidrs"`
+	ProxyHeaderTimeoutMs   int              `json:"proxyHeaderTimeoutMs"`
+	Listeners              []ListenerConfig `json:"listeners"`
+	RecordDir              string           `json:"recordDir"`
+
+	// Deprecated: ignored since the outbound queue is bounded in bytes; see
+	// MaxOutQueueBytes.
+	MaxMsgChanLen uint32 `json:"maxMsgChanLen,omitempty"`
+	// Deprecated: ignored since the outbound queue is bounded in bytes; see
+	// MaxOutQueueBytes.
+	MaxMsgBuffChanLen uint32 `json:"maxMsgBuffChanLen,omitempty"`
+}
+
+type ListenerConfig struct {
+	// Name defaults to DefaultListenerName of the listener's index.
+	Name     string `json:"name"`
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	SkipAuth bool   `json:"skipAuth"`
+}
+
+type AdmissionConfig struct {
+	AllowCIDRs            []string `json:"allowCidrs"`
+	DenyCIDRs             []string `json:"denyCidrs"`
+	MaxConnPerIP          int      `json:"maxConnPerIp"`
+	ConnRatePerIP         float64  `json:"connRatePerIp"`
+	ConnBurstPerIP        int      `json:"connBurstPerIp"`
+	ViolationsBeforeBan   int      `json:"violationsBeforeBan"`
+	BanDurationMs         int      `json:"banDurationMs"`
+	AuthFailuresBeforeBan int      `json:"authFailuresBeforeBan"`
 }
 
 type LogConfig struct {
@@ -45,6 +88,7 @@ type LogConfig struct {
 type StateConfig struct {
 	Adapter string            `json:"adapter"`
 	Redis   state.RedisConfig `json:"redis"`
+	SQL     state.SQLConfig   `json:"sql"`
 }
 
 type AOIConfig struct {
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"zinxplusplus/state"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate checks c and returns every problem found joined into one error;
+// each part wraps ErrInvalidConfig and names the offending key.
+func Validate(c *Config) error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidConfig}, args...)...))
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		add("%s %q must be one of %s", key, value, strings.Join(allowed, ", "))
+	}
+
+	s := c.Server
+	oneOf("server.ipVersion", s.IPVersion, "tcp", "tcp4", "tcp6")
+	if s.Port < 0 || s.Port > 65535 {
+		add("server.port %d out of range 0-65535", s.Port)
+	}
+	if s.MaxConn <= 0 {
+		add("server.maxConn must be positive")
+	}
+	if s.MaxPacketSize == 0 {
+		add("server.maxPacketSize must be positive")
+	}
+	if s.WorkerPoolSize == 0 {
+		add("server.workerPoolSize must be positive")
+	}
+	if s.MaxWorkerTaskLen == 0 {
+		add("server.maxWorkerTaskLen must be positive")
+	}
+	for _, f := range []struct {
+		key   string
+		value int
+	}{
+		{"readTimeoutMs", s.ReadTimeoutMs},
+		{"writeTimeoutMs", s.WriteTimeoutMs},
+		{"idleTimeoutMs", s.IdleTimeoutMs},
+		{"sendMsgTimeoutMs", s.SendMsgTimeoutMs},
+		{"sendTaskQueueTimeoutMs", s.SendTaskQueueTimeoutMs},
+		{"authTimeoutMs", s.AuthTimeoutMs},
+		{"proxyHeaderTimeoutMs", s.ProxyHeaderTimeoutMs},
+		{"writeFlushDelayMs", s.WriteFlushDelayMs},
+		{"maxOutQueueBytes", s.MaxOutQueueBytes},
+		{"maxWriteBatchBytes", s.MaxWriteBatchBytes},
+		{"netpollNumLoops", s.NetpollNumLoops},
+		{"sessionResumeGraceMs", s.SessionResumeGraceMs},
+		{"sessionReplayBufferLen", s.SessionReplayBufferLen},
+		{"maxCatchUpTicks", s.MaxCatchUpTicks},
+	} {
+		if f.value < 0 {
+			add("server.%s must not be negative", f.key)
+		}
+	}
+	if s.TickIntervalMs <= 0 {
+		add("server.tickIntervalMs must be positive")
+	}
+	oneOf("server.slowConsumerPolicy", s.SlowConsumerPolicy, "drop-lowest", "disconnect", "callback")
+	oneOf("server.sessionPolicy", s.SessionPolicy, "kick-old", "reject-new")
+	oneOf("server.dispatchMode", s.DispatchMode, "conn-id", "hash-key", "least-loaded", "actor")
+	oneOf("server.netpollLoadBalance", s.NetpollLoadBalance, "round-robin", "random")
+	if err := checkCIDRs(s.TrustedProxyCIDRs); err != nil {
+		add("server.trustedProxyCidrs: %v", err)
+	}
+	names := make(map[string]bool, len(s.Listeners))
+	for i, l := range s.Listeners {
+		name := l.Name
+		if name == "" {
+			name = DefaultListenerName(i)
+		}
+		if names[name] {
+			add("server.listeners[%d]: duplicate name %q", i, name)
+		}
+		names[name] = true
+		oneOf(fmt.Sprintf("server.listeners[%d].network", i), l.Network, "tcp", "tcp4", "tcp6", "unix")
+		if l.Address == "" {
+			add("server.listeners[%d].address is empty", i)
+		}
+	}
+
+	a := c.Admission
+	if err := checkCIDRs(a.AllowCIDRs); err != nil {
+		add("admission.allowCidrs: %v", err)
+	}
+	if err := checkCIDRs(a.DenyCIDRs); err != nil {
+		add("admission.denyCidrs: %v", err)
+	}
+	if a.MaxConnPerIP < 0 || a.ConnRatePerIP < 0 || a.ConnBurstPerIP < 0 {
+		add("admission limits must not be negative")
+	}
+	if a.ViolationsBeforeBan < 0 || a.BanDurationMs < 0 || a.AuthFailuresBeforeBan < 0 {
+		add("admission ban settings must not be negative")
+	}
+
+	oneOf("log.level", strings.ToLower(c.Log.Level), "", "debug", "info", "warn", "error")
+
+	oneOf("state.adapter", c.State.Adapter, "memory", "redis", "sql")
+	if c.State.Adapter == "redis" {
+		r := c.State.Redis
+		switch strings.ToLower(r.Mode) {
+		case "", state.RedisModeStandalone:
+			if r.Addr == "" && len(r.Addrs) != 1 {
+				add("state.redis.Addr is required in standalone mode")
+			}
+		case state.RedisModeSentinel:
+			if r.MasterName == "" || len(r.Addrs) == 0 {
+				add("state.redis sentinel mode needs MasterName and Addrs")
+			}
+		case state.RedisModeCluster:
+			if len(r.Addrs) == 0 {
+				add("state.redis cluster mode needs Addrs")
+			}
+		default:
+			add("state.redis.Mode %q must be one of standalone, sentinel, cluster", r.Mode)
+		}
+		if r.PoolSize < 0 || r.MinIdleConns < 0 || r.DialTimeoutMs < 0 || r.ReadTimeoutMs < -1 ||
+			r.WriteTimeoutMs < -1 || r.PoolTimeoutMs < 0 || r.MaxRetries < -1 ||
+			r.MinRetryBackoffMs < -1 || r.MaxRetryBackoffMs < -1 {
+			add("state.redis pool, timeout and retry settings out of range")
+		}
+	}
+	if c.State.Adapter == "sql" {
+		q := c.State.SQL
+		if q.Driver == "" || q.DSN == "" {
+			add("state.sql needs Driver and DSN")
+		}
+		oneOf("state.sql.Dialect", q.Dialect, state.SQLDialectSQLite, state.SQLDialectMySQL, state.SQLDialectPostgres)
+	}
+
+	if c.AOI.MinX >= c.AOI.MaxX {
+		add("aoi.minX %v must be below aoi.maxX %v", c.AOI.MinX, c.AOI.MaxX)
+	}
+	if c.AOI.MinZ >= c.AOI.MaxZ {
+		add("aoi.minZ %v must be below aoi.maxZ %v", c.AOI.MinZ, c.AOI.MaxZ)
+	}
+	if c.AOI.CntsX <= 0 || c.AOI.CntsZ <= 0 {
+		add("aoi.cntsX and aoi.cntsZ must be positive")
+	}
+	if c.AOI.Capacity <= 0 || c.AOI.MaxDepth <= 0 {
+		add("aoi.capacity and aoi.maxDepth must be positive")
+	}
+	if c.AOI.ViewRange <= 0 {
+		add("aoi.viewRange must be positive")
+	}
+
+	if c.Scripting.Enabled {
+		oneOf("scripting.engineType", c.Scripting.EngineType, "lua")
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkCIDRs accepts the same forms as the admission controller: CIDRs and
+// bare IPs.
+func checkCIDRs(cidrs []string) error {
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				return err
+			}
+		} else if net.ParseIP(s) == nil {
+			return fmt.Errorf("invalid IP %q", s)
+		}
+	}
+	return nil
+}
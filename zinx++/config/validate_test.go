@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Validate(Defaults()); err != nil {
+		t.Fatalf("Validate(Defaults()) = %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"ip version", func(c *Config) { c.Server.IPVersion = "udp" }, "server.ipVersion"},
+		{"port", func(c *Config) { c.Server.Port = 65536 }, "server.port 65536"},
+		{"max conn", func(c *Config) { c.Server.MaxConn = 0 }, "server.maxConn"},
+		{"packet size", func(c *Config) { c.Server.MaxPacketSize = 0 }, "server.maxPacketSize"},
+		{"negative timeout", func(c *Config) { c.Server.ReadTimeoutMs = -1 }, "server.readTimeoutMs"},
+		{"tick interval", func(c *Config) { c.Server.TickIntervalMs = 0 }, "server.tickIntervalMs"},
+		{"slow consumer policy", func(c *Config) { c.Server.SlowConsumerPolicy = "block" }, "server.slowConsumerPolicy"},
+		{"dispatch mode", func(c *Config) { c.Server.DispatchMode = "random" }, "server.dispatchMode"},
+		{"trusted proxies", func(c *Config) { c.Server.TrustedProxyCIDRs = []string{"10.0.0.0/40"} }, "server.trustedProxyCidrs"},
+		{"duplicate listener", func(c *Config) {
+			c.Server.Listeners = []ListenerConfig{
+				{Name: "a", Network: "tcp", Address: ":1"},
+				{Name: "a", Network: "tcp", Address: ":2"},
+			}
+		}, `duplicate name "a"`},
+		{"default listener name taken", func(c *Config) {
+			c.Server.Listeners = []ListenerConfig{
+				{Name: "listener-1", Network: "tcp", Address: ":1"},
+				{Network: "tcp", Address: ":2"},
+			}
+		}, `duplicate name "listener-1"`},
+		{"listener network", func(c *Config) {
+			c.Server.Listeners = []ListenerConfig{{Network: "udp", Address: ":1"}}
+		}, "server.listeners[0].network"},
+		{"listener address", func(c *Config) {
+			c.Server.Listeners = []ListenerConfig{{Network: "unix"}}
+		}, "server.listeners[0].address"},
+		{"deny list", func(c *Config) { c.Admission.DenyCIDRs = []string{"nonsense"} }, "admission.denyCidrs"},
+		{"admission limits", func(c *Config) { c.Admission.MaxConnPerIP = -1 }, "admission limits"},
+		{"auth failures", func(c *Config) { c.Admission.AuthFailuresBeforeBan = -1 }, "admission ban settings"},
+		{"log level", func(c *Config) { c.Log.Level = "verbose" }, "log.level"},
+		{"state adapter", func(c *Config) { c.State.Adapter = "etcd" }, "state.adapter"},
+		{"redis addr", func(c *Config) {
+			c.State.Adapter = "redis"
+			c.State.Redis.Addr = ""
+		}, "state.redis.Addr"},
+		{"redis mode", func(c *Config) {
+			c.State.Adapter = "redis"
+			c.State.Redis.Addr = "localhost:6379"
+			c.State.Redis.Mode = "ring"
+		}, "state.redis.Mode"},
+		{"sql dsn", func(c *Config) { c.State.Adapter = "sql" }, "state.sql needs Driver and DSN"},
+		{"aoi bounds", func(c *Config) { c.AOI.MinX = c.AOI.MaxX }, "aoi.minX"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Defaults()
+			tc.change(c)
+			err := Validate(c)
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("Validate = %v, want ErrInvalidConfig", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Validate = %v, want it to mention %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateUnnamedListeners(t *testing.T) {
+	c := Defaults()
+	c.Server.Listeners = []ListenerConfig{
+		{Network: "tcp", Address: ":1"},
+		{Network: "tcp", Address: ":2"},
+	}
+	if err := Validate(c); err != nil {
+		t.Fatalf("Validate of two unnamed listeners = %v", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := Defaults()
+	c.Server.MaxConn = 0
+	c.Server.Port = -1
+	c.Log.Level = "loud"
+
+	err := Validate(c)
+	for _, part := range []string{"server.maxConn", "server.port -1", "log.level"} {
+		if err == nil || !strings.Contains(err.Error(), part) {
+			t.Errorf("Validate = %v, want it to mention %s", err, part)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"zinxplusplus/state"
+	"zinxplusplus/ziface"
+)
+
+type PlayerCacheOption func(*PlayerCacheOptions)
+
+type PlayerCacheOptions struct {
+	// FlushInterval is how often dirty states are written back.
+	FlushInterval time.Duration
+	// FlushBatchSize caps the states written in one round trip.
+	FlushBatchSize int
+	// FlushRetries is how many times a failed batch is retried within one
+	// flush, waiting RetryBackoff, then twice that, between attempts. States
+	// that still fail stay dirty for the next flush.
+	FlushRetries int
+	RetryBackoff time.Duration
+	// FlushTimeout bounds the flushes run from OnConnStop and Server.Stop.
+	FlushTimeout time.Duration
+	// Expiration in seconds applied to states loaded from the backend; states
+	// set through the cache keep their own.
+	Expiration int64
+	// CleanTTL is how long a state read from the backend and not changed
+	// since is served from memory before it is read again; 0 keeps it until
+	// the player is evicted.
+	CleanTTL time.Duration
+
+	OnFlushError func(playerIDs []uint64, err error)
+}
+
+func WithFlushInterval(d time.Duration) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.FlushInterval = d
+	}
+}
+
+func WithFlushBatchSize(n int) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.FlushBatchSize = n
+	}
+}
+
+func WithFlushRetries(retries int, backoff time.Duration) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.FlushRetries = retries
+		o.RetryBackoff = backoff
+	}
+}
+
+func WithFlushTimeout(d time.Duration) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.FlushTimeout = d
+	}
+}
+
+func WithCacheExpiration(seconds int64) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.Expiration = seconds
+	}
+}
+
+func WithCleanTTL(d time.Duration) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.CleanTTL = d
+	}
+}
+
+func WithOnFlushError(hook func(playerIDs []uint64, err error)) PlayerCacheOption {
+	return func(o *PlayerCacheOptions) {
+		o.OnFlushError = hook
+	}
+}
+
+type cachedPlayer struct {
+	data       []byte
+	expiration int64
+	gen        uint64 // bumped by every set
+	flushedGen uint64
+	touched    time.Time // last load or set
+}
+
+func (p *cachedPlayer) dirty() bool {
+	return p.gen != p.flushedGen
+}
+
+// stale reports a clean state older than ttl, which is read again instead of
+// served from memory.
+func (p *cachedPlayer) stale(ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && !p.dirty() && now.Sub(p.touched) >= ttl
+}
+
+// PlayerStateCache keeps the states of players on this server in memory and
+// writes changes back behind the caller: SetPlayerState only updates the
+// cache, and dirty states are flushed in batches every FlushInterval, when
+// the player leaves (Evict, or the connection closing once Attach is used)
+// and when the server stops.
+//
+// The cache assumes this server owns the players it holds; writes made to
+// the same keys elsewhere are overwritten by the next flush. Once attached,
+// reads of players not bound on this server go to the backend without being
+// cached, and clean states are dropped after CleanTTL either way.
+type PlayerStateCache struct {
+	client *StateClient
+	opts   PlayerCacheOptions
+	server ziface.IServer
+
+	players map[uint64]*cachedPlayer
+	lock    sync.Mutex
+
+	// flushLock serialises backend writes, so a delete or eviction never
+	// races an older flush of the same player.
+	flushLock sync.Mutex
+
+	evictions sync.WaitGroup
+	stop      chan struct{}
+	stopOnce  sync.Once
+	loopDone  chan struct{}
+}
+
+func NewPlayerStateCache(client *StateClient, opts ...PlayerCacheOption) *PlayerStateCache {
+	c := &PlayerStateCache{
+		client: client,
+		opts: PlayerCacheOptions{
+			FlushInterval:  5 * time.Second,
+			FlushBatchSize: 100,
+			FlushRetries:   2,
+			RetryBackoff:   100 * time.Millisecond,
+			FlushTimeout:   5 * time.Second,
+			CleanTTL:       time.Minute,
+		},
+		players: make(map[uint64]*cachedPlayer),
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	if c.opts.FlushBatchSize <= 0 {
+		c.opts.FlushBatchSize = 100
+	}
+	return c
+}
+
+// Start runs the periodic flush.
+func (c *PlayerStateCache) Start() {
+	if c.loopDone != nil || c.opts.FlushInterval <= 0 {
+		return
+	}
+	c.loopDone = make(chan struct{})
+	go c.flushLoop()
+}
+
+// Stop ends the periodic flush, waits for pending evictions and flushes what
+// is still dirty.
+func (c *PlayerStateCache) Stop(ctx context.Context) error {
+	// Closed under lock so evictAsync never adds to evictions once Wait may
+	// have started.
+	c.lock.Lock()
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.lock.Unlock()
+	if c.loopDone != nil {
+		<-c.loopDone
+	}
+	c.evictions.Wait()
+	return c.Flush(ctx)
+}
+
+// Attach flushes and evicts a player when their connection closes, using
+// the server's session manager to find the player, and stops the cache
+// when the server stops. It must be called before the cache is used.
+func (c *PlayerStateCache) Attach(server ziface.IServer) {
+	c.server = server
+	server.AddOnConnStop(func(conn ziface.IConnection) {
+		sessions := server.GetSessionManager()
+		if sessions == nil {
+			return
+		}
+		if playerID, ok := sessions.GetUserByConn(conn); ok {
+			c.evictAsync(playerID)
+		}
+	})
+	server.AddOnStop(func(ziface.IServer) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.FlushTimeout)
+		defer cancel()
+		if err := c.Stop(ctx); err != nil {
+			fmt.Printf("[PlayerStateCache] Final flush failed: %v\n", err)
+		}
+	})
+}
+
+func (c *PlayerStateCache) flushLoop() {
+	defer close(c.loopDone)
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.opts.FlushTimeout)
+			if err := c.Flush(ctx); err != nil {
+				fmt.Printf("[PlayerStateCache] Periodic flush failed: %v\n", err)
+			}
+			cancel()
+			c.dropStale()
+		}
+	}
+}
+
+// dropStale forgets clean states past CleanTTL.
+func (c *PlayerStateCache) dropStale() {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for playerID, p := range c.players {
+		if p.stale(c.opts.CleanTTL, now) {
+			delete(c.players, playerID)
+		}
+	}
+}
+
+// owns reports whether playerID is bound on the attached server. Without a
+// server every player counts as owned.
+func (c *PlayerStateCache) owns(playerID uint64) bool {
+	if c.server == nil {
+		return true
+	}
+	sessions := c.server.GetSessionManager()
+	if sessions == nil {
+		return true
+	}
+	_, err := sessions.GetConnByUser(playerID)
+	return err == nil
+}
+
+func (c *PlayerStateCache) GetPlayerState(ctx context.Context, playerID uint64, statePtr interface{}) error {
+	c.lock.Lock()
+	p, ok := c.players[playerID]
+	var data []byte
+	if ok && p.stale(c.opts.CleanTTL, time.Now()) {
+		delete(c.players, playerID)
+		ok = false
+	}
+	if ok {
+		data = p.data
+	}
+	c.lock.Unlock()
+
+	if !ok {
+		var err error
+		data, err = c.client.manager.GetState(ctx, playerStateKey(playerID))
+		if err != nil {
+			if errors.Is(err, state.ErrStateNotFound) {
+				return err
+			}
+			return fmt.Errorf("get player state failed (key=%s): %w", playerStateKey(playerID), err)
+		}
+		owned := c.owns(playerID)
+		c.lock.Lock()
+		if cur, loaded := c.players[playerID]; loaded {
+			// A set won the race; it is newer than what was read.
+			data = cur.data
+		} else if owned {
+			c.players[playerID] = &cachedPlayer{data: data, expiration: c.opts.Expiration, touched: time.Now()}
+		}
+		c.lock.Unlock()
+	}
+
+	return c.client.Codecs().Unmarshal(playerStateKey(playerID), data, statePtr)
+}
+
+// SetPlayerState encodes stateObj into the cache and marks it dirty; the
+// backend is written by the next flush.
+func (c *PlayerStateCache) SetPlayerState(ctx context.Context, playerID uint64, stateObj interface{}, expirationSeconds int64) error {
+	data, err := c.client.Codecs().Marshal(playerStateKey(playerID), stateObj)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.players[playerID]
+	if !ok {
+		p = &cachedPlayer{}
+		c.players[playerID] = p
+	}
+	p.data = data
+	p.expiration = expirationSeconds
+	p.gen++
+	p.touched = time.Now()
+	return nil
+}
+
+// DeletePlayerState drops the player from the cache and the backend at once.
+func (c *PlayerStateCache) DeletePlayerState(ctx context.Context, playerID uint64) error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+
+	c.lock.Lock()
+	delete(c.players, playerID)
+	c.lock.Unlock()
+	return c.client.DeletePlayerState(ctx, playerID)
+}
+
+// Evict flushes the player if dirty and drops them from the cache, e.g. on
+// logout. If the flush fails the player stays cached and dirty so a later
+// flush can retry.
+func (c *PlayerStateCache) Evict(ctx context.Context, playerID uint64) error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+
+	if err := c.flushLocked(ctx, []uint64{playerID}); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	if p, ok := c.players[playerID]; ok && !p.dirty() {
+		delete(c.players, playerID)
+	}
+	c.lock.Unlock()
+	return nil
+}
+
+// evictAsync evicts in the background so OnConnStop does not block on the
+// backend. After Stop it does nothing; the final flush covers the player.
+func (c *PlayerStateCache) evictAsync(playerID uint64) {
+	c.lock.Lock()
+	select {
+	case <-c.stop:
+		c.lock.Unlock()
+		return
+	default:
+	}
+	c.evictions.Add(1)
+	c.lock.Unlock()
+	go func() {
+		defer c.evictions.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.FlushTimeout)
+		defer cancel()
+		if err := c.Evict(ctx, playerID); err != nil {
+			fmt.Printf("[PlayerStateCache] Evict player %d failed: %v\n", playerID, err)
+		}
+	}()
+}
+
+// Flush writes every dirty state back. Batches that still fail after the
+// retries are reported through OnFlushError and stay dirty.
+func (c *PlayerStateCache) Flush(ctx context.Context) error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+	return c.flushLocked(ctx, nil)
+}
+
+type pendingWrite struct {
+	playerID   uint64
+	data       []byte
+	expiration int64
+	gen        uint64
+}
+
+// flushLocked flushes the given players, or all dirty ones when ids is nil.
+func (c *PlayerStateCache) flushLocked(ctx context.Context, ids []uint64) error {
+	var pending []pendingWrite
+	c.lock.Lock()
+	collect := func(playerID uint64, p *cachedPlayer) {
+		if p.dirty() {
+			pending = append(pending, pendingWrite{playerID, p.data, p.expiration, p.gen})
+		}
+	}
+	if ids == nil {
+		for playerID, p := range c.players {
+			collect(playerID, p)
+		}
+	} else {
+		for _, playerID := range ids {
+			if p, ok := c.players[playerID]; ok {
+				collect(playerID, p)
+			}
+		}
+	}
+	c.lock.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].playerID < pending[j].playerID })
+
+	var errs []error
+	for start := 0; start < len(pending); start += c.opts.FlushBatchSize {
+		end := start + c.opts.FlushBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+		if err := c.writeWithRetry(ctx, batch); err != nil {
+			playerIDs := make([]uint64, len(batch))
+			for i, w := range batch {
+				playerIDs[i] = w.playerID
+			}
+			if c.opts.OnFlushError != nil {
+				c.opts.OnFlushError(playerIDs, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		c.lock.Lock()
+		for _, w := range batch {
+			if p, ok := c.players[w.playerID]; ok && p.flushedGen < w.gen {
+				p.flushedGen = w.gen
+			}
+		}
+		c.lock.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (c *PlayerStateCache) writeWithRetry(ctx context.Context, batch []pendingWrite) error {
+	backoff := c.opts.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = c.write(ctx, batch); err == nil {
+			return nil
+		}
+		if attempt >= c.opts.FlushRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (%v)", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// write sends one batch, pipelined when the backend supports batches.
+func (c *PlayerStateCache) write(ctx context.Context, batch []pendingWrite) error {
+	if ext, ok := c.client.manager.(ziface.IExtendedStateManager); ok {
+		b := ext.Batch()
+		for _, w := range batch {
+			b.Set(playerStateKey(w.playerID), w.data, w.expiration)
+		}
+		if err := b.Exec(ctx); err != nil {
+			return fmt.Errorf("flush %d player states failed: %w", len(batch), err)
+		}
+		return nil
+	}
+	for _, w := range batch {
+		if err := c.client.manager.SetState(ctx, playerStateKey(w.playerID), w.data, w.expiration); err != nil {
+			return fmt.Errorf("flush player state failed (key=%s): %w", playerStateKey(w.playerID), err)
+		}
+	}
+	return nil
+}
+
+// Len reports the cached players and how many of them are dirty.
+func (c *PlayerStateCache) Len() (cached, dirty int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, p := range c.players {
+		if p.dirty() {
+			dirty++
+		}
+	}
+	return len(c.players), dirty
+}
@@ -0,0 +1,194 @@
+package distributed_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"zinxplusplus/distributed"
+	"zinxplusplus/state"
+	"zinxplusplus/ztest"
+)
+
+type player struct {
+	Gold int
+}
+
+func newCache(t *testing.T, opts ...distributed.PlayerCacheOption) (*distributed.PlayerStateCache, *distributed.StateClient) {
+	t.Helper()
+	client, err := distributed.NewStateClient(state.NewMemoryStateAdapter())
+	if err != nil {
+		t.Fatalf("NewStateClient: %v", err)
+	}
+	opts = append([]distributed.PlayerCacheOption{distributed.WithFlushInterval(0)}, opts...)
+	return distributed.NewPlayerStateCache(client, opts...), client
+}
+
+func login(t *testing.T, server *ztest.FakeServer, playerID uint64) *ztest.FakeConnection {
+	t.Helper()
+	conn := server.Connect()
+	if err := server.GetSessionManager().Bind(playerID, conn); err != nil {
+		t.Fatalf("Bind(%d): %v", playerID, err)
+	}
+	return conn
+}
+
+func gold(t *testing.T, get func(context.Context, uint64, interface{}) error, playerID uint64) int {
+	t.Helper()
+	var p player
+	if err := get(context.Background(), playerID, &p); err != nil {
+		t.Fatalf("get player %d: %v", playerID, err)
+	}
+	return p.Gold
+}
+
+func TestPlayerCacheKeepsOnlyBoundPlayers(t *testing.T) {
+	ctx := context.Background()
+	cache, client := newCache(t)
+	server := ztest.NewFakeServer()
+	cache.Attach(server)
+
+	for id := uint64(1); id <= 2; id++ {
+		if err := client.SetPlayerState(ctx, id, player{Gold: int(id)}, 0); err != nil {
+			t.Fatalf("SetPlayerState: %v", err)
+		}
+	}
+	login(t, server, 1)
+
+	if got := gold(t, cache.GetPlayerState, 1); got != 1 {
+		t.Fatalf("player 1 gold = %d, want 1", got)
+	}
+	if got := gold(t, cache.GetPlayerState, 2); got != 2 {
+		t.Fatalf("player 2 gold = %d, want 2", got)
+	}
+	if cached, _ := cache.Len(); cached != 1 {
+		t.Fatalf("cached = %d, want only the bound player", cached)
+	}
+
+	// Player 2 lives on another server; its writes must be seen here.
+	if err := client.SetPlayerState(ctx, 2, player{Gold: 20}, 0); err != nil {
+		t.Fatalf("SetPlayerState: %v", err)
+	}
+	if got := gold(t, cache.GetPlayerState, 2); got != 20 {
+		t.Fatalf("player 2 gold = %d, want 20", got)
+	}
+}
+
+func TestPlayerCacheCleanTTL(t *testing.T) {
+	ctx := context.Background()
+	cache, client := newCache(t, distributed.WithCleanTTL(30*time.Millisecond))
+
+	if err := client.SetPlayerState(ctx, 1, player{Gold: 1}, 0); err != nil {
+		t.Fatalf("SetPlayerState: %v", err)
+	}
+	if got := gold(t, cache.GetPlayerState, 1); got != 1 {
+		t.Fatalf("gold = %d, want 1", got)
+	}
+	if err := client.SetPlayerState(ctx, 1, player{Gold: 2}, 0); err != nil {
+		t.Fatalf("SetPlayerState: %v", err)
+	}
+	if got := gold(t, cache.GetPlayerState, 1); got != 1 {
+		t.Fatalf("gold = %d, want the cached 1 within the TTL", got)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if got := gold(t, cache.GetPlayerState, 1); got != 2 {
+		t.Fatalf("gold = %d, want 2 after the TTL", got)
+	}
+
+	// Dirty states never expire before they are flushed.
+	if err := cache.SetPlayerState(ctx, 1, player{Gold: 3}, 0); err != nil {
+		t.Fatalf("SetPlayerState: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if got := gold(t, cache.GetPlayerState, 1); got != 3 {
+		t.Fatalf("gold = %d, want the dirty 3", got)
+	}
+}
+
+func TestPlayerCacheEvictsOnDisconnect(t *testing.T) {
+	ctx := context.Background()
+	cache, client := newCache(t)
+	server := ztest.NewFakeServer()
+	cache.Attach(server)
+
+	conn := login(t, server, 7)
+	if err := cache.SetPlayerState(ctx, 7, player{Gold: 70}, 0); err != nil {
+		t.Fatalf("SetPlayerState: %v", err)
+	}
+	server.Disconnect(conn)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if cached, _ := cache.Len(); cached == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("player still cached after disconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := gold(t, client.GetPlayerState, 7); got != 70 {
+		t.Fatalf("stored gold = %d, want 70", got)
+	}
+}
+
+// TestPlayerCacheStopDuringDisconnects runs evictions against Stop; with
+// -race it catches evictions added while Stop waits for them.
+func TestPlayerCacheStopDuringDisconnects(t *testing.T) {
+	ctx := context.Background()
+	cache, client := newCache(t)
+	server := ztest.NewFakeServer()
+	cache.Attach(server)
+
+	const players = 50
+	conns := make([]*ztest.FakeConnection, players)
+	for i := range conns {
+		id := uint64(i + 1)
+		conns[i] = login(t, server, id)
+		if err := cache.SetPlayerState(ctx, id, player{Gold: int(id)}, 0); err != nil {
+			t.Fatalf("SetPlayerState: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *ztest.FakeConnection) {
+			defer wg.Done()
+			server.Disconnect(conn)
+		}(conn)
+	}
+	if err := cache.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	wg.Wait()
+
+	for id := uint64(1); id <= players; id++ {
+		if got := gold(t, client.GetPlayerState, id); got != int(id) {
+			t.Fatalf("player %d stored gold = %d, want %d", id, got, id)
+		}
+	}
+}
+
+func TestPlayerCacheStopsWithServer(t *testing.T) {
+	ctx := context.Background()
+	cache, client := newCache(t)
+	server := ztest.NewFakeServer()
+	cache.Attach(server)
+
+	for id := uint64(1); id <= 3; id++ {
+		login(t, server, id)
+		if err := cache.SetPlayerState(ctx, id, player{Gold: int(id) * 10}, 0); err != nil {
+			t.Fatalf("SetPlayerState: %v", err)
+		}
+	}
+	server.Stop()
+
+	for id := uint64(1); id <= 3; id++ {
+		if got := gold(t, client.GetPlayerState, id); got != int(id)*10 {
+			t.Fatalf("player %d stored gold = %d after Stop, want %d", id, got, id*10)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package distributed
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -12,23 +11,70 @@ import (
 
 type StateClient struct {
 	manager ziface.IStateManager
+
+	// codecs is set by WithCodecs/WithSerializer; when nil, managers that
+	// encode objects themselves are left to do so and the rest use JSON.
+	codecs *state.Codecs
+}
+
+type StateClientOption func(*StateClient)
+
+// WithCodecs picks serializers per key prefix, e.g.
+// state.NewCodecs(state.JSONSerializer).Use("player:", state.MsgPackSerializer).
+func WithCodecs(codecs *state.Codecs) StateClientOption {
+	return func(sc *StateClient) {
+		sc.codecs = codecs
+	}
+}
+
+func WithSerializer(s ziface.ISerializer) StateClientOption {
+	return WithCodecs(state.NewCodecs(s))
+}
+
+// WithSchemas migrates player states written with an older schema version
+// on load. Give it after WithCodecs/WithSerializer, which replace the codecs.
+func WithSchemas(schemas *state.SchemaRegistry) StateClientOption {
+	return func(sc *StateClient) {
+		if sc.codecs == nil {
+			sc.codecs = state.DefaultCodecs()
+		}
+		sc.codecs.UseSchemas(schemas)
+	}
 }
 
-func NewStateClient(stateMgr ziface.IStateManager) (*StateClient, error) {
+func playerStateKey(playerID uint64) string {
+	return fmt.Sprintf("player:%d", playerID)
+}
+
+func NewStateClient(stateMgr ziface.IStateManager, opts ...StateClientOption) (*StateClient, error) {
 	if stateMgr == nil {
 		return nil, errors.New("state manager cannot be nil")
 	}
-	return &StateClient{
+	sc := &StateClient{
 		manager: stateMgr,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(sc)
+	}
+	return sc, nil
 }
 
+// Codecs returns the serializers the client encodes state objects with.
+func (sc *StateClient) Codecs() *state.Codecs {
+	if sc.codecs != nil {
+		return sc.codecs
+	}
+	return defaultCodecs
+}
+
+var defaultCodecs = state.DefaultCodecs()
+
 func (sc *StateClient) GetPlayerState(ctx context.Context, playerID uint64, statePtr interface{}) error {
-	stateKey := fmt.Sprintf("player:%d", playerID)
+	stateKey := playerStateKey(playerID)
 
 	if getter, ok := sc.manager.(interface {
 		GetStateObject(context.Context, string, interface{}) error
-	}); ok {
+	}); ok && sc.codecs == nil {
 		err := getter.GetStateObject(ctx, stateKey, statePtr)
 		if err != nil {
 
@@ -49,19 +95,15 @@ func (sc *StateClient) GetPlayerState(ctx context.Context, playerID uint64, stat
 		return fmt.Errorf("get player state failed (key=%s): %w", stateKey, err)
 	}
 
-	if err := json.Unmarshal(stateBytes, statePtr); err != nil {
-		return fmt.Errorf("%w: failed to unmarshal player state (key=%s): %v", state.ErrDeserializationFailed, stateKey, err)
-	}
-
-	return nil
+	return sc.Codecs().Unmarshal(stateKey, stateBytes, statePtr)
 }
 
 func (sc *StateClient) SetPlayerState(ctx context.Context, playerID uint64, stateObj interface{}, expirationSeconds int64) error {
-	stateKey := fmt.Sprintf("player:%d", playerID)
+	stateKey := playerStateKey(playerID)
 
 	if setter, ok := sc.manager.(interface {
 		SetStateObject(context.Context, string, interface{}, int64) error
-	}); ok {
+	}); ok && sc.codecs == nil {
 		err := setter.SetStateObject(ctx, stateKey, stateObj, expirationSeconds)
 		if err != nil {
 			return fmt.Errorf("set player state object failed (key=%s): %w", stateKey, err)
@@ -69,9 +111,9 @@ func (sc *StateClient) SetPlayerState(ctx context.Context, playerID uint64, stat
 		return nil
 	}
 
-	stateBytes, err := json.Marshal(stateObj)
+	stateBytes, err := sc.Codecs().Marshal(stateKey, stateObj)
 	if err != nil {
-		return fmt.Errorf("%w: failed to marshal player state (key=%s): %v", state.ErrSerializationFailed, stateKey, err)
+		return err
 	}
 
 	err = sc.manager.SetState(ctx, stateKey, stateBytes, expirationSeconds)
@@ -83,7 +125,7 @@ func (sc *StateClient) SetPlayerState(ctx context.Context, playerID uint64, stat
 }
 
 func (sc *StateClient) DeletePlayerState(ctx context.Context, playerID uint64) error {
-	stateKey := fmt.Sprintf("player:%d", playerID)
+	stateKey := playerStateKey(playerID)
 	err := sc.manager.DeleteState(ctx, stateKey)
 	if err != nil {
 		return fmt.Errorf("delete player state failed (key=%s): %w", stateKey, err)
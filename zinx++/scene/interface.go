@@ -0,0 +1,7 @@
+package scene
+
+import "zinxplusplus/ziface"
+
+type IScene = ziface.IScene
+
+type ISceneManager = ziface.ISceneManager
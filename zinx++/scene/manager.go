@@ -0,0 +1,103 @@
+package scene
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"zinxplusplus/ziface"
+)
+
+type SceneManager struct {
+	opts *Options
+
+	scenes    map[uint64]*Scene
+	sceneLock sync.RWMutex
+
+	routers    map[uint32]ziface.IRouter
+	routerLock sync.RWMutex
+}
+
+func NewSceneManager(opts ...Option) ziface.ISceneManager {
+	return &SceneManager{
+		opts:    newOptions(opts...),
+		scenes:  make(map[uint64]*Scene),
+		routers: make(map[uint32]ziface.IRouter),
+	}
+}
+
+func (sm *SceneManager) CreateScene(sceneID uint64) (ziface.IScene, error) {
+	sm.sceneLock.Lock()
+	defer sm.sceneLock.Unlock()
+
+	if _, ok := sm.scenes[sceneID]; ok {
+		return nil, fmt.Errorf("%w: sceneID=%d", ErrSceneExists, sceneID)
+	}
+
+	s := newScene(sceneID, sm.getRouter, sm.opts)
+	sm.scenes[sceneID] = s
+	s.Start()
+	return s, nil
+}
+
+func (sm *SceneManager) GetScene(sceneID uint64) (ziface.IScene, error) {
+	sm.sceneLock.RLock()
+	defer sm.sceneLock.RUnlock()
+
+	if s, ok := sm.scenes[sceneID]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: sceneID=%d", ErrSceneNotFound, sceneID)
+}
+
+func (sm *SceneManager) RemoveScene(sceneID uint64) error {
+	sm.sceneLock.Lock()
+	s, ok := sm.scenes[sceneID]
+	if !ok {
+		sm.sceneLock.Unlock()
+		return fmt.Errorf("%w: sceneID=%d", ErrSceneNotFound, sceneID)
+	}
+	delete(sm.scenes, sceneID)
+	sm.sceneLock.Unlock()
+
+	s.Stop()
+	return nil
+}
+
+func (sm *SceneManager) Forward(sceneID uint64, request ziface.IRequest) error {
+	s, err := sm.GetScene(sceneID)
+	if err != nil {
+		return err
+	}
+	return s.Dispatch(request)
+}
+
+func (sm *SceneManager) AddRouter(msgID uint32, router ziface.IRouter) {
+	sm.routerLock.Lock()
+	defer sm.routerLock.Unlock()
+
+	if _, ok := sm.routers[msgID]; ok {
+		panic("repeated scene api, msgID = " + strconv.Itoa(int(msgID)))
+	}
+	sm.routers[msgID] = router
+	fmt.Printf("[SceneManager] Add scene Router success! msgID = %d\n", msgID)
+}
+
+func (sm *SceneManager) StopAll() {
+	sm.sceneLock.Lock()
+	scenes := sm.scenes
+	sm.scenes = make(map[uint64]*Scene)
+	sm.sceneLock.Unlock()
+
+	for _, s := range scenes {
+		s.Stop()
+	}
+	fmt.Printf("[SceneManager] All scenes stopped. Count = %d\n", len(scenes))
+}
+
+func (sm *SceneManager) getRouter(msgID uint32) (ziface.IRouter, bool) {
+	sm.routerLock.RLock()
+	defer sm.routerLock.RUnlock()
+	router, ok := sm.routers[msgID]
+	return router, ok
+}
@@ -0,0 +1,123 @@
+package scene
+
+import (
+	"time"
+
+	"zinxplusplus/aoi"
+	"zinxplusplus/config"
+	"zinxplusplus/timer"
+	"zinxplusplus/ziface"
+)
+
+type Option func(*Options)
+
+type Options struct {
+	MailboxLen   int
+	PostTimeout  time.Duration
+	TickInterval time.Duration
+	MaxCatchUp   int
+
+	OnTick    func(scene ziface.IScene, dt time.Duration)
+	OnOverrun func(scene ziface.IScene, overrun timer.Overrun)
+	OnStart   func(scene ziface.IScene)
+	OnStop    func(scene ziface.IScene)
+
+	NewAoiManager func(sceneID uint64) ziface.IAoiManager
+
+	// ConfigStore is followed for AOI view range changes; nil means the
+	// global config.
+	ConfigStore *config.Store
+}
+
+func WithMailboxLen(n int) Option {
+	return func(o *Options) {
+		o.MailboxLen = n
+	}
+}
+
+func WithPostTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.PostTimeout = d
+	}
+}
+
+func WithTick(interval time.Duration, onTick func(scene ziface.IScene, dt time.Duration)) Option {
+	return func(o *Options) {
+		o.TickInterval = interval
+		o.OnTick = onTick
+	}
+}
+
+func WithMaxCatchUp(ticks int) Option {
+	return func(o *Options) {
+		o.MaxCatchUp = ticks
+	}
+}
+
+func WithOverrunHandler(handler func(scene ziface.IScene, overrun timer.Overrun)) Option {
+	return func(o *Options) {
+		o.OnOverrun = handler
+	}
+}
+
+func WithOnStart(hook func(scene ziface.IScene)) Option {
+	return func(o *Options) {
+		o.OnStart = hook
+	}
+}
+
+func WithOnStop(hook func(scene ziface.IScene)) Option {
+	return func(o *Options) {
+		o.OnStop = hook
+	}
+}
+
+func WithAoiManagerFactory(factory func(sceneID uint64) ziface.IAoiManager) Option {
+	return func(o *Options) {
+		o.NewAoiManager = factory
+	}
+}
+
+// WithConfigStore makes scenes follow reloads of store, typically a server's
+// Config(), instead of the global config.
+func WithConfigStore(store *config.Store) Option {
+	return func(o *Options) {
+		o.ConfigStore = store
+	}
+}
+
+func WithQuadtreeAoi(cfg config.AOIConfig) Option {
+	return WithAoiManagerFactory(func(sceneID uint64) ziface.IAoiManager {
+		mgr := aoi.NewQuadtreeAoiManager(cfg.MinX, cfg.MaxX, cfg.MinZ, cfg.MaxZ, cfg.Capacity, cfg.MaxDepth)
+		if cfg.ViewRange > 0 {
+			mgr.(*aoi.AoiManager).SetViewRange(cfg.ViewRange)
+		}
+		return mgr
+	})
+}
+
+func newOptions(opts ...Option) *Options {
+	opt := &Options{
+		MailboxLen:   1024,
+		PostTimeout:  100 * time.Millisecond,
+		TickInterval: 50 * time.Millisecond,
+		MaxCatchUp:   5,
+	}
+
+	for _, o := range opts {
+		o(opt)
+	}
+
+	if opt.ConfigStore == nil {
+		opt.ConfigStore = config.GlobalStore()
+	}
+
+	if opt.MailboxLen <= 0 {
+		opt.MailboxLen = 1
+	}
+	if opt.TickInterval <= 0 {
+		opt.TickInterval = 50 * time.Millisecond
+	}
+
+	return opt
+}
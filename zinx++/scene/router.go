@@ -0,0 +1,57 @@
+package scene
+
+import (
+	"fmt"
+
+	"zinxplusplus/ziface"
+)
+
+const PropKeySceneID = "zinx.sceneID"
+
+type SceneIDFunc func(request ziface.IRequest) (uint64, bool)
+
+func SceneIDFromProperty(request ziface.IRequest) (uint64, bool) {
+	value, err := request.GetConnection().GetProperty(PropKeySceneID)
+	if err != nil {
+		return 0, false
+	}
+	sceneID, ok := value.(uint64)
+	return sceneID, ok
+}
+
+// ForwardRouter is registered on the server's MsgHandle and hands the request
+// over to the scene the connection currently belongs to.
+type ForwardRouter struct {
+	mgr     ziface.ISceneManager
+	sceneID SceneIDFunc
+}
+
+func NewForwardRouter(mgr ziface.ISceneManager, sceneID SceneIDFunc) ziface.IRouter {
+	if sceneID == nil {
+		sceneID = SceneIDFromProperty
+	}
+	return &ForwardRouter{mgr: mgr, sceneID: sceneID}
+}
+
+func (fr *ForwardRouter) PreHandle(request ziface.IRequest) {}
+
+func (fr *ForwardRouter) Handle(request ziface.IRequest) {
+	sceneID, ok := fr.sceneID(request)
+	if !ok {
+		fmt.Printf("[Scene] ConnID=%d is not in any scene, dropping msgID=%d\n",
+			request.GetConnection().GetConnID(), request.GetMsgID())
+		return
+	}
+	if err := fr.mgr.Forward(sceneID, request); err != nil {
+		fmt.Printf("[Scene] Forward msgID=%d to scene %d error: %v\n", request.GetMsgID(), sceneID, err)
+	}
+}
+
+func (fr *ForwardRouter) PostHandle(request ziface.IRequest) {}
+
+// Route registers router to run inside scenes and the forwarding router on
+// the server for the same msgID.
+func Route(server ziface.IServer, mgr ziface.ISceneManager, msgID uint32, router ziface.IRouter) {
+	mgr.AddRouter(msgID, router)
+	server.AddRouter(msgID, NewForwardRouter(mgr, nil))
+}
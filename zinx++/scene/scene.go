@@ -0,0 +1,218 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/config"
+	"zinxplusplus/timer"
+	"zinxplusplus/ziface"
+)
+
+var (
+	ErrSceneStopped  = errors.New("scene is stopped")
+	ErrSceneNotFound = errors.New("scene not found")
+	ErrSceneExists   = errors.New("scene already exists")
+	ErrMailboxFull   = errors.New("scene mailbox is full")
+	ErrNoSceneRouter = errors.New("no router registered in scene for msgID")
+)
+
+// Scene runs all of its game logic on one goroutine: forwarded requests,
+// posted tasks, timers and the tick callback never execute concurrently, so
+// handlers and the scene's AOI manager need no extra locking.
+type Scene struct {
+	id     uint64
+	opts   *Options
+	aoiMgr ziface.IAoiManager
+	sched  *timer.Scheduler
+
+	mailbox chan func()
+	routers func(msgID uint32) (ziface.IRouter, bool)
+
+	running atomic.Bool
+	exit    chan struct{}
+	done    chan struct{}
+	stop    sync.Once
+
+	// postLock lets the loop wait out in-flight Posts before it drains the
+	// mailbox for the last time; closed rejects every Post after that.
+	postLock sync.RWMutex
+	closed   bool
+}
+
+func NewScene(sceneID uint64, opts ...Option) ziface.IScene {
+	return newScene(sceneID, nil, newOptions(opts...))
+}
+
+func newScene(sceneID uint64, routers func(uint32) (ziface.IRouter, bool), opts *Options) *Scene {
+	s := &Scene{
+		id:      sceneID,
+		opts:    opts,
+		mailbox: make(chan func(), opts.MailboxLen),
+		routers: routers,
+		exit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		sched:   timer.NewScheduler(opts.TickInterval, opts.MaxCatchUp),
+	}
+	if opts.OnTick != nil {
+		s.sched.OnTick(func(tick uint64, dt time.Duration) {
+			opts.OnTick(s, dt)
+		})
+	}
+	if opts.OnOverrun != nil {
+		s.sched.SetOverrunHandler(func(o timer.Overrun) {
+			opts.OnOverrun(s, o)
+		})
+	}
+	if opts.NewAoiManager != nil {
+		s.aoiMgr = opts.NewAoiManager(sceneID)
+	}
+	return s
+}
+
+func (s *Scene) GetSceneID() uint64 {
+	return s.id
+}
+
+func (s *Scene) GetAoiManager() ziface.IAoiManager {
+	return s.aoiMgr
+}
+
+// GetScheduler exposes the scene's timer wheel and tick driver. They are
+// pumped by the scene loop and must not be started separately.
+func (s *Scene) GetScheduler() ziface.IScheduler {
+	return s.sched
+}
+
+func (s *Scene) Start() {
+	if !s.running.CompareAndSwap(false, true) {
+		return
+	}
+	go s.loop()
+	fmt.Printf("[Scene] Scene %d started.\n", s.id)
+}
+
+func (s *Scene) Stop() {
+	s.stop.Do(func() {
+		close(s.exit)
+	})
+	if s.running.Load() {
+		<-s.done
+	}
+}
+
+// Post queues task for the scene goroutine. Once Stop was called a task is
+// either still run by the final drain or rejected with ErrSceneStopped, never
+// dropped silently.
+func (s *Scene) Post(task func()) error {
+	s.postLock.RLock()
+	defer s.postLock.RUnlock()
+	if s.closed {
+		return fmt.Errorf("%w: sceneID=%d", ErrSceneStopped, s.id)
+	}
+	select {
+	case <-s.exit:
+		return fmt.Errorf("%w: sceneID=%d", ErrSceneStopped, s.id)
+	default:
+	}
+
+	select {
+	case s.mailbox <- task:
+		return nil
+	case <-s.exit:
+		return fmt.Errorf("%w: sceneID=%d", ErrSceneStopped, s.id)
+	case <-time.After(s.opts.PostTimeout):
+		return fmt.Errorf("%w: sceneID=%d", ErrMailboxFull, s.id)
+	}
+}
+
+func (s *Scene) Dispatch(request ziface.IRequest) error {
+	if s.routers == nil {
+		return fmt.Errorf("%w: sceneID=%d, msgID=%d", ErrNoSceneRouter, s.id, request.GetMsgID())
+	}
+	router, ok := s.routers(request.GetMsgID())
+	if !ok {
+		return fmt.Errorf("%w: sceneID=%d, msgID=%d", ErrNoSceneRouter, s.id, request.GetMsgID())
+	}
+
+	return s.Post(func() {
+		router.PreHandle(request)
+		router.Handle(request)
+		router.PostHandle(request)
+	})
+}
+
+// AfterFunc runs task on the scene goroutine after d. The returned cancel is
+// safe to call from any goroutine, including after the task already ran.
+func (s *Scene) AfterFunc(d time.Duration, task func()) (cancel func()) {
+	id := s.sched.AfterFunc(d, task)
+	return func() {
+		s.sched.Cancel(id)
+	}
+}
+
+// viewRangeSetter is implemented by AOI managers whose view range follows
+// config reloads.
+type viewRangeSetter interface {
+	SetViewRange(viewRange float32)
+}
+
+func (s *Scene) loop() {
+	defer close(s.done)
+
+	if aoiMgr, ok := s.aoiMgr.(viewRangeSetter); ok {
+		unsubscribe := s.opts.ConfigStore.OnAOIChange(func(old, cfg config.AOIConfig) {
+			if old.ViewRange != cfg.ViewRange {
+				aoiMgr.SetViewRange(cfg.ViewRange)
+			}
+		})
+		defer unsubscribe()
+	}
+
+	if s.opts.OnStart != nil {
+		s.safeRun(func() { s.opts.OnStart(s) })
+	}
+
+	ticker := time.NewTicker(s.sched.Step())
+	defer ticker.Stop()
+	s.sched.Pump(time.Now())
+
+	for {
+		select {
+		case task := <-s.mailbox:
+			s.safeRun(task)
+		case now := <-ticker.C:
+			s.sched.Pump(now)
+		case <-s.exit:
+			s.postLock.Lock()
+			s.closed = true
+			s.postLock.Unlock()
+			for {
+				select {
+				case task := <-s.mailbox:
+					s.safeRun(task)
+					continue
+				default:
+				}
+				break
+			}
+			if s.opts.OnStop != nil {
+				s.safeRun(func() { s.opts.OnStop(s) })
+			}
+			fmt.Printf("[Scene] Scene %d stopped.\n", s.id)
+			return
+		}
+	}
+}
+
+func (s *Scene) safeRun(task func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Scene] Scene %d task panic: %v\n", s.id, err)
+		}
+	}()
+	task()
+}
@@ -0,0 +1,225 @@
+package scene_test
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"zinxplusplus/scene"
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+	"zinxplusplus/ztest"
+)
+
+const msgMove uint32 = 1
+
+// goid returns the id of the calling goroutine from its stack header.
+func goid() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	id, _ := strconv.ParseUint(string(buf[:bytes.IndexByte(buf, ' ')]), 10, 64)
+	return id
+}
+
+// goroutines records which goroutine each kind of callback ran on.
+type goroutines struct {
+	lock sync.Mutex
+	seen map[string]map[uint64]bool
+}
+
+func (g *goroutines) record(kind string) {
+	id := goid()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.seen[kind] == nil {
+		g.seen[kind] = make(map[uint64]bool)
+	}
+	g.seen[kind][id] = true
+}
+
+func (g *goroutines) snapshot() map[string]map[uint64]bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	out := make(map[string]map[uint64]bool, len(g.seen))
+	for kind, ids := range g.seen {
+		out[kind] = make(map[uint64]bool, len(ids))
+		for id := range ids {
+			out[kind][id] = true
+		}
+	}
+	return out
+}
+
+type recordRouter struct {
+	znet.BaseRouter
+	g *goroutines
+}
+
+func (r *recordRouter) Handle(request ziface.IRequest) {
+	r.g.record("request")
+}
+
+func TestSceneRunsEverythingOnOneGoroutine(t *testing.T) {
+	g := &goroutines{seen: make(map[string]map[uint64]bool)}
+	mgr := scene.NewSceneManager(scene.WithTick(5*time.Millisecond, func(ziface.IScene, time.Duration) {
+		g.record("tick")
+	}))
+	defer mgr.StopAll()
+	mgr.AddRouter(msgMove, &recordRouter{g: g})
+
+	s, err := mgr.CreateScene(7)
+	if err != nil {
+		t.Fatalf("CreateScene: %v", err)
+	}
+	s.(*scene.Scene).AfterFunc(5*time.Millisecond, func() { g.record("timer") })
+
+	conn := ztest.NewFakeConnection(1)
+	conn.SetProperty(scene.PropKeySceneID, uint64(7))
+	forward := scene.NewForwardRouter(mgr, nil)
+
+	// Send from several goroutines at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := s.Post(func() { g.record("post") }); err != nil {
+					t.Errorf("Post: %v", err)
+				}
+				forward.Handle(ztest.Request(conn, msgMove, nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(g.snapshot()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	all := make(map[uint64]bool)
+	for kind, ids := range g.snapshot() {
+		if len(ids) != 1 {
+			t.Errorf("%s ran on %d goroutines", kind, len(ids))
+		}
+		for id := range ids {
+			all[id] = true
+		}
+	}
+	if got := len(g.snapshot()); got != 4 {
+		t.Fatalf("saw %d kinds of callback, want post, request, tick and timer: %v", got, g.snapshot())
+	}
+	if len(all) != 1 {
+		t.Fatalf("callbacks ran on %d different goroutines", len(all))
+	}
+}
+
+func TestSceneStopDrainsThenRejects(t *testing.T) {
+	s := scene.NewScene(1)
+	s.Start()
+
+	release := make(chan struct{})
+	var ran int
+	if err := s.Post(func() { <-release }); err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Post(func() { ran++ }); err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+
+	if ran != 10 {
+		t.Fatalf("%d of 10 queued tasks ran before Stop returned", ran)
+	}
+	if err := s.Post(func() { t.Error("task posted after Stop ran") }); !errors.Is(err, scene.ErrSceneStopped) {
+		t.Fatalf("Post after Stop = %v, want ErrSceneStopped", err)
+	}
+}
+
+func TestScenePostRacingStopIsNeverLost(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		s := scene.NewScene(uint64(round))
+		s.Start()
+
+		var lock sync.Mutex
+		accepted, ran := 0, 0
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					err := s.Post(func() {
+						lock.Lock()
+						ran++
+						lock.Unlock()
+					})
+					if err == nil {
+						lock.Lock()
+						accepted++
+						lock.Unlock()
+					} else if !errors.Is(err, scene.ErrSceneStopped) {
+						t.Errorf("Post: %v", err)
+					}
+				}
+			}()
+		}
+		s.Stop()
+		wg.Wait()
+
+		lock.Lock()
+		if accepted != ran {
+			t.Fatalf("round %d: %d posts accepted but %d ran", round, accepted, ran)
+		}
+		lock.Unlock()
+	}
+}
+
+func TestForwardToUnknownScene(t *testing.T) {
+	mgr := scene.NewSceneManager()
+	defer mgr.StopAll()
+	mgr.AddRouter(msgMove, &recordRouter{g: &goroutines{seen: make(map[string]map[uint64]bool)}})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- mgr.Forward(42, ztest.Request(ztest.NewFakeConnection(1), msgMove, nil))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, scene.ErrSceneNotFound) {
+			t.Fatalf("Forward = %v, want ErrSceneNotFound", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Forward to an unknown scene blocked")
+	}
+
+	// A scene that was removed is unknown too.
+	if _, err := mgr.CreateScene(43); err != nil {
+		t.Fatalf("CreateScene: %v", err)
+	}
+	if err := mgr.RemoveScene(43); err != nil {
+		t.Fatalf("RemoveScene: %v", err)
+	}
+	if err := mgr.Forward(43, ztest.Request(ztest.NewFakeConnection(1), msgMove, nil)); !errors.Is(err, scene.ErrSceneNotFound) {
+		t.Fatalf("Forward to a removed scene = %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"zinxplusplus/ziface"
+)
+
+// Encoded objects start with a small header:
+//
+//	0xF5 | serializer id | serializer version | payload
+//	0xF6 | serializer id | serializer version | schema version (uvarint) | payload
+//
+// The second form is written for keys with a schema (see SchemaRegistry).
+// Neither byte starts valid UTF-8, so blobs written before the header
+// existed (plain JSON) are told apart and still decode as JSON.
+const (
+	codecMagic       byte = 0xF5
+	codecSchemaMagic byte = 0xF6
+	codecHeaderSize       = 3
+)
+
+// Codecs picks the serializer for a key: the one registered for the longest
+// matching key prefix, else the default. Decoding ignores the choice and
+// uses whichever serializer the blob's header names, so switching a prefix
+// to another serializer keeps old data readable.
+type Codecs struct {
+	def      ziface.ISerializer
+	prefixes []codecPrefix // longest first
+	schemas  *SchemaRegistry
+	lock     sync.RWMutex
+}
+
+type codecPrefix struct {
+	prefix     string
+	serializer ziface.ISerializer
+}
+
+func NewCodecs(def ziface.ISerializer) *Codecs {
+	return &Codecs{def: def}
+}
+
+// DefaultCodecs encodes everything as JSON.
+func DefaultCodecs() *Codecs {
+	return NewCodecs(JSONSerializer)
+}
+
+// Use encodes keys starting with prefix with s.
+func (c *Codecs) Use(prefix string, s ziface.ISerializer) *Codecs {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range c.prefixes {
+		if c.prefixes[i].prefix == prefix {
+			c.prefixes[i].serializer = s
+			return c
+		}
+	}
+	c.prefixes = append(c.prefixes, codecPrefix{prefix: prefix, serializer: s})
+	sort.SliceStable(c.prefixes, func(i, j int) bool {
+		return len(c.prefixes[i].prefix) > len(c.prefixes[j].prefix)
+	})
+	return c
+}
+
+// UseSchemas stamps objects with their schema version on write and migrates
+// older ones on read. Keys with a schema must use a DocumentSerializer;
+// Marshal rejects the rest with ErrNotMigratable, since their objects could
+// never be migrated.
+func (c *Codecs) UseSchemas(schemas *SchemaRegistry) *Codecs {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.schemas = schemas
+	return c
+}
+
+func (c *Codecs) Schemas() *SchemaRegistry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.schemas
+}
+
+func (c *Codecs) For(key string) ziface.ISerializer {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, p := range c.prefixes {
+		if strings.HasPrefix(key, p.prefix) {
+			return p.serializer
+		}
+	}
+	return c.def
+}
+
+func (c *Codecs) Marshal(key string, v interface{}) ([]byte, error) {
+	s := c.For(key)
+	schema := c.Schemas().For(key)
+	if schema != nil && !supportsDocuments(s) {
+		return nil, fmt.Errorf("%w: key=%s has schema %s but codec=%s", ErrNotMigratable, key, schema.Prefix, s.Name())
+	}
+	payload, err := s.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key=%s, codec=%s, type=%T: %v", ErrSerializationFailed, key, s.Name(), v, err)
+	}
+	return encodeBlob(s, schema, payload), nil
+}
+
+// Unmarshal decodes data into v, first migrating it when it was written with
+// an older schema version. The stored blob is not rewritten.
+func (c *Codecs) Unmarshal(key string, data []byte, v interface{}) error {
+	h, err := parseBlob(data)
+	if err != nil {
+		return fmt.Errorf("%w: key=%s: %w", ErrDeserializationFailed, key, err)
+	}
+	payload := h.payload
+	if schema := c.Schemas().For(key); schema != nil && h.schemaVersion != schema.Version {
+		if payload, err = migratePayload(h, schema, h.serializer); err != nil {
+			return fmt.Errorf("%w: key=%s: %w", ErrDeserializationFailed, key, err)
+		}
+	}
+	if err := h.serializer.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: key=%s, codec=%s, targetType=%T: %v", ErrDeserializationFailed, key, h.serializer.Name(), v, err)
+	}
+	return nil
+}
+
+// Upgrade rewrites a stored blob to the current schema version and header,
+// re-encoding it with to when given (else with the serializer that wrote
+// it). changed is false when the blob is already current. Migrating or
+// re-encoding a blob fails with ErrNotMigratable unless both serializers
+// are DocumentSerializers.
+func (c *Codecs) Upgrade(key string, data []byte, to ziface.ISerializer) (upgraded []byte, changed bool, err error) {
+	h, err := parseBlob(data)
+	if err != nil {
+		return nil, false, err
+	}
+	schema := c.Schemas().For(key)
+	target := h.serializer
+	if to != nil {
+		target = to
+	}
+
+	stamp := schema
+	if stamp == nil && h.schemaVersion > 1 {
+		// No schema for the key any more; keep the version it was written with.
+		stamp = &Schema{Prefix: key, Version: h.schemaVersion}
+	}
+
+	payload := h.payload
+	if (schema != nil && h.schemaVersion != schema.Version) || target.ID() != h.serializer.ID() {
+		steps := schema
+		if steps == nil {
+			steps = &Schema{Prefix: key, Version: h.schemaVersion}
+		}
+		if payload, err = migratePayload(h, steps, target); err != nil {
+			return nil, false, err
+		}
+	}
+	upgraded = encodeBlob(target, stamp, payload)
+	return upgraded, !bytes.Equal(upgraded, data), nil
+}
+
+// migratePayload decodes h generically, runs schema's migrations and encodes
+// the result with to.
+func migratePayload(h blobHeader, schema *Schema, to ziface.ISerializer) ([]byte, error) {
+	if h.schemaVersion > schema.Version {
+		return nil, fmt.Errorf("%w: %s v%d, current v%d", ErrSchemaTooNew, schema.Prefix, h.schemaVersion, schema.Version)
+	}
+	for _, s := range []ziface.ISerializer{h.serializer, to} {
+		if !supportsDocuments(s) {
+			return nil, fmt.Errorf("%w: %s", ErrNotMigratable, s.Name())
+		}
+	}
+	doc, err := decodeDocument(h.serializer, h.payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMigrationFailed, err)
+	}
+	if err := schema.migrate(doc, h.schemaVersion); err != nil {
+		return nil, err
+	}
+	if to.ID() != SerializerJSON {
+		normalizeNumbers(doc)
+	}
+	payload, err := to.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: re-encode as %s: %v", ErrMigrationFailed, to.Name(), err)
+	}
+	return payload, nil
+}
+
+type blobHeader struct {
+	serializer    ziface.ISerializer
+	schemaVersion uint32
+	payload       []byte
+}
+
+func encodeBlob(s ziface.ISerializer, schema *Schema, payload []byte) []byte {
+	if schema == nil {
+		data := make([]byte, codecHeaderSize+len(payload))
+		data[0], data[1], data[2] = codecMagic, s.ID(), s.Version()
+		copy(data[codecHeaderSize:], payload)
+		return data
+	}
+	data := make([]byte, codecHeaderSize, codecHeaderSize+binary.MaxVarintLen32+len(payload))
+	data[0], data[1], data[2] = codecSchemaMagic, s.ID(), s.Version()
+	data = binary.AppendUvarint(data, uint64(schema.Version))
+	return append(data, payload...)
+}
+
+func parseBlob(data []byte) (blobHeader, error) {
+	if len(data) == 0 || (data[0] != codecMagic && data[0] != codecSchemaMagic) {
+		return blobHeader{serializer: JSONSerializer, schemaVersion: 1, payload: data}, nil
+	}
+	if len(data) < codecHeaderSize {
+		return blobHeader{}, fmt.Errorf("truncated codec header")
+	}
+	s, err := SerializerByID(data[1])
+	if err != nil {
+		return blobHeader{}, err
+	}
+	if data[2] > s.Version() {
+		return blobHeader{}, fmt.Errorf("%w: %s v%d, supported up to v%d", ErrUnsupportedCodecVersion, s.Name(), data[2], s.Version())
+	}
+
+	h := blobHeader{serializer: s, schemaVersion: 1, payload: data[codecHeaderSize:]}
+	if data[0] == codecSchemaMagic {
+		version, n := binary.Uvarint(h.payload)
+		if n <= 0 || version == 0 || version > 1<<32-1 {
+			return blobHeader{}, fmt.Errorf("bad schema version in codec header")
+		}
+		h.schemaVersion = uint32(version)
+		h.payload = h.payload[n:]
+	}
+	return h, nil
+}
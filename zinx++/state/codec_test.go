@@ -0,0 +1,221 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"zinxplusplus/ziface"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type profile struct {
+	ID    uint64  `json:"id"`
+	Name  string  `json:"name"`
+	Level int     `json:"level"`
+	Speed float64 `json:"speed"`
+	Bag   []item  `json:"bag"`
+}
+
+var testProfile = profile{
+	ID:    1<<63 + 5, // does not fit in a float64 or an int64
+	Name:  "ann",
+	Level: 7,
+	Speed: 1.5,
+	Bag:   []item{{"potion", 3}, {"sword", 1}},
+}
+
+var allSerializers = []ziface.ISerializer{JSONSerializer, GobSerializer, MsgPackSerializer, ProtobufSerializer}
+
+func TestSerializerRoundTrip(t *testing.T) {
+	for _, s := range allSerializers {
+		t.Run(s.Name(), func(t *testing.T) {
+			codecs := NewCodecs(s)
+			if s == ProtobufSerializer {
+				data, err := codecs.Marshal("k", wrapperspb.String("hello"))
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				got := &wrapperspb.StringValue{}
+				if err := codecs.Unmarshal("k", data, got); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if !proto.Equal(got, wrapperspb.String("hello")) {
+					t.Fatalf("got %v", got)
+				}
+				return
+			}
+
+			data, err := codecs.Marshal("k", testProfile)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var got profile
+			// Decoding follows the header, not the codecs' own choice.
+			if err := DefaultCodecs().Unmarshal("k", data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, testProfile) {
+				t.Fatalf("got %+v, want %+v", got, testProfile)
+			}
+		})
+	}
+}
+
+// TestUpgradeRecode re-encodes a blob between every pair of serializers. It
+// must either round-trip into the original struct or refuse.
+func TestUpgradeRecode(t *testing.T) {
+	codecs := DefaultCodecs()
+	for _, from := range allSerializers {
+		if from == ProtobufSerializer {
+			continue // needs a message type, covered by the refusal below
+		}
+		data, err := NewCodecs(from).Marshal("k", testProfile)
+		if err != nil {
+			t.Fatalf("%s Marshal: %v", from.Name(), err)
+		}
+		for _, to := range allSerializers {
+			t.Run(from.Name()+"_to_"+to.Name(), func(t *testing.T) {
+				upgraded, changed, err := codecs.Upgrade("k", data, to)
+				if from != to && (!supportsDocuments(from) || !supportsDocuments(to)) {
+					if !errors.Is(err, ErrNotMigratable) {
+						t.Fatalf("err = %v, want ErrNotMigratable", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Upgrade: %v", err)
+				}
+				if changed != (from != to) {
+					t.Fatalf("changed = %v", changed)
+				}
+				var got profile
+				if err := codecs.Unmarshal("k", upgraded, &got); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if !reflect.DeepEqual(got, testProfile) {
+					t.Fatalf("got %+v, want %+v", got, testProfile)
+				}
+			})
+		}
+	}
+
+	data, err := NewCodecs(ProtobufSerializer).Marshal("k", wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("protobuf Marshal: %v", err)
+	}
+	if _, _, err := codecs.Upgrade("k", data, JSONSerializer); !errors.Is(err, ErrNotMigratable) {
+		t.Fatalf("protobuf to json: err = %v, want ErrNotMigratable", err)
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	type v2 struct {
+		Name  string `json:"name"`
+		Level int    `json:"level"`
+	}
+	for _, s := range []ziface.ISerializer{JSONSerializer, MsgPackSerializer} {
+		t.Run(s.Name(), func(t *testing.T) {
+			schemas := NewSchemaRegistry()
+			schemas.Define("p:", 1)
+			old, err := NewCodecs(s).UseSchemas(schemas).Marshal("p:1", map[string]interface{}{"name": "ann", "lvl": 3})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			schemas.Define("p:", 2).Migration(1, func(doc map[string]interface{}) error {
+				doc["level"] = doc["lvl"]
+				delete(doc, "lvl")
+				return nil
+			})
+			codecs := NewCodecs(s).UseSchemas(schemas)
+			var got v2
+			if err := codecs.Unmarshal("p:1", old, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got != (v2{"ann", 3}) {
+				t.Fatalf("got %+v", got)
+			}
+
+			upgraded, changed, err := codecs.Upgrade("p:1", old, nil)
+			if err != nil || !changed {
+				t.Fatalf("Upgrade = %v, %v", changed, err)
+			}
+			got = v2{}
+			if err := NewCodecs(s).Unmarshal("p:1", upgraded, &got); err != nil || got != (v2{"ann", 3}) {
+				t.Fatalf("upgraded blob decodes to %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestMigratePrefixRejectsRecodeTarget(t *testing.T) {
+	ctx := context.Background()
+	sm := NewMemoryStateAdapter()
+	data, err := DefaultCodecs().Marshal("p:1", testProfile)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := sm.SetState(ctx, "p:1", data, 0); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	for _, to := range []ziface.ISerializer{GobSerializer, ProtobufSerializer} {
+		stats, err := MigratePrefix(ctx, sm, DefaultCodecs(), "p:", MigrateOptions{Recode: to})
+		if !errors.Is(err, ErrNotMigratable) || stats.Scanned != 0 {
+			t.Fatalf("recode to %s: stats %+v, err %v; want ErrNotMigratable before scanning", to.Name(), stats, err)
+		}
+	}
+
+	stats, err := MigratePrefix(ctx, sm, DefaultCodecs(), "p:", MigrateOptions{Recode: MsgPackSerializer})
+	if err != nil || stats.Migrated != 1 {
+		t.Fatalf("recode to msgpack: stats %+v, err %v", stats, err)
+	}
+	stored, _ := sm.GetState(ctx, "p:1")
+	var got profile
+	if err := DefaultCodecs().Unmarshal("p:1", stored, &got); err != nil || !reflect.DeepEqual(got, testProfile) {
+		t.Fatalf("migrated blob decodes to %+v, %v", got, err)
+	}
+}
+
+func TestSchemaRejectsOpaqueSerializer(t *testing.T) {
+	schemas := NewSchemaRegistry()
+	schemas.Define("p:", 2)
+
+	for _, s := range []ziface.ISerializer{GobSerializer, ProtobufSerializer} {
+		t.Run(s.Name(), func(t *testing.T) {
+			var v interface{} = testProfile
+			if s == ProtobufSerializer {
+				v = wrapperspb.String("ann")
+			}
+
+			// As the default serializer and through a prefix.
+			for _, codecs := range []*Codecs{
+				NewCodecs(s).UseSchemas(schemas),
+				DefaultCodecs().Use("p:", s).UseSchemas(schemas),
+			} {
+				if _, err := codecs.Marshal("p:1", v); !errors.Is(err, ErrNotMigratable) {
+					t.Fatalf("Marshal of a schema key = %v, want ErrNotMigratable", err)
+				}
+				// Keys without a schema are unaffected.
+				if _, err := codecs.Marshal("q:1", v); err != nil {
+					t.Fatalf("Marshal of a key without a schema: %v", err)
+				}
+			}
+		})
+	}
+
+	// A prefix that overrides the schema key's serializer lifts the check.
+	codecs := NewCodecs(GobSerializer).Use("p:", JSONSerializer).UseSchemas(schemas)
+	if _, err := codecs.Marshal("p:1", testProfile); err != nil {
+		t.Fatalf("Marshal with a document serializer: %v", err)
+	}
+}
@@ -10,4 +10,34 @@ var (
 	ErrSerializationFailed = errors.New("failed to serialize object")
 
 	ErrDeserializationFailed = errors.New("failed to deserialize object")
+
+	ErrVersionMismatch = errors.New("state version mismatch")
+
+	ErrWrongType = errors.New("state key holds a different kind of value")
+
+	ErrNotInteger = errors.New("state value is not an integer")
+
+	ErrUnknownAdapter = errors.New("unknown state adapter")
+
+	ErrLockHeld = errors.New("lock is held by another owner")
+
+	ErrLockNotHeld = errors.New("lock is not held by this owner")
+
+	ErrInvalidLockTTL = errors.New("lock ttl must be positive")
+
+	ErrUnknownSerializer = errors.New("unknown serializer")
+
+	ErrUnsupportedCodecVersion = errors.New("state written by a newer serializer version")
+
+	ErrSchemaTooNew = errors.New("state written by a newer schema version")
+
+	ErrNoMigration = errors.New("no migration registered")
+
+	ErrMigrationFailed = errors.New("state migration failed")
+
+	ErrNotMigratable = errors.New("serializer cannot carry migrated documents")
+
+	ErrBadSQLConfig = errors.New("invalid sql state config")
+
+	ErrSQLFailed = errors.New("sql statement failed")
 )
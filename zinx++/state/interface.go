@@ -3,3 +3,17 @@ package state
 import "zinxplusplus/ziface"
 
 type IStateManager = ziface.IStateManager
+
+type IExtendedStateManager = ziface.IExtendedStateManager
+
+type IStateBatch = ziface.IStateBatch
+
+type ILocker = ziface.ILocker
+
+type ILock = ziface.ILock
+
+type ISerializer = ziface.ISerializer
+
+// KeepTTL as the expiration of SetState, MSetState or a batch Set keeps the
+// key's current TTL instead of clearing it. Other values up to 0 mean no TTL.
+const KeepTTL int64 = -1
@@ -0,0 +1,235 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+const defaultLockRetryInterval = 50 * time.Millisecond
+
+// lockBackend stores lock ownership. acquire returns ErrLockHeld when another
+// owner holds key; refresh and release return ErrLockNotHeld when owner no
+// longer does.
+type lockBackend interface {
+	acquire(ctx context.Context, key, owner string, ttl time.Duration) (token uint64, err error)
+	refresh(ctx context.Context, key, owner string, ttl time.Duration) error
+	release(ctx context.Context, key, owner string) error
+}
+
+type LockerOption func(*LockerOptions)
+
+type LockerOptions struct {
+	// RetryInterval is the mean wait between attempts in Lock and Lease;
+	// each wait is jittered by ±50%.
+	RetryInterval time.Duration
+}
+
+func WithLockRetryInterval(d time.Duration) LockerOption {
+	return func(o *LockerOptions) {
+		o.RetryInterval = d
+	}
+}
+
+type locker struct {
+	backend lockBackend
+	opts    LockerOptions
+}
+
+func newLocker(backend lockBackend, opts []LockerOption) *locker {
+	l := &locker{
+		backend: backend,
+		opts:    LockerOptions{RetryInterval: defaultLockRetryInterval},
+	}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	if l.opts.RetryInterval <= 0 {
+		l.opts.RetryInterval = defaultLockRetryInterval
+	}
+	return l
+}
+
+// NewLocker returns a locker on the same backend as sm.
+func NewLocker(sm ziface.IStateManager, opts ...LockerOption) (ziface.ILocker, error) {
+	switch m := sm.(type) {
+	case *RedisStateAdapter:
+		return NewRedisLocker(m.client, opts...), nil
+	case *MemoryStateAdapter:
+		return newLocker(m.locks, opts), nil
+	default:
+		return nil, fmt.Errorf("%w: no lock support for %T", ErrUnknownAdapter, sm)
+	}
+}
+
+func newLockOwner() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("generate lock owner err: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (l *locker) TryLock(ctx context.Context, key string, ttl time.Duration) (ziface.ILock, error) {
+	return l.tryLock(ctx, key, ttl)
+}
+
+func (l *locker) tryLock(ctx context.Context, key string, ttl time.Duration) (*lock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%w: key=%s, ttl=%v", ErrInvalidLockTTL, key, ttl)
+	}
+	owner := newLockOwner()
+	token, err := l.backend.acquire(ctx, key, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &lock{
+		backend: l.backend,
+		key:     key,
+		owner:   owner,
+		token:   token,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (l *locker) Lock(ctx context.Context, key string, ttl time.Duration) (ziface.ILock, error) {
+	return l.lock(ctx, key, ttl)
+}
+
+func (l *locker) lock(ctx context.Context, key string, ttl time.Duration) (*lock, error) {
+	for {
+		lk, err := l.tryLock(ctx, key, ttl)
+		if err == nil {
+			return lk, nil
+		}
+		// The context may run out inside the backend call, whose error does
+		// not always wrap it; report that the same way as a wait cut short.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%w: key=%s: %w", ErrLockHeld, key, ctxErr)
+		}
+		if !errors.Is(err, ErrLockHeld) {
+			return nil, err
+		}
+
+		wait := l.opts.RetryInterval/2 + time.Duration(mathrand.Int63n(int64(l.opts.RetryInterval)))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: key=%s: %w", ErrLockHeld, key, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Lease blocks like Lock, then renews the lock every ttl/3 until Release.
+// If renewal keeps failing for a whole ttl, or the lock turns out to belong
+// to someone else, the lease is lost and Done is closed.
+func (l *locker) Lease(ctx context.Context, key string, ttl time.Duration) (ziface.ILock, error) {
+	lk, err := l.lock(ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	lk.stop = make(chan struct{})
+	go lk.keepAlive(ttl)
+	return lk, nil
+}
+
+type lock struct {
+	backend lockBackend
+	key     string
+	owner   string
+	token   uint64
+
+	done     chan struct{}
+	doneOnce sync.Once
+	stop     chan struct{} // nil unless leased
+	stopOnce sync.Once
+}
+
+func (lk *lock) Key() string {
+	return lk.key
+}
+
+func (lk *lock) Token() uint64 {
+	return lk.token
+}
+
+func (lk *lock) Done() <-chan struct{} {
+	return lk.done
+}
+
+func (lk *lock) markDone() {
+	lk.doneOnce.Do(func() { close(lk.done) })
+}
+
+func (lk *lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: key=%s, ttl=%v", ErrInvalidLockTTL, lk.key, ttl)
+	}
+	err := lk.backend.refresh(ctx, lk.key, lk.owner, ttl)
+	if errors.Is(err, ErrLockNotHeld) {
+		lk.markDone()
+	}
+	return err
+}
+
+func (lk *lock) Release(ctx context.Context) error {
+	if lk.stop != nil {
+		lk.stopOnce.Do(func() { close(lk.stop) })
+	}
+	err := lk.backend.release(ctx, lk.key, lk.owner)
+	if err == nil || errors.Is(err, ErrLockNotHeld) {
+		lk.markDone()
+	}
+	return err
+}
+
+func (lk *lock) keepAlive(ttl time.Duration) {
+	interval := ttl / 3
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(ttl)
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-lk.done:
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := lk.backend.refresh(ctx, lk.key, lk.owner, ttl)
+		cancel()
+
+		select {
+		case <-lk.stop:
+			return
+		default:
+		}
+
+		switch {
+		case err == nil:
+			deadline = sent.Add(ttl)
+		case errors.Is(err, ErrLockNotHeld) || !time.Now().Before(deadline):
+			fmt.Printf("[Lock] Lease on %s lost: %v\n", lk.key, err)
+			lk.markDone()
+			return
+		default:
+			fmt.Printf("[Lock] Renewing lease on %s failed, will retry: %v\n", lk.key, err)
+		}
+	}
+}
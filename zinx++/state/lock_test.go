@@ -0,0 +1,302 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeClock is a settable time source for the memory backend.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// lockFixture is a locker plus a way to move its backend's clock forward, so
+// expiry does not depend on the wall clock.
+type lockFixture struct {
+	locker  *locker
+	advance func(d time.Duration)
+}
+
+// lockBackends runs fn against the memory backend and a Redis backend on
+// miniredis.
+func lockBackends(t *testing.T, fn func(t *testing.T, f lockFixture)) {
+	t.Run("memory", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		backend := newMemoryLockBackend()
+		backend.now = clock.Now
+		fn(t, lockFixture{
+			locker:  newLocker(backend, []LockerOption{WithLockRetryInterval(10 * time.Millisecond)}),
+			advance: clock.Advance,
+		})
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		fn(t, lockFixture{
+			locker:  newLocker(&redisLockBackend{client: client}, []LockerOption{WithLockRetryInterval(10 * time.Millisecond)}),
+			advance: mr.FastForward,
+		})
+	})
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestLockFencingTokens(t *testing.T) {
+	lockBackends(t, func(t *testing.T, f lockFixture) {
+		ctx := context.Background()
+
+		first, err := f.locker.tryLock(ctx, "room:1", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock: %v", err)
+		}
+		if _, err := f.locker.tryLock(ctx, "room:1", time.Second); !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("TryLock of a held key = %v, want ErrLockHeld", err)
+		}
+
+		// The first holder stalls past its ttl and the lock changes hands.
+		f.advance(2 * time.Second)
+		second, err := f.locker.tryLock(ctx, "room:1", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock after expiry: %v", err)
+		}
+		if second.Token() <= first.Token() {
+			t.Fatalf("token after expiry = %d, want more than %d", second.Token(), first.Token())
+		}
+
+		// Releasing does not reset the counter either.
+		if err := second.Release(ctx); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		third, err := f.locker.tryLock(ctx, "room:1", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock after Release: %v", err)
+		}
+		if third.Token() <= second.Token() {
+			t.Fatalf("token after Release = %d, want more than %d", third.Token(), second.Token())
+		}
+
+		// Keys are fenced independently.
+		other, err := f.locker.tryLock(ctx, "room:2", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock of another key: %v", err)
+		}
+		if other.Token() != 1 {
+			t.Fatalf("first token of another key = %d, want 1", other.Token())
+		}
+
+		if _, err := f.locker.tryLock(ctx, "room:3", 0); !errors.Is(err, ErrInvalidLockTTL) {
+			t.Fatalf("TryLock with a zero ttl = %v, want ErrInvalidLockTTL", err)
+		}
+	})
+}
+
+func TestLockNonOwner(t *testing.T) {
+	lockBackends(t, func(t *testing.T, f lockFixture) {
+		ctx := context.Background()
+
+		stale, err := f.locker.tryLock(ctx, "room:1", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock: %v", err)
+		}
+		f.advance(2 * time.Second)
+		current, err := f.locker.tryLock(ctx, "room:1", time.Second)
+		if err != nil {
+			t.Fatalf("TryLock after expiry: %v", err)
+		}
+
+		// The stale holder can neither extend nor drop the new owner's lock.
+		if err := stale.Refresh(ctx, time.Minute); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("Refresh by a former owner = %v, want ErrLockNotHeld", err)
+		}
+		if !isClosed(stale.Done()) {
+			t.Fatal("Done not closed after Refresh found the lock lost")
+		}
+		if err := stale.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("Release by a former owner = %v, want ErrLockNotHeld", err)
+		}
+		if _, err := f.locker.tryLock(ctx, "room:1", time.Second); !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("former owner's Release freed the lock: %v", err)
+		}
+
+		// The same holds for an owner that never had the lock.
+		if err := f.locker.backend.refresh(ctx, "room:1", "intruder", time.Minute); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("refresh by a stranger = %v, want ErrLockNotHeld", err)
+		}
+		if err := f.locker.backend.release(ctx, "room:1", "intruder"); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("release by a stranger = %v, want ErrLockNotHeld", err)
+		}
+
+		// The owner can refresh; the refreshed ttl outlasts the original one.
+		if err := current.Refresh(ctx, 5*time.Second); err != nil {
+			t.Fatalf("Refresh by the owner: %v", err)
+		}
+		f.advance(2 * time.Second)
+		if _, err := f.locker.tryLock(ctx, "room:1", time.Second); !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("TryLock within the refreshed ttl = %v, want ErrLockHeld", err)
+		}
+		if err := current.Release(ctx); err != nil {
+			t.Fatalf("Release by the owner: %v", err)
+		}
+		if !isClosed(current.Done()) {
+			t.Fatal("Done not closed after Release")
+		}
+		if err := current.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("second Release = %v, want ErrLockNotHeld", err)
+		}
+	})
+}
+
+func TestLockContextCancel(t *testing.T) {
+	lockBackends(t, func(t *testing.T, f lockFixture) {
+		held, err := f.locker.tryLock(context.Background(), "room:1", time.Minute)
+		if err != nil {
+			t.Fatalf("TryLock: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		begin := time.Now()
+		_, err = f.locker.Lock(ctx, "room:1", time.Minute)
+		if !errors.Is(err, ErrLockHeld) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Lock past its deadline = %v, want ErrLockHeld and DeadlineExceeded", err)
+		}
+		if waited := time.Since(begin); waited > time.Second {
+			t.Fatalf("Lock returned %v after its deadline", waited)
+		}
+
+		// A cancel while waiting ends the wait too.
+		ctx, cancel = context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() {
+			_, err := f.locker.Lease(ctx, "room:1", time.Minute)
+			errc <- err
+		}()
+		time.Sleep(30 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Lease after cancel = %v, want context.Canceled", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Lease did not return after its context was cancelled")
+		}
+
+		// A waiting Lock gets the key once it is released.
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			held.Release(context.Background())
+		}()
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		next, err := f.locker.Lock(ctx, "room:1", time.Minute)
+		if err != nil {
+			t.Fatalf("Lock after Release: %v", err)
+		}
+		if next.Token() <= held.Token() {
+			t.Fatalf("token = %d, want more than %d", next.Token(), held.Token())
+		}
+	})
+}
+
+func TestLeaseRenews(t *testing.T) {
+	lockBackends(t, func(t *testing.T, f lockFixture) {
+		ctx := context.Background()
+		const ttl = 150 * time.Millisecond
+
+		lease, err := f.locker.Lease(ctx, "room:1", ttl)
+		if err != nil {
+			t.Fatalf("Lease: %v", err)
+		}
+
+		// The backend clock moves four ttls while the lease renews every
+		// ttl/3 of wall time; without renewal the lock would expire.
+		for i := 0; i < 12; i++ {
+			time.Sleep(ttl / 3)
+			f.advance(ttl / 3)
+			if isClosed(lease.Done()) {
+				t.Fatalf("lease lost after %d steps", i+1)
+			}
+		}
+		if _, err := f.locker.tryLock(ctx, "room:1", ttl); !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("TryLock of a leased key = %v, want ErrLockHeld", err)
+		}
+
+		if err := lease.Release(ctx); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		if !isClosed(lease.Done()) {
+			t.Fatal("Done not closed after Release")
+		}
+		if _, err := f.locker.tryLock(ctx, "room:1", ttl); err != nil {
+			t.Fatalf("TryLock after releasing the lease: %v", err)
+		}
+	})
+}
+
+func TestLeaseLost(t *testing.T) {
+	lockBackends(t, func(t *testing.T, f lockFixture) {
+		ctx := context.Background()
+		const ttl = 150 * time.Millisecond
+
+		lease, err := f.locker.Lease(ctx, "room:1", ttl)
+		if err != nil {
+			t.Fatalf("Lease: %v", err)
+		}
+
+		// The backend expires the lock between renewals and someone else
+		// takes it; the next renewal finds it gone.
+		f.advance(time.Minute)
+		thief, err := f.locker.tryLock(ctx, "room:1", time.Minute)
+		if err != nil && !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("TryLock: %v", err)
+		}
+
+		select {
+		case <-lease.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done not closed after the lease was lost")
+		}
+		if thief == nil {
+			// The renewal ran before TryLock and found the lock expired.
+			if thief, err = f.locker.tryLock(ctx, "room:1", time.Minute); err != nil {
+				t.Fatalf("TryLock after the lease was lost: %v", err)
+			}
+		}
+
+		// Releasing the lost lease leaves the new owner's lock alone.
+		if err := lease.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("Release of a lost lease = %v, want ErrLockNotHeld", err)
+		}
+		if err := thief.Refresh(ctx, time.Minute); err != nil {
+			t.Fatalf("new owner lost the lock: %v", err)
+		}
+	})
+}
@@ -0,0 +1,447 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+type memEntry struct {
+	value     []byte
+	fields    map[string][]byte // non-nil for hashes
+	version   uint64            // non-zero for versioned keys
+	expiresAt time.Time
+}
+
+func (e *memEntry) isHash() bool {
+	return e.fields != nil && e.version == 0
+}
+
+func (e *memEntry) isString() bool {
+	return e.fields == nil && e.version == 0
+}
+
+// MemoryStateAdapter keeps state in process, mirroring the Redis adapter's
+// semantics (expiry, key kinds, versioned keys) so code written against one
+// behaves the same on the other. Meant for tests and single-server setups.
+type MemoryStateAdapter struct {
+	entries map[string]*memEntry
+	lock    sync.Mutex
+	now     func() time.Time
+
+	// locks backs NewLocker on this adapter.
+	locks *memoryLockBackend
+}
+
+func NewMemoryStateAdapter() ziface.IExtendedStateManager {
+	return &MemoryStateAdapter{
+		entries: make(map[string]*memEntry),
+		now:     time.Now,
+		locks:   newMemoryLockBackend(),
+	}
+}
+
+// NewAdapter builds the adapter named by config state.adapter. The memory
+// and Redis adapters also implement ziface.IExtendedStateManager; the SQL one
+// needs its driver imported by the application.
+func NewAdapter(adapter string, redisCfg RedisConfig, sqlCfg SQLConfig) (ziface.IStateManager, error) {
+	switch adapter {
+	case "memory", "":
+		return NewMemoryStateAdapter(), nil
+	case "redis":
+		return NewRedisStateAdapter(redisCfg)
+	case "sql":
+		a, err := OpenSQLStateAdapter(sqlCfg)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAdapter, adapter)
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+func (m *MemoryStateAdapter) expiry(expiration int64) time.Time {
+	if expiration > 0 {
+		return m.now().Add(time.Duration(expiration) * time.Second)
+	}
+	return time.Time{}
+}
+
+// getLocked returns the live entry for key, dropping it if it expired.
+func (m *MemoryStateAdapter) getLocked(key string) *memEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expiresAt.IsZero() && !m.now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+// Sweep drops expired entries. Reads expire lazily, so calling it is only
+// needed to bound memory when many keys are never read again.
+func (m *MemoryStateAdapter) Sweep() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key := range m.entries {
+		m.getLocked(key)
+	}
+}
+
+func (m *MemoryStateAdapter) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.setLocked(key, value, expiration)
+	return nil
+}
+
+func (m *MemoryStateAdapter) setLocked(key string, value []byte, expiration int64) {
+	expiresAt := m.expiry(expiration)
+	if expiration == KeepTTL {
+		if old := m.getLocked(key); old != nil {
+			expiresAt = old.expiresAt
+		}
+	}
+	m.entries[key] = &memEntry{value: copyBytes(value), expiresAt: expiresAt}
+}
+
+func (m *MemoryStateAdapter) GetState(ctx context.Context, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e := m.getLocked(key)
+	if e == nil {
+		return nil, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	if !e.isString() {
+		return nil, fmt.Errorf("%w: get key %s", ErrWrongType, key)
+	}
+	return copyBytes(e.value), nil
+}
+
+func (m *MemoryStateAdapter) DeleteState(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *MemoryStateAdapter) ExistsState(ctx context.Context, key string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.getLocked(key) != nil, nil
+}
+
+// ScanKeys reports the live keys with prefix in sorted order. fn runs
+// without the adapter lock held, so it may call back into the adapter.
+func (m *MemoryStateAdapter) ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error {
+	m.lock.Lock()
+	var keys []string
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) && m.getLocked(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	m.lock.Unlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStateAdapter) MGetState(ctx context.Context, keys []string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		// Like MGET, keys of another kind read as missing.
+		if e := m.getLocked(key); e != nil && e.isString() {
+			values[key] = copyBytes(e.value)
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryStateAdapter) MSetState(ctx context.Context, values map[string][]byte, expiration int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, value := range values {
+		m.setLocked(key, value, expiration)
+	}
+	return nil
+}
+
+func (m *MemoryStateAdapter) ExpireState(ctx context.Context, key string, expiration int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expireLocked(key, expiration)
+	return nil
+}
+
+func (m *MemoryStateAdapter) expireLocked(key string, expiration int64) {
+	if e := m.getLocked(key); e != nil {
+		e.expiresAt = m.expiry(expiration)
+	}
+}
+
+// hashLocked returns key's hash, creating it when create is set.
+func (m *MemoryStateAdapter) hashLocked(op, key string, create bool) (*memEntry, error) {
+	e := m.getLocked(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &memEntry{fields: make(map[string][]byte)}
+		m.entries[key] = e
+	}
+	if !e.isHash() {
+		return nil, fmt.Errorf("%w: %s key %s", ErrWrongType, op, key)
+	}
+	return e, nil
+}
+
+func (m *MemoryStateAdapter) HGetState(ctx context.Context, key string, field string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, err := m.hashLocked("hget", key, false)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		if val, ok := e.fields[field]; ok {
+			return copyBytes(val), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: key=%s, field=%s", ErrStateNotFound, key, field)
+}
+
+func (m *MemoryStateAdapter) HGetAllState(ctx context.Context, key string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, err := m.hashLocked("hgetall", key, false)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	fields := make(map[string][]byte, len(e.fields))
+	for field, val := range e.fields {
+		fields[field] = copyBytes(val)
+	}
+	return fields, nil
+}
+
+func (m *MemoryStateAdapter) HSetState(ctx context.Context, key string, fields map[string][]byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.hsetLocked(key, fields)
+}
+
+func (m *MemoryStateAdapter) hsetLocked(key string, fields map[string][]byte) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	e, err := m.hashLocked("hset", key, true)
+	if err != nil {
+		return err
+	}
+	for field, val := range fields {
+		e.fields[field] = copyBytes(val)
+	}
+	return nil
+}
+
+func (m *MemoryStateAdapter) HDelState(ctx context.Context, key string, fields ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.hdelLocked(key, fields...)
+}
+
+func (m *MemoryStateAdapter) hdelLocked(key string, fields ...string) error {
+	e, err := m.hashLocked("hdel", key, false)
+	if err != nil || e == nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(e.fields, field)
+	}
+	// Redis drops a hash with no fields left.
+	if len(e.fields) == 0 {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *MemoryStateAdapter) HIncrBy(ctx context.Context, key string, field string, delta int64) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.hincrLocked(key, field, delta)
+}
+
+func (m *MemoryStateAdapter) hincrLocked(key string, field string, delta int64) (int64, error) {
+	e, err := m.hashLocked("hincrby", key, true)
+	if err != nil {
+		return 0, err
+	}
+	n, err := parseCounter(e.fields[field])
+	if err != nil {
+		return 0, fmt.Errorf("%w: hincrby key %s field %s", ErrNotInteger, key, field)
+	}
+	n += delta
+	e.fields[field] = []byte(strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+func (m *MemoryStateAdapter) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.incrLocked(key, delta)
+}
+
+func (m *MemoryStateAdapter) incrLocked(key string, delta int64) (int64, error) {
+	e := m.getLocked(key)
+	if e == nil {
+		e = &memEntry{}
+		m.entries[key] = e
+	}
+	if !e.isString() {
+		return 0, fmt.Errorf("%w: incrby key %s", ErrWrongType, key)
+	}
+	n, err := parseCounter(e.value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: incrby key %s", ErrNotInteger, key)
+	}
+	n += delta
+	// Like INCRBY, the TTL is kept.
+	e.value = []byte(strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+func parseCounter(b []byte) (int64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+func (m *MemoryStateAdapter) GetVersioned(ctx context.Context, key string) ([]byte, uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e := m.getLocked(key)
+	if e == nil {
+		return nil, 0, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	if e.version == 0 {
+		return nil, 0, fmt.Errorf("%w: getversioned key %s", ErrWrongType, key)
+	}
+	return copyBytes(e.value), e.version, nil
+}
+
+func (m *MemoryStateAdapter) CompareAndSet(ctx context.Context, key string, expectedVersion uint64, value []byte, expiration int64) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var current uint64
+	if e := m.getLocked(key); e != nil {
+		if e.version == 0 {
+			return 0, fmt.Errorf("%w: cas key %s", ErrWrongType, key)
+		}
+		current = e.version
+	}
+	if current != expectedVersion {
+		return 0, fmt.Errorf("%w: key=%s, expected=%d", ErrVersionMismatch, key, expectedVersion)
+	}
+	m.entries[key] = &memEntry{
+		value:     copyBytes(value),
+		version:   current + 1,
+		expiresAt: m.expiry(expiration),
+	}
+	return current + 1, nil
+}
+
+func (m *MemoryStateAdapter) Batch() ziface.IStateBatch {
+	return &memoryBatch{m: m}
+}
+
+// memoryBatch replays the queued operations under one lock, so unlike the
+// Redis pipeline other callers never see it half applied.
+type memoryBatch struct {
+	m   *MemoryStateAdapter
+	ops []func() error
+}
+
+func (b *memoryBatch) add(op func() error) ziface.IStateBatch {
+	b.ops = append(b.ops, op)
+	return b
+}
+
+func (b *memoryBatch) Set(key string, value []byte, expiration int64) ziface.IStateBatch {
+	value = copyBytes(value)
+	return b.add(func() error { b.m.setLocked(key, value, expiration); return nil })
+}
+
+func (b *memoryBatch) Delete(key string) ziface.IStateBatch {
+	return b.add(func() error { delete(b.m.entries, key); return nil })
+}
+
+func (b *memoryBatch) Expire(key string, expiration int64) ziface.IStateBatch {
+	return b.add(func() error { b.m.expireLocked(key, expiration); return nil })
+}
+
+func (b *memoryBatch) HSet(key string, fields map[string][]byte) ziface.IStateBatch {
+	copied := make(map[string][]byte, len(fields))
+	for field, val := range fields {
+		copied[field] = copyBytes(val)
+	}
+	return b.add(func() error { return b.m.hsetLocked(key, copied) })
+}
+
+func (b *memoryBatch) HDel(key string, fields ...string) ziface.IStateBatch {
+	return b.add(func() error { return b.m.hdelLocked(key, fields...) })
+}
+
+func (b *memoryBatch) HIncrBy(key string, field string, delta int64) ziface.IStateBatch {
+	return b.add(func() error { _, err := b.m.hincrLocked(key, field, delta); return err })
+}
+
+func (b *memoryBatch) IncrBy(key string, delta int64) ziface.IStateBatch {
+	return b.add(func() error { _, err := b.m.incrLocked(key, delta); return err })
+}
+
+func (b *memoryBatch) Len() int {
+	return len(b.ops)
+}
+
+func (b *memoryBatch) Exec(ctx context.Context) error {
+	ops := b.ops
+	b.ops = nil
+
+	b.m.lock.Lock()
+	defer b.m.lock.Unlock()
+	var first error
+	for _, op := range ops {
+		if err := op(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
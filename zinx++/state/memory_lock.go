@@ -0,0 +1,85 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+type memLockEntry struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// memoryLockBackend is the in-process lock table. Like the Redis one, fencing
+// counters outlive the locks.
+type memoryLockBackend struct {
+	held   map[string]memLockEntry
+	fences map[string]uint64
+	lock   sync.Mutex
+	now    func() time.Time
+}
+
+func newMemoryLockBackend() *memoryLockBackend {
+	return &memoryLockBackend{
+		held:   make(map[string]memLockEntry),
+		fences: make(map[string]uint64),
+		now:    time.Now,
+	}
+}
+
+// NewMemoryLocker returns a locker private to the process, for tests and
+// single-server setups.
+func NewMemoryLocker(opts ...LockerOption) ziface.ILocker {
+	return newLocker(newMemoryLockBackend(), opts)
+}
+
+// ownerLocked returns key's current owner, dropping an expired entry.
+func (b *memoryLockBackend) ownerLocked(key string) (string, bool) {
+	e, ok := b.held[key]
+	if !ok {
+		return "", false
+	}
+	if !b.now().Before(e.expiresAt) {
+		delete(b.held, key)
+		return "", false
+	}
+	return e.owner, true
+}
+
+func (b *memoryLockBackend) acquire(ctx context.Context, key, owner string, ttl time.Duration) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, held := b.ownerLocked(key); held {
+		return 0, fmt.Errorf("%w: key=%s", ErrLockHeld, key)
+	}
+	b.held[key] = memLockEntry{owner: owner, expiresAt: b.now().Add(ttl)}
+	b.fences[key]++
+	return b.fences[key], nil
+}
+
+func (b *memoryLockBackend) refresh(ctx context.Context, key, owner string, ttl time.Duration) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if cur, held := b.ownerLocked(key); !held || cur != owner {
+		return fmt.Errorf("%w: key=%s", ErrLockNotHeld, key)
+	}
+	b.held[key] = memLockEntry{owner: owner, expiresAt: b.now().Add(ttl)}
+	return nil
+}
+
+func (b *memoryLockBackend) release(ctx context.Context, key, owner string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if cur, held := b.ownerLocked(key); !held || cur != owner {
+		return fmt.Errorf("%w: key=%s", ErrLockNotHeld, key)
+	}
+	delete(b.held, key)
+	return nil
+}
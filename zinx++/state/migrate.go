@@ -0,0 +1,89 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"zinxplusplus/ziface"
+)
+
+type MigrateOptions struct {
+	// DryRun counts what would change without writing.
+	DryRun bool
+	// Recode re-encodes every object with this serializer; nil keeps the one
+	// each object was written with. It must be a DocumentSerializer.
+	Recode ziface.ISerializer
+	// OnError is called for each key that could not be migrated; the run
+	// continues. When nil the run stops at the first error.
+	OnError func(key string, err error)
+}
+
+type MigrateStats struct {
+	Scanned  int
+	Migrated int
+	// Skipped counts keys holding something other than a plain value, e.g.
+	// hashes or versioned keys.
+	Skipped int
+	Failed  int
+}
+
+// MigrateStore is what MigratePrefix needs from a state manager. The Redis,
+// memory and SQL adapters provide it, and so does TieredStateManager.
+type MigrateStore interface {
+	GetState(ctx context.Context, key string) ([]byte, error)
+	SetState(ctx context.Context, key string, value []byte, expiration int64) error
+	ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error
+}
+
+// MigratePrefix upgrades every object under prefix to its current schema
+// version (and serializer, with Recode), keeping TTLs. It is meant to run
+// offline: a concurrent writer's update to a key between the read and the
+// write is overwritten.
+func MigratePrefix(ctx context.Context, sm MigrateStore, codecs *Codecs, prefix string, opts MigrateOptions) (MigrateStats, error) {
+	var stats MigrateStats
+	if opts.Recode != nil && !supportsDocuments(opts.Recode) {
+		return stats, fmt.Errorf("%w: %s", ErrNotMigratable, opts.Recode.Name())
+	}
+	err := sm.ScanKeys(ctx, prefix, func(key string) error {
+		stats.Scanned++
+		err := migrateKey(ctx, sm, codecs, key, opts, &stats)
+		if err == nil {
+			return nil
+		}
+		stats.Failed++
+		if opts.OnError == nil {
+			return fmt.Errorf("migrate key %s: %w", key, err)
+		}
+		opts.OnError(key, err)
+		return nil
+	})
+	return stats, err
+}
+
+func migrateKey(ctx context.Context, sm MigrateStore, codecs *Codecs, key string, opts MigrateOptions, stats *MigrateStats) error {
+	data, err := sm.GetState(ctx, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrWrongType):
+			stats.Skipped++
+			return nil
+		case errors.Is(err, ErrStateNotFound):
+			// Deleted or expired since the scan.
+			return nil
+		}
+		return err
+	}
+
+	upgraded, changed, err := codecs.Upgrade(key, data, opts.Recode)
+	if err != nil || !changed {
+		return err
+	}
+	if !opts.DryRun {
+		if err := sm.SetState(ctx, key, upgraded, KeepTTL); err != nil {
+			return err
+		}
+	}
+	stats.Migrated++
+	return nil
+}
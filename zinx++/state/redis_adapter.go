@@ -2,7 +2,6 @@ package state
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -13,47 +12,49 @@ import (
 )
 
 type RedisStateAdapter struct {
-	client *redis.Client
+	client redis.UniversalClient
+	codecs *Codecs
 }
 
-type RedisConfig struct {
-	Addr     string
-	Password string
-	DB       int
-	PoolSize int
-}
-
-func NewRedisStateAdapter(cfg RedisConfig) (ziface.IStateManager, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-		PoolSize: cfg.PoolSize,
-	})
+func NewRedisStateAdapter(cfg RedisConfig) (ziface.IExtendedStateManager, error) {
+	rdb, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
-		return nil, fmt.Errorf("failed to connect to redis (%s): %w", cfg.Addr, err)
+		_ = rdb.Close()
+		return nil, fmt.Errorf("failed to connect to redis (%s): %w", cfg.endpoint(), err)
 	}
 
-	fmt.Printf("[State] RedisStateAdapter connected to %s, DB %d\n", cfg.Addr, cfg.DB)
+	fmt.Printf("[State] RedisStateAdapter connected to %s, DB %d\n", cfg.endpoint(), cfg.DB)
 
-	return &RedisStateAdapter{
-		client: rdb,
-	}, nil
+	return NewRedisStateAdapterWithClient(rdb), nil
 }
 
-func (rsa *RedisStateAdapter) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
-	var expDuration time.Duration
-	if expiration > 0 {
+// NewRedisStateAdapterWithClient wraps an existing client, e.g. one shared
+// with other components. The caller keeps ownership of the client.
+func NewRedisStateAdapterWithClient(client redis.UniversalClient) ziface.IExtendedStateManager {
+	return &RedisStateAdapter{client: client, codecs: DefaultCodecs()}
+}
 
-		expDuration = time.Duration(expiration) * time.Second
-	} else {
-		expDuration = 0
-	}
+func (rsa *RedisStateAdapter) Client() redis.UniversalClient {
+	return rsa.client
+}
 
-	err := rsa.client.Set(ctx, key, value, expDuration).Err()
+// SetCodecs replaces the serializers used by SetStateObject/GetStateObject.
+func (rsa *RedisStateAdapter) SetCodecs(codecs *Codecs) {
+	rsa.codecs = codecs
+}
+
+func (rsa *RedisStateAdapter) Close() error {
+	return rsa.client.Close()
+}
+
+func (rsa *RedisStateAdapter) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
+	err := rsa.client.Set(ctx, key, value, expirationDuration(expiration)).Err()
 	if err != nil {
 
 		return fmt.Errorf("%w: set key %s: %v", ErrRedisCmdFailed, key, err)
@@ -69,7 +70,7 @@ func (rsa *RedisStateAdapter) GetState(ctx context.Context, key string) ([]byte,
 			return nil, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
 		}
 
-		return nil, fmt.Errorf("%w: get key %s: %v", ErrRedisCmdFailed, key, err)
+		return nil, redisErr("get", key, err)
 	}
 	return val, nil
 }
@@ -93,9 +94,9 @@ func (rsa *RedisStateAdapter) ExistsState(ctx context.Context, key string) (bool
 
 func (rsa *RedisStateAdapter) SetStateObject(ctx context.Context, key string, obj interface{}, expiration int64) error {
 
-	valueBytes, err := json.Marshal(obj)
+	valueBytes, err := rsa.codecs.Marshal(key, obj)
 	if err != nil {
-		return fmt.Errorf("%w: key=%s, type=%T: %v", ErrSerializationFailed, key, obj, err)
+		return err
 	}
 
 	return rsa.SetState(ctx, key, valueBytes, expiration)
@@ -109,9 +110,5 @@ func (rsa *RedisStateAdapter) GetStateObject(ctx context.Context, key string, ob
 		return err
 	}
 
-	if err := json.Unmarshal(valueBytes, objPtr); err != nil {
-		return fmt.Errorf("%w: key=%s, targetType=%T: %v", ErrDeserializationFailed, key, objPtr, err)
-	}
-
-	return nil
+	return rsa.codecs.Unmarshal(key, valueBytes, objPtr)
 }
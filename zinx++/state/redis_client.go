@@ -0,0 +1,137 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+var (
+	ErrUnknownRedisMode = errors.New("unknown redis mode")
+	ErrBadRedisConfig   = errors.New("invalid redis config")
+)
+
+// RedisConfig describes how to reach Redis in one of three modes:
+//
+//   - standalone (default): a single node at Addr.
+//   - sentinel: the master named MasterName, discovered through the sentinels
+//     in Addrs, with automatic failover.
+//   - cluster: a Redis Cluster seeded from Addrs.
+//
+// Durations are in milliseconds; zero keeps the go-redis default. MaxRetries
+// 0 means the default of 3 and -1 disables retries.
+type RedisConfig struct {
+	Mode string
+
+	Addr  string
+	Addrs []string
+
+	MasterName       string
+	SentinelPassword string
+
+	Username string
+	Password string
+	DB       int
+
+	PoolSize     int
+	MinIdleConns int
+
+	DialTimeoutMs  int
+	ReadTimeoutMs  int
+	WriteTimeoutMs int
+	PoolTimeoutMs  int
+
+	MaxRetries        int
+	MinRetryBackoffMs int
+	MaxRetryBackoffMs int
+
+	// Sentinel and cluster only: serve reads from replicas.
+	ReadFromReplicas bool
+	RouteByLatency   bool
+}
+
+func (cfg RedisConfig) mode() string {
+	if cfg.Mode == "" {
+		return RedisModeStandalone
+	}
+	return strings.ToLower(cfg.Mode)
+}
+
+// endpoint describes the target for logs and errors.
+func (cfg RedisConfig) endpoint() string {
+	switch cfg.mode() {
+	case RedisModeSentinel:
+		return fmt.Sprintf("sentinel %s via %s", cfg.MasterName, strings.Join(cfg.Addrs, ","))
+	case RedisModeCluster:
+		return fmt.Sprintf("cluster %s", strings.Join(cfg.Addrs, ","))
+	default:
+		if cfg.Addr == "" && len(cfg.Addrs) > 0 {
+			return cfg.Addrs[0]
+		}
+		return cfg.Addr
+	}
+}
+
+func ms(v int) time.Duration {
+	return time.Duration(v) * time.Millisecond
+}
+
+// NewRedisClient builds the client for cfg's mode. The three client types
+// all satisfy redis.UniversalClient, which is all the adapters use.
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      ms(cfg.DialTimeoutMs),
+		ReadTimeout:      ms(cfg.ReadTimeoutMs),
+		WriteTimeout:     ms(cfg.WriteTimeoutMs),
+		PoolTimeout:      ms(cfg.PoolTimeoutMs),
+		MaxRetries:       cfg.MaxRetries,
+		MinRetryBackoff:  ms(cfg.MinRetryBackoffMs),
+		MaxRetryBackoff:  ms(cfg.MaxRetryBackoffMs),
+		MasterName:       cfg.MasterName,
+		ReadOnly:         cfg.ReadFromReplicas,
+		RouteByLatency:   cfg.RouteByLatency,
+	}
+
+	switch cfg.mode() {
+	case RedisModeStandalone:
+		if cfg.Addr != "" {
+			opts.Addrs = []string{cfg.Addr}
+		}
+		if len(opts.Addrs) != 1 {
+			return nil, fmt.Errorf("%w: standalone needs exactly one address", ErrBadRedisConfig)
+		}
+		return redis.NewClient(opts.Simple()), nil
+	case RedisModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("%w: sentinel needs MasterName and sentinel Addrs", ErrBadRedisConfig)
+		}
+		failover := opts.Failover()
+		if cfg.ReadFromReplicas {
+			return redis.NewFailoverClusterClient(failover), nil
+		}
+		return redis.NewFailoverClient(failover), nil
+	case RedisModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("%w: cluster needs seed Addrs", ErrBadRedisConfig)
+		}
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRedisMode, cfg.Mode)
+	}
+}
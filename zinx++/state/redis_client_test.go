@@ -0,0 +1,108 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisAdapterModes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		cfg  RedisConfig
+	}{
+		{"standalone addr", RedisConfig{Addr: mr.Addr()}},
+		{"standalone addrs", RedisConfig{Mode: "Standalone", Addrs: []string{mr.Addr()}}},
+		{"cluster", RedisConfig{Mode: RedisModeCluster, Addrs: []string{mr.Addr()}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mr.FlushAll()
+			sm, err := NewRedisStateAdapter(tc.cfg)
+			if err != nil {
+				t.Fatalf("NewRedisStateAdapter: %v", err)
+			}
+			defer sm.(*RedisStateAdapter).Close()
+
+			if err := sm.SetState(ctx, "k", []byte("v"), 30); err != nil {
+				t.Fatalf("SetState: %v", err)
+			}
+			got, err := sm.GetState(ctx, "k")
+			if err != nil || string(got) != "v" {
+				t.Fatalf("GetState = %q, %v", got, err)
+			}
+			if ttl := mr.TTL("k"); ttl <= 0 {
+				t.Fatalf("ttl = %v, want positive", ttl)
+			}
+			if err := sm.DeleteState(ctx, "k"); err != nil {
+				t.Fatalf("DeleteState: %v", err)
+			}
+			if _, err := sm.GetState(ctx, "k"); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("GetState after delete = %v, want ErrStateNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRedisAdapterUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	_, err := NewRedisStateAdapter(RedisConfig{Addr: addr, DialTimeoutMs: 100, MaxRetries: -1})
+	if err == nil {
+		t.Fatal("connected to a stopped server")
+	}
+}
+
+func TestNewRedisClientSentinel(t *testing.T) {
+	cfg := RedisConfig{Mode: RedisModeSentinel, MasterName: "main", Addrs: []string{"127.0.0.1:26379"}}
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	client.Close()
+	if _, ok := client.(*redis.Client); !ok {
+		t.Fatalf("sentinel client is %T, want *redis.Client", client)
+	}
+
+	cfg.ReadFromReplicas = true
+	client, err = NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	client.Close()
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Fatalf("sentinel client reading from replicas is %T, want *redis.ClusterClient", client)
+	}
+}
+
+func TestNewRedisClientErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  RedisConfig
+		want error
+	}{
+		{"standalone without address", RedisConfig{}, ErrBadRedisConfig},
+		{"standalone with two addresses", RedisConfig{Addrs: []string{"a:1", "b:1"}}, ErrBadRedisConfig},
+		{"sentinel without master", RedisConfig{Mode: RedisModeSentinel, Addrs: []string{"a:1"}}, ErrBadRedisConfig},
+		{"sentinel without addresses", RedisConfig{Mode: RedisModeSentinel, MasterName: "main"}, ErrBadRedisConfig},
+		{"cluster without seeds", RedisConfig{Mode: RedisModeCluster}, ErrBadRedisConfig},
+		{"unknown mode", RedisConfig{Mode: "ring", Addr: "a:1"}, ErrUnknownRedisMode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewRedisClient(tc.cfg)
+			if client != nil {
+				client.Close()
+			}
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
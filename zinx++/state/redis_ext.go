@@ -0,0 +1,372 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"zinxplusplus/ziface"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Versioned keys are hashes holding the value in "v" and its version in
+// "ver", so a single script can compare and bump both. The marker field tells
+// them apart from plain hashes: the hash commands below refuse marked keys, as
+// the memory adapter does, so HSetState cannot overwrite a version behind
+// CAS's back.
+const versionMarkerField = "zinx:versioned"
+
+const wrongTypeReply = `redis.error_reply('WRONGTYPE Operation against a key holding the wrong kind of value')`
+
+var casScript = redis.NewScript(`
+local kind = redis.call('TYPE', KEYS[1]).ok
+local cur = 0
+if kind == 'hash' then
+	if redis.call('HEXISTS', KEYS[1], '` + versionMarkerField + `') == 0 then
+		return ` + wrongTypeReply + `
+	end
+	cur = tonumber(redis.call('HGET', KEYS[1], 'ver'))
+elseif kind ~= 'none' then
+	return ` + wrongTypeReply + `
+end
+if cur ~= tonumber(ARGV[1]) then
+	return -1
+end
+cur = cur + 1
+redis.call('HSET', KEYS[1], 'v', ARGV[2], 'ver', cur, '` + versionMarkerField + `', 1)
+if tonumber(ARGV[3]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+else
+	redis.call('PERSIST', KEYS[1])
+end
+return cur
+`)
+
+var getVersionedScript = redis.NewScript(`
+local kind = redis.call('TYPE', KEYS[1]).ok
+if kind == 'none' then
+	return false
+end
+if kind ~= 'hash' or redis.call('HEXISTS', KEYS[1], '` + versionMarkerField + `') == 0 then
+	return ` + wrongTypeReply + `
+end
+return redis.call('HMGET', KEYS[1], 'v', 'ver')
+`)
+
+// hashGuard runs before every hash command: it fails on versioned keys and
+// on keys that are not hashes.
+const hashGuard = `
+local kind = redis.call('TYPE', KEYS[1]).ok
+if kind ~= 'none' and (kind ~= 'hash' or redis.call('HEXISTS', KEYS[1], '` + versionMarkerField + `') == 1) then
+	return ` + wrongTypeReply + `
+end
+`
+
+var (
+	hgetScript    = redis.NewScript(hashGuard + `return redis.call('HGET', KEYS[1], ARGV[1])`)
+	hgetallScript = redis.NewScript(hashGuard + `return redis.call('HGETALL', KEYS[1])`)
+	hsetScript    = redis.NewScript(hashGuard + `return redis.call('HSET', KEYS[1], unpack(ARGV))`)
+	hdelScript    = redis.NewScript(hashGuard + `return redis.call('HDEL', KEYS[1], unpack(ARGV))`)
+	hincrbyScript = redis.NewScript(hashGuard + `return redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])`)
+)
+
+func expirationDuration(expiration int64) time.Duration {
+	switch {
+	case expiration > 0:
+		return time.Duration(expiration) * time.Second
+	case expiration == KeepTTL:
+		return redis.KeepTTL
+	default:
+		return 0
+	}
+}
+
+// redisGlobEscape quotes the glob characters of s for SCAN MATCH.
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ScanKeys walks the keys with SCAN, on every master of a cluster. Like SCAN
+// it may report a key more than once.
+func (rsa *RedisStateAdapter) ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error {
+	match := redisGlobEscape(prefix) + "*"
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, match, 500).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return redisErr("scan", prefix, err)
+		}
+		return nil
+	}
+	if cluster, ok := rsa.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+	}
+	return scan(ctx, rsa.client)
+}
+
+// redisErr maps Redis replies onto the package errors.
+func redisErr(op, key string, err error) error {
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "WRONGTYPE"):
+		return fmt.Errorf("%w: %s key %s", ErrWrongType, op, key)
+	case strings.Contains(msg, "not an integer"):
+		return fmt.Errorf("%w: %s key %s", ErrNotInteger, op, key)
+	default:
+		return fmt.Errorf("%w: %s key %s: %v", ErrRedisCmdFailed, op, key, err)
+	}
+}
+
+// MGetState pipelines GETs instead of sending MGET, which Redis Cluster
+// rejects when the keys live in different slots.
+func (rsa *RedisStateAdapter) MGetState(ctx context.Context, keys []string) (map[string][]byte, error) {
+	pipe := rsa.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		for i, cmd := range cmds {
+			if cmd.Err() != nil && !errors.Is(cmd.Err(), redis.Nil) {
+				return nil, redisErr("mget", keys[i], cmd.Err())
+			}
+		}
+		return nil, fmt.Errorf("%w: mget: %v", ErrRedisCmdFailed, err)
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		if val, err := cmd.Bytes(); err == nil {
+			values[keys[i]] = val
+		}
+	}
+	return values, nil
+}
+
+func (rsa *RedisStateAdapter) MSetState(ctx context.Context, values map[string][]byte, expiration int64) error {
+	batch := rsa.Batch()
+	for key, value := range values {
+		batch.Set(key, value, expiration)
+	}
+	return batch.Exec(ctx)
+}
+
+func (rsa *RedisStateAdapter) ExpireState(ctx context.Context, key string, expiration int64) error {
+	var err error
+	if expiration > 0 {
+		err = rsa.client.Expire(ctx, key, expirationDuration(expiration)).Err()
+	} else {
+		err = rsa.client.Persist(ctx, key).Err()
+	}
+	if err != nil {
+		return redisErr("expire", key, err)
+	}
+	return nil
+}
+
+func (rsa *RedisStateAdapter) HGetState(ctx context.Context, key string, field string) ([]byte, error) {
+	val, err := hgetScript.Run(ctx, rsa.client, []string{key}, field).Text()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%w: key=%s, field=%s", ErrStateNotFound, key, field)
+		}
+		return nil, redisErr("hget", key, err)
+	}
+	return []byte(val), nil
+}
+
+func (rsa *RedisStateAdapter) HGetAllState(ctx context.Context, key string) (map[string][]byte, error) {
+	vals, err := hgetallScript.Run(ctx, rsa.client, []string{key}).StringSlice()
+	if err != nil {
+		return nil, redisErr("hgetall", key, err)
+	}
+	if len(vals) == 0 {
+		return nil, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	fields := make(map[string][]byte, len(vals)/2)
+	for i := 0; i+1 < len(vals); i += 2 {
+		fields[vals[i]] = []byte(vals[i+1])
+	}
+	return fields, nil
+}
+
+func (rsa *RedisStateAdapter) HSetState(ctx context.Context, key string, fields map[string][]byte) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := hsetScript.Run(ctx, rsa.client, []string{key}, hashArgs(fields)...).Err(); err != nil {
+		return redisErr("hset", key, err)
+	}
+	return nil
+}
+
+func (rsa *RedisStateAdapter) HDelState(ctx context.Context, key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := hdelScript.Run(ctx, rsa.client, []string{key}, stringArgs(fields)...).Err(); err != nil {
+		return redisErr("hdel", key, err)
+	}
+	return nil
+}
+
+func (rsa *RedisStateAdapter) HIncrBy(ctx context.Context, key string, field string, delta int64) (int64, error) {
+	n, err := hincrbyScript.Run(ctx, rsa.client, []string{key}, field, delta).Int64()
+	if err != nil {
+		return 0, redisErr("hincrby", key, err)
+	}
+	return n, nil
+}
+
+func (rsa *RedisStateAdapter) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := rsa.client.IncrBy(ctx, key, delta).Result()
+	if err != nil {
+		return 0, redisErr("incrby", key, err)
+	}
+	return n, nil
+}
+
+func (rsa *RedisStateAdapter) GetVersioned(ctx context.Context, key string) ([]byte, uint64, error) {
+	vals, err := getVersionedScript.Run(ctx, rsa.client, []string{key}).Slice()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+		}
+		return nil, 0, redisErr("getversioned", key, err)
+	}
+	value, _ := vals[0].(string)
+	verStr, _ := vals[1].(string)
+	var version uint64
+	if _, err := fmt.Sscan(verStr, &version); err != nil {
+		return nil, 0, fmt.Errorf("%w: getversioned key %s", ErrWrongType, key)
+	}
+	return []byte(value), version, nil
+}
+
+func (rsa *RedisStateAdapter) CompareAndSet(ctx context.Context, key string, expectedVersion uint64, value []byte, expiration int64) (uint64, error) {
+	res, err := casScript.Run(ctx, rsa.client, []string{key}, expectedVersion, value, expiration).Int64()
+	if err != nil {
+		return 0, redisErr("cas", key, err)
+	}
+	if res < 0 {
+		return 0, fmt.Errorf("%w: key=%s, expected=%d", ErrVersionMismatch, key, expectedVersion)
+	}
+	return uint64(res), nil
+}
+
+func (rsa *RedisStateAdapter) Batch() ziface.IStateBatch {
+	return &redisBatch{pipe: rsa.client.Pipeline()}
+}
+
+func stringArgs(vals []string) []interface{} {
+	args := make([]interface{}, len(vals))
+	for i, val := range vals {
+		args[i] = val
+	}
+	return args
+}
+
+func hashArgs(fields map[string][]byte) []interface{} {
+	args := make([]interface{}, 0, len(fields)*2)
+	for field, val := range fields {
+		args = append(args, field, val)
+	}
+	return args
+}
+
+// redisBatch queues commands on a pipeline; on a cluster client go-redis
+// splits it per node. Hash commands go through their guard scripts with EVAL,
+// since EVALSHA cannot fall back to loading the script inside a pipeline.
+type redisBatch struct {
+	pipe redis.Pipeliner
+	keys []string
+}
+
+func (b *redisBatch) Set(key string, value []byte, expiration int64) ziface.IStateBatch {
+	b.pipe.Set(context.Background(), key, value, expirationDuration(expiration))
+	b.keys = append(b.keys, key)
+	return b
+}
+
+func (b *redisBatch) Delete(key string) ziface.IStateBatch {
+	b.pipe.Del(context.Background(), key)
+	b.keys = append(b.keys, key)
+	return b
+}
+
+func (b *redisBatch) Expire(key string, expiration int64) ziface.IStateBatch {
+	if expiration > 0 {
+		b.pipe.Expire(context.Background(), key, expirationDuration(expiration))
+	} else {
+		b.pipe.Persist(context.Background(), key)
+	}
+	b.keys = append(b.keys, key)
+	return b
+}
+
+func (b *redisBatch) HSet(key string, fields map[string][]byte) ziface.IStateBatch {
+	if len(fields) > 0 {
+		hsetScript.Eval(context.Background(), b.pipe, []string{key}, hashArgs(fields)...)
+		b.keys = append(b.keys, key)
+	}
+	return b
+}
+
+func (b *redisBatch) HDel(key string, fields ...string) ziface.IStateBatch {
+	if len(fields) > 0 {
+		hdelScript.Eval(context.Background(), b.pipe, []string{key}, stringArgs(fields)...)
+		b.keys = append(b.keys, key)
+	}
+	return b
+}
+
+func (b *redisBatch) HIncrBy(key string, field string, delta int64) ziface.IStateBatch {
+	hincrbyScript.Eval(context.Background(), b.pipe, []string{key}, field, delta)
+	b.keys = append(b.keys, key)
+	return b
+}
+
+func (b *redisBatch) IncrBy(key string, delta int64) ziface.IStateBatch {
+	b.pipe.IncrBy(context.Background(), key, delta)
+	b.keys = append(b.keys, key)
+	return b
+}
+
+func (b *redisBatch) Len() int {
+	return len(b.keys)
+}
+
+func (b *redisBatch) Exec(ctx context.Context) error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	cmdKeys := b.keys
+	b.keys = nil
+	cmds, err := b.pipe.Exec(ctx)
+	if err == nil {
+		return nil
+	}
+	for i, cmd := range cmds {
+		if cmd.Err() != nil && i < len(cmdKeys) {
+			return redisErr("batch", cmdKeys[i], cmd.Err())
+		}
+	}
+	return fmt.Errorf("%w: batch: %v", ErrRedisCmdFailed, err)
+}
@@ -0,0 +1,95 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"zinxplusplus/ziface"
+)
+
+// adapters returns a memory adapter and a Redis adapter on miniredis, so a
+// test can check both behave the same.
+func adapters(t *testing.T) map[string]ziface.IExtendedStateManager {
+	mr := miniredis.RunT(t)
+	rsa, err := NewRedisStateAdapter(RedisConfig{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatalf("NewRedisStateAdapter: %v", err)
+	}
+	t.Cleanup(func() { rsa.(*RedisStateAdapter).Close() })
+	return map[string]ziface.IExtendedStateManager{
+		"memory": NewMemoryStateAdapter(),
+		"redis":  rsa,
+	}
+}
+
+func TestVersionedKeyRejectsHashOps(t *testing.T) {
+	ctx := context.Background()
+	for name, sm := range adapters(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := sm.CompareAndSet(ctx, "v", 0, []byte("one"), 0); err != nil {
+				t.Fatalf("CompareAndSet: %v", err)
+			}
+
+			for op, err := range map[string]error{
+				"hget":    func() error { _, err := sm.HGetState(ctx, "v", "ver"); return err }(),
+				"hgetall": func() error { _, err := sm.HGetAllState(ctx, "v"); return err }(),
+				"hset":    sm.HSetState(ctx, "v", map[string][]byte{"ver": []byte("7")}),
+				"hdel":    sm.HDelState(ctx, "v", "ver"),
+				"hincrby": func() error { _, err := sm.HIncrBy(ctx, "v", "ver", 1); return err }(),
+				"batch":   sm.Batch().HSet("v", map[string][]byte{"ver": []byte("7")}).Exec(ctx),
+				"get":     func() error { _, err := sm.GetState(ctx, "v"); return err }(),
+			} {
+				if !errors.Is(err, ErrWrongType) {
+					t.Errorf("%s on a versioned key: err = %v, want ErrWrongType", op, err)
+				}
+			}
+
+			value, ver, err := sm.GetVersioned(ctx, "v")
+			if err != nil || string(value) != "one" || ver != 1 {
+				t.Fatalf("GetVersioned = %q, %d, %v; want one, 1", value, ver, err)
+			}
+			if ver, err := sm.CompareAndSet(ctx, "v", 1, []byte("two"), 0); err != nil || ver != 2 {
+				t.Fatalf("CompareAndSet = %d, %v; want 2", ver, err)
+			}
+		})
+	}
+}
+
+func TestPlainHashIsNotVersioned(t *testing.T) {
+	ctx := context.Background()
+	for name, sm := range adapters(t) {
+		t.Run(name, func(t *testing.T) {
+			// A hash that happens to use the versioned field names.
+			fields := map[string][]byte{"v": []byte("x"), "ver": []byte("1")}
+			if err := sm.HSetState(ctx, "h", fields); err != nil {
+				t.Fatalf("HSetState: %v", err)
+			}
+			if _, _, err := sm.GetVersioned(ctx, "h"); !errors.Is(err, ErrWrongType) {
+				t.Errorf("GetVersioned on a hash: err = %v, want ErrWrongType", err)
+			}
+			if _, err := sm.CompareAndSet(ctx, "h", 1, []byte("y"), 0); !errors.Is(err, ErrWrongType) {
+				t.Errorf("CompareAndSet on a hash: err = %v, want ErrWrongType", err)
+			}
+
+			got, err := sm.HGetAllState(ctx, "h")
+			if err != nil || len(got) != 2 || string(got["ver"]) != "1" {
+				t.Fatalf("HGetAllState = %q, %v", got, err)
+			}
+			if n, err := sm.HIncrBy(ctx, "h", "ver", 2); err != nil || n != 3 {
+				t.Fatalf("HIncrBy = %d, %v; want 3", n, err)
+			}
+			if err := sm.Batch().HDel("h", "v", "ver").Exec(ctx); err != nil {
+				t.Fatalf("batch HDel: %v", err)
+			}
+			if _, err := sm.HGetState(ctx, "h", "v"); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("HGetState after HDel: err = %v, want ErrStateNotFound", err)
+			}
+			if _, _, err := sm.GetVersioned(ctx, "missing"); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("GetVersioned on a missing key: err = %v, want ErrStateNotFound", err)
+			}
+		})
+	}
+}
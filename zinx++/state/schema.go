@@ -0,0 +1,145 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MigrationFunc upgrades a decoded document by one schema version in place.
+// Documents are the generic form of the stored object: maps, slices,
+// strings, bools and numbers (json.Number for JSON, int64/uint64/float64 for
+// msgpack).
+type MigrationFunc func(doc map[string]interface{}) error
+
+// Schema versions the objects stored under a key prefix. Objects written
+// before versioning count as version 1.
+type Schema struct {
+	Prefix  string
+	Version uint32
+
+	migrations map[uint32]MigrationFunc
+	lock       sync.RWMutex
+}
+
+// Migration registers fn as the step from version from to from+1.
+func (s *Schema) Migration(from uint32, fn MigrationFunc) *Schema {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.migrations[from] = fn
+	return s
+}
+
+// migrate runs every step from version from up to s.Version.
+func (s *Schema) migrate(doc map[string]interface{}, from uint32) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for v := from; v < s.Version; v++ {
+		fn, ok := s.migrations[v]
+		if !ok {
+			return fmt.Errorf("%w: %s v%d to v%d", ErrNoMigration, s.Prefix, v, v+1)
+		}
+		if err := fn(doc); err != nil {
+			return fmt.Errorf("%w: %s v%d to v%d: %v", ErrMigrationFailed, s.Prefix, v, v+1, err)
+		}
+	}
+	return nil
+}
+
+// SchemaRegistry maps key prefixes to schemas; the longest matching prefix
+// wins. Attach it to Codecs with UseSchemas so objects are stamped with
+// their schema version on write and migrated on read.
+type SchemaRegistry struct {
+	schemas []*Schema // longest prefix first
+	lock    sync.RWMutex
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{}
+}
+
+// Define declares the current version of objects under prefix, replacing an
+// earlier definition for the same prefix but keeping its migrations.
+func (r *SchemaRegistry) Define(prefix string, version uint32) *Schema {
+	if version == 0 {
+		version = 1
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, s := range r.schemas {
+		if s.Prefix == prefix {
+			s.lock.Lock()
+			s.Version = version
+			s.lock.Unlock()
+			return s
+		}
+	}
+	s := &Schema{Prefix: prefix, Version: version, migrations: make(map[uint32]MigrationFunc)}
+	r.schemas = append(r.schemas, s)
+	sort.SliceStable(r.schemas, func(i, j int) bool {
+		return len(r.schemas[i].Prefix) > len(r.schemas[j].Prefix)
+	})
+	return s
+}
+
+func (r *SchemaRegistry) For(key string) *Schema {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, s := range r.schemas {
+		if strings.HasPrefix(key, s.Prefix) {
+			return s
+		}
+	}
+	return nil
+}
+
+// normalizeNumbers replaces json.Number values in v with int64, uint64 or
+// float64 so other serializers encode them as numbers, not strings.
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = normalizeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = normalizeNumbers(item)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return n
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+// decodeDocument decodes payload into its generic form. JSON keeps numbers
+// as json.Number so 64-bit IDs survive a migration.
+func decodeDocument(s ISerializer, payload []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if s.ID() == SerializerJSON {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+	if err := s.Unmarshal(payload, &doc); err != nil {
+		return nil, fmt.Errorf("%s objects cannot be migrated generically: %v", s.Name(), err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,176 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"zinxplusplus/ziface"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// IDs of the built-in serializers. Custom ones should use 128 and up.
+const (
+	SerializerJSON     uint8 = 1
+	SerializerGob      uint8 = 2
+	SerializerMsgPack  uint8 = 3
+	SerializerProtobuf uint8 = 4
+)
+
+var (
+	JSONSerializer     ziface.ISerializer = jsonSerializer{}
+	GobSerializer      ziface.ISerializer = gobSerializer{}
+	MsgPackSerializer  ziface.ISerializer = msgpackSerializer{}
+	ProtobufSerializer ziface.ISerializer = protobufSerializer{}
+)
+
+var (
+	serializers     = map[uint8]ziface.ISerializer{}
+	serializerNames = map[string]ziface.ISerializer{}
+	serializersLock sync.RWMutex
+)
+
+func init() {
+	for _, s := range []ziface.ISerializer{JSONSerializer, GobSerializer, MsgPackSerializer, ProtobufSerializer} {
+		if err := RegisterSerializer(s); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterSerializer makes s available for decoding by ID and for lookup by
+// name. IDs and names must be unique.
+func RegisterSerializer(s ziface.ISerializer) error {
+	serializersLock.Lock()
+	defer serializersLock.Unlock()
+	if old, ok := serializers[s.ID()]; ok {
+		return fmt.Errorf("serializer id %d already used by %s", s.ID(), old.Name())
+	}
+	if _, ok := serializerNames[s.Name()]; ok {
+		return fmt.Errorf("serializer name %q already registered", s.Name())
+	}
+	serializers[s.ID()] = s
+	serializerNames[s.Name()] = s
+	return nil
+}
+
+// DocumentSerializer is implemented by serializers whose encoding of a
+// generic document (maps, slices and scalars) still decodes into the struct
+// it came from. Migrations and re-encoding work on such documents, so they
+// only run between these serializers: JSON and msgpack, not gob or protobuf.
+type DocumentSerializer interface {
+	ziface.ISerializer
+	Documents() bool
+}
+
+func supportsDocuments(s ziface.ISerializer) bool {
+	d, ok := s.(DocumentSerializer)
+	return ok && d.Documents()
+}
+
+func SerializerByID(id uint8) (ziface.ISerializer, error) {
+	serializersLock.RLock()
+	defer serializersLock.RUnlock()
+	if s, ok := serializers[id]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: id=%d", ErrUnknownSerializer, id)
+}
+
+// SerializerByName looks up "json", "gob", "msgpack", "protobuf" or a
+// registered custom serializer.
+func SerializerByName(name string) (ziface.ISerializer, error) {
+	serializersLock.RLock()
+	defer serializersLock.RUnlock()
+	if s, ok := serializerNames[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownSerializer, name)
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) ID() uint8       { return SerializerJSON }
+func (jsonSerializer) Name() string    { return "json" }
+func (jsonSerializer) Version() uint8  { return 1 }
+func (jsonSerializer) Documents() bool { return true }
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// gobSerializer encodes each value as a self-contained gob stream, type
+// description included, so any blob decodes on its own.
+type gobSerializer struct{}
+
+func (gobSerializer) ID() uint8      { return SerializerGob }
+func (gobSerializer) Name() string   { return "gob" }
+func (gobSerializer) Version() uint8 { return 1 }
+
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// msgpackSerializer uses the json tags for field names, so structs tagged
+// for JSON need no extra tags.
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) ID() uint8       { return SerializerMsgPack }
+func (msgpackSerializer) Name() string    { return "msgpack" }
+func (msgpackSerializer) Version() uint8  { return 1 }
+func (msgpackSerializer) Documents() bool { return true }
+
+func (msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// protobufSerializer only accepts generated message types.
+type protobufSerializer struct{}
+
+func (protobufSerializer) ID() uint8      { return SerializerProtobuf }
+func (protobufSerializer) Name() string   { return "protobuf" }
+func (protobufSerializer) Version() uint8 { return 1 }
+
+func (protobufSerializer) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf serializer needs a proto.Message, got %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufSerializer) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf serializer needs a proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,447 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SQLDialectSQLite   = "sqlite"
+	SQLDialectMySQL    = "mysql"
+	SQLDialectPostgres = "postgres"
+)
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type SQLConfig struct {
+	// Driver and DSN are passed to sql.Open by OpenSQLStateAdapter; the
+	// application must import the driver.
+	Driver string
+	DSN    string
+	// Dialect is one of sqlite, mysql, postgres.
+	Dialect string
+	// Table defaults to zinx_state.
+	Table string
+	// CreateTable creates the table when it does not exist.
+	CreateTable bool
+}
+
+// SQLStateAdapter stores state in one table:
+//
+//	state_key    VARCHAR(255) PRIMARY KEY
+//	state_value  blob
+//	expires_at   BIGINT, unix milliseconds, 0 for no expiry
+//	version      BIGINT, bumped by every write
+//
+// It works on any database/sql driver the application registers; the
+// package imports none. Expired rows read as missing and are removed by
+// PurgeExpired. Keys compare byte by byte: the table it creates uses a
+// binary collation for state_key on MySQL and Postgres, and a table created
+// by hand must do the same. Besides IStateManager it offers the MGet/MSet,
+// expiry, versioned and scan operations of IExtendedStateManager; hashes,
+// counters and batches stay with the Redis and memory adapters.
+type SQLStateAdapter struct {
+	db      *sql.DB
+	table   string
+	dialect string
+	now     func() time.Time
+}
+
+func NewSQLStateAdapter(db *sql.DB, cfg SQLConfig) (*SQLStateAdapter, error) {
+	switch cfg.Dialect {
+	case SQLDialectSQLite, SQLDialectMySQL, SQLDialectPostgres:
+	default:
+		return nil, fmt.Errorf("%w: unknown sql dialect %q", ErrBadSQLConfig, cfg.Dialect)
+	}
+	table := cfg.Table
+	if table == "" {
+		table = "zinx_state"
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("%w: invalid table name %q", ErrBadSQLConfig, table)
+	}
+
+	a := &SQLStateAdapter{db: db, table: table, dialect: cfg.Dialect, now: time.Now}
+	if cfg.CreateTable {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := db.ExecContext(ctx, a.createTableSQL()); err != nil {
+			return nil, fmt.Errorf("%w: create table %s: %v", ErrSQLFailed, table, err)
+		}
+	}
+	return a, nil
+}
+
+// OpenSQLStateAdapter opens cfg.DSN with cfg.Driver and checks the
+// connection. SQLite gets a single connection, so an in-memory database is
+// shared and writers never hit a locked database.
+func OpenSQLStateAdapter(cfg SQLConfig) (*SQLStateAdapter, error) {
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("%w: open %s: %v", ErrBadSQLConfig, cfg.Driver, err)
+	}
+	if cfg.Dialect == SQLDialectSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%w: connect %s: %v", ErrSQLFailed, cfg.Driver, err)
+	}
+	a, err := NewSQLStateAdapter(db, cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	fmt.Printf("[State] SQLStateAdapter connected via %s, table %s\n", cfg.Driver, a.table)
+	return a, nil
+}
+
+func (a *SQLStateAdapter) DB() *sql.DB {
+	return a.db
+}
+
+func (a *SQLStateAdapter) createTableSQL() string {
+	key, blob := "VARCHAR(255)", "BLOB"
+	switch a.dialect {
+	case SQLDialectMySQL:
+		key, blob = "VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin", "LONGBLOB"
+	case SQLDialectPostgres:
+		key, blob = `VARCHAR(255) COLLATE "C"`, "BYTEA"
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	state_key %s NOT NULL PRIMARY KEY,
+	state_value %s NOT NULL,
+	expires_at BIGINT NOT NULL DEFAULT 0,
+	version BIGINT NOT NULL DEFAULT 0
+)`, a.table, key, blob)
+}
+
+// q rewrites ? placeholders for the dialect.
+func (a *SQLStateAdapter) q(query string) string {
+	query = strings.ReplaceAll(query, "{t}", a.table)
+	if a.dialect != SQLDialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (a *SQLStateAdapter) upsertSQL() string {
+	if a.dialect == SQLDialectMySQL {
+		return a.q(`INSERT INTO {t} (state_key, state_value, expires_at, version) VALUES (?, ?, ?, 1)
+ON DUPLICATE KEY UPDATE state_value = VALUES(state_value), expires_at = VALUES(expires_at), version = version + 1`)
+	}
+	return a.q(`INSERT INTO {t} (state_key, state_value, expires_at, version) VALUES (?, ?, ?, 1)
+ON CONFLICT (state_key) DO UPDATE SET state_value = excluded.state_value, expires_at = excluded.expires_at, version = {t}.version + 1`)
+}
+
+func (a *SQLStateAdapter) nowMs() int64 {
+	return a.now().UnixMilli()
+}
+
+func (a *SQLStateAdapter) expiresAt(expiration int64) int64 {
+	if expiration > 0 {
+		return a.nowMs() + expiration*1000
+	}
+	return 0
+}
+
+func sqlErr(op, key string, err error) error {
+	return fmt.Errorf("%w: %s key %s: %v", ErrSQLFailed, op, key, err)
+}
+
+// sqlExecer is the part of *sql.DB and *sql.Tx the writes need.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (a *SQLStateAdapter) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
+	return a.set(ctx, a.db, key, value, expiration)
+}
+
+func (a *SQLStateAdapter) set(ctx context.Context, db sqlExecer, key string, value []byte, expiration int64) error {
+	if value == nil {
+		value = []byte{}
+	}
+	expiresAt := a.expiresAt(expiration)
+	if expiration == KeepTTL {
+		// A live row keeps its expiry; a missing or expired one gets none.
+		err := db.QueryRowContext(ctx, a.q(`SELECT expires_at FROM {t} WHERE state_key = ? AND (expires_at = 0 OR expires_at > ?)`),
+			key, a.nowMs()).Scan(&expiresAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return sqlErr("set", key, err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, a.upsertSQL(), key, value, expiresAt); err != nil {
+		return sqlErr("set", key, err)
+	}
+	return nil
+}
+
+func (a *SQLStateAdapter) GetState(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := a.GetVersioned(ctx, key)
+	return value, err
+}
+
+func (a *SQLStateAdapter) DeleteState(ctx context.Context, key string) error {
+	if _, err := a.db.ExecContext(ctx, a.q(`DELETE FROM {t} WHERE state_key = ?`), key); err != nil {
+		return sqlErr("delete", key, err)
+	}
+	return nil
+}
+
+func (a *SQLStateAdapter) ExistsState(ctx context.Context, key string) (bool, error) {
+	var one int
+	err := a.db.QueryRowContext(ctx, a.q(`SELECT 1 FROM {t} WHERE state_key = ? AND (expires_at = 0 OR expires_at > ?)`),
+		key, a.nowMs()).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, sqlErr("exists", key, err)
+	}
+	return true, nil
+}
+
+func (a *SQLStateAdapter) MGetState(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	args = append(args, a.nowMs())
+	query := a.q(`SELECT state_key, state_value FROM {t} WHERE state_key IN (?` + strings.Repeat(", ?", len(keys)-1) +
+		`) AND (expires_at = 0 OR expires_at > ?)`)
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqlErr("mget", keys[0], err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, sqlErr("mget", keys[0], err)
+		}
+		values[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqlErr("mget", keys[0], err)
+	}
+	return values, nil
+}
+
+// MSetState writes all values in one transaction.
+func (a *SQLStateAdapter) MSetState(ctx context.Context, values map[string][]byte, expiration int64) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: mset begin: %v", ErrSQLFailed, err)
+	}
+	for key, value := range values {
+		if err := a.set(ctx, tx, key, value, expiration); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: mset commit: %v", ErrSQLFailed, err)
+	}
+	return nil
+}
+
+func (a *SQLStateAdapter) ExpireState(ctx context.Context, key string, expiration int64) error {
+	_, err := a.db.ExecContext(ctx, a.q(`UPDATE {t} SET expires_at = ? WHERE state_key = ? AND (expires_at = 0 OR expires_at > ?)`),
+		a.expiresAt(expiration), key, a.nowMs())
+	if err != nil {
+		return sqlErr("expire", key, err)
+	}
+	return nil
+}
+
+// GetStateTTL returns the value with its remaining lifetime in whole
+// seconds, rounded down; ttl is 0 for a key without expiry and -1 for one
+// that expires within the second.
+func (a *SQLStateAdapter) GetStateTTL(ctx context.Context, key string) (value []byte, ttl int64, err error) {
+	now := a.nowMs()
+	var expiresAt int64
+	err = a.db.QueryRowContext(ctx, a.q(`SELECT state_value, expires_at FROM {t} WHERE state_key = ? AND (expires_at = 0 OR expires_at > ?)`),
+		key, now).Scan(&value, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	if err != nil {
+		return nil, 0, sqlErr("get", key, err)
+	}
+	if expiresAt != 0 {
+		if ttl = (expiresAt - now) / 1000; ttl == 0 {
+			ttl = -1
+		}
+	}
+	return value, ttl, nil
+}
+
+// GetVersioned returns the value with its version, which counts every write
+// to the key, SetState included.
+func (a *SQLStateAdapter) GetVersioned(ctx context.Context, key string) ([]byte, uint64, error) {
+	var value []byte
+	var version int64
+	err := a.db.QueryRowContext(ctx, a.q(`SELECT state_value, version FROM {t} WHERE state_key = ? AND (expires_at = 0 OR expires_at > ?)`),
+		key, a.nowMs()).Scan(&value, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, fmt.Errorf("%w: key=%s", ErrStateNotFound, key)
+	}
+	if err != nil {
+		return nil, 0, sqlErr("get", key, err)
+	}
+	return value, uint64(version), nil
+}
+
+// CompareAndSet writes only when the key's version equals expectedVersion;
+// 0 means the key must not exist (an expired row counts as missing).
+func (a *SQLStateAdapter) CompareAndSet(ctx context.Context, key string, expectedVersion uint64, value []byte, expiration int64) (uint64, error) {
+	if value == nil {
+		value = []byte{}
+	}
+	now := a.nowMs()
+	var res sql.Result
+	var err error
+	if expectedVersion == 0 {
+		if _, err = a.db.ExecContext(ctx, a.q(`DELETE FROM {t} WHERE state_key = ? AND expires_at <> 0 AND expires_at <= ?`), key, now); err != nil {
+			return 0, sqlErr("cas", key, err)
+		}
+		insert := `INSERT INTO {t} (state_key, state_value, expires_at, version) VALUES (?, ?, ?, 1) ON CONFLICT (state_key) DO NOTHING`
+		if a.dialect == SQLDialectMySQL {
+			insert = `INSERT IGNORE INTO {t} (state_key, state_value, expires_at, version) VALUES (?, ?, ?, 1)`
+		}
+		res, err = a.db.ExecContext(ctx, a.q(insert), key, value, a.expiresAt(expiration))
+	} else {
+		res, err = a.db.ExecContext(ctx, a.q(`UPDATE {t} SET state_value = ?, expires_at = ?, version = version + 1
+WHERE state_key = ? AND version = ? AND (expires_at = 0 OR expires_at > ?)`),
+			value, a.expiresAt(expiration), key, int64(expectedVersion), now)
+	}
+	if err != nil {
+		return 0, sqlErr("cas", key, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, sqlErr("cas", key, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: key=%s, expected=%d", ErrVersionMismatch, key, expectedVersion)
+	}
+	return expectedVersion + 1, nil
+}
+
+// prefixEnd returns the smallest string above every string starting with
+// prefix, or "" when there is none. It bumps the last rune rather than the
+// last byte so the bound stays valid UTF-8.
+func prefixEnd(prefix string) string {
+	if !utf8.ValidString(prefix) {
+		b := []byte(prefix)
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] < 0xff {
+				b[i]++
+				return string(b[:i+1])
+			}
+		}
+		return ""
+	}
+	r := []rune(prefix)
+	for i := len(r) - 1; i >= 0; i-- {
+		if r[i] < unicode.MaxRune {
+			r[i]++
+			if r[i] == 0xD800 {
+				r[i] = 0xE000 // skip the surrogates, which UTF-8 cannot encode
+			}
+			return string(r[:i+1])
+		}
+	}
+	return ""
+}
+
+// ScanKeys pages through the live keys with prefix in key order, so fn may
+// write to the adapter while the scan runs. The prefix is matched as a key
+// range rather than with LIKE, which ignores case on many databases.
+func (a *SQLStateAdapter) ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error {
+	const pageSize = 500
+	query := `SELECT state_key FROM {t} WHERE state_key >= ? AND state_key > ?`
+	args := []interface{}{prefix, ""}
+	if end := prefixEnd(prefix); end != "" {
+		query += ` AND state_key < ?`
+		args = append(args, end)
+	}
+	query = a.q(query + ` AND (expires_at = 0 OR expires_at > ?) ORDER BY state_key LIMIT ` + fmt.Sprint(pageSize))
+	args = append(args, int64(0))
+
+	for {
+		args[len(args)-1] = a.nowMs()
+		rows, err := a.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return sqlErr("scan", prefix, err)
+		}
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return sqlErr("scan", prefix, err)
+			}
+			keys = append(keys, key)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return sqlErr("scan", prefix, err)
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue // a table whose key collation is not binary
+			}
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if len(keys) < pageSize {
+			return nil
+		}
+		args[1] = keys[len(keys)-1]
+	}
+}
+
+// PurgeExpired deletes expired rows and reports how many went.
+func (a *SQLStateAdapter) PurgeExpired(ctx context.Context) (int64, error) {
+	res, err := a.db.ExecContext(ctx, a.q(`DELETE FROM {t} WHERE expires_at <> 0 AND expires_at <= ?`), a.nowMs())
+	if err != nil {
+		return 0, fmt.Errorf("%w: purge expired: %v", ErrSQLFailed, err)
+	}
+	return res.RowsAffected()
+}
+
+func (a *SQLStateAdapter) Close() error {
+	return a.db.Close()
+}
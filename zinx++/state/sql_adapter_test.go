@@ -0,0 +1,304 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteAdapter opens a fresh in-memory SQLite database whose clock the
+// test moves by hand.
+func newSQLiteAdapter(t *testing.T) (*SQLStateAdapter, *time.Time) {
+	t.Helper()
+	a, err := OpenSQLStateAdapter(SQLConfig{Driver: "sqlite", DSN: ":memory:", Dialect: SQLDialectSQLite, CreateTable: true})
+	if err != nil {
+		t.Fatalf("OpenSQLStateAdapter: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	now := time.UnixMilli(1_700_000_000_000)
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+func (a *SQLStateAdapter) expiresAtOf(t *testing.T, key string) int64 {
+	t.Helper()
+	var expiresAt int64
+	if err := a.db.QueryRow(a.q(`SELECT expires_at FROM {t} WHERE state_key = ?`), key).Scan(&expiresAt); err != nil {
+		t.Fatalf("read expires_at of %s: %v", key, err)
+	}
+	return expiresAt
+}
+
+func TestSQLConfigErrors(t *testing.T) {
+	a, _ := newSQLiteAdapter(t)
+	if _, err := NewSQLStateAdapter(a.db, SQLConfig{Dialect: "oracle"}); !errors.Is(err, ErrBadSQLConfig) {
+		t.Errorf("unknown dialect: err = %v, want ErrBadSQLConfig", err)
+	}
+	if _, err := NewSQLStateAdapter(a.db, SQLConfig{Dialect: SQLDialectSQLite, Table: "x; drop"}); !errors.Is(err, ErrBadSQLConfig) {
+		t.Errorf("bad table: err = %v, want ErrBadSQLConfig", err)
+	}
+	if _, err := NewAdapter("sql", RedisConfig{}, SQLConfig{Driver: "nope", DSN: "x", Dialect: SQLDialectSQLite}); err == nil {
+		t.Error("NewAdapter opened an unregistered driver")
+	}
+	sm, err := NewAdapter("sql", RedisConfig{}, SQLConfig{Driver: "sqlite", DSN: ":memory:", Dialect: SQLDialectSQLite, CreateTable: true})
+	if err != nil {
+		t.Fatalf("NewAdapter(sql): %v", err)
+	}
+	sm.(*SQLStateAdapter).Close()
+}
+
+func TestSQLUpsert(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newSQLiteAdapter(t)
+
+	if _, err := a.GetState(ctx, "k"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("GetState on a missing key: err = %v, want ErrStateNotFound", err)
+	}
+	for i, v := range []string{"one", "two", ""} {
+		if err := a.SetState(ctx, "k", []byte(v), 0); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+		value, ver, err := a.GetVersioned(ctx, "k")
+		if err != nil || string(value) != v || ver != uint64(i+1) {
+			t.Fatalf("GetVersioned = %q, %d, %v; want %q, %d", value, ver, err, v, i+1)
+		}
+	}
+
+	if err := a.MSetState(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, 0); err != nil {
+		t.Fatalf("MSetState: %v", err)
+	}
+	got, err := a.MGetState(ctx, []string{"a", "b", "missing"})
+	if err != nil || !reflect.DeepEqual(got, map[string][]byte{"a": []byte("1"), "b": []byte("2")}) {
+		t.Fatalf("MGetState = %q, %v", got, err)
+	}
+
+	if err := a.DeleteState(ctx, "k"); err != nil {
+		t.Fatalf("DeleteState: %v", err)
+	}
+	if ok, err := a.ExistsState(ctx, "k"); ok || err != nil {
+		t.Fatalf("ExistsState after delete = %v, %v", ok, err)
+	}
+}
+
+func TestSQLKeepTTL(t *testing.T) {
+	ctx := context.Background()
+	a, now := newSQLiteAdapter(t)
+
+	if err := a.SetState(ctx, "k", []byte("1"), 100); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	want := a.expiresAtOf(t, "k")
+	*now = now.Add(10 * time.Second)
+	if err := a.SetState(ctx, "k", []byte("2"), KeepTTL); err != nil {
+		t.Fatalf("SetState KeepTTL: %v", err)
+	}
+	if got := a.expiresAtOf(t, "k"); got != want {
+		t.Fatalf("expires_at = %d, want the kept %d", got, want)
+	}
+	if value, _ := a.GetState(ctx, "k"); string(value) != "2" {
+		t.Fatalf("value = %q, want 2", value)
+	}
+
+	// A missing or expired row has no TTL to keep.
+	if err := a.SetState(ctx, "new", []byte("1"), KeepTTL); err != nil {
+		t.Fatalf("SetState KeepTTL: %v", err)
+	}
+	if got := a.expiresAtOf(t, "new"); got != 0 {
+		t.Fatalf("new key expires_at = %d, want 0", got)
+	}
+	*now = now.Add(100 * time.Second)
+	if err := a.SetState(ctx, "k", []byte("3"), KeepTTL); err != nil {
+		t.Fatalf("SetState KeepTTL: %v", err)
+	}
+	if got := a.expiresAtOf(t, "k"); got != 0 {
+		t.Fatalf("rewritten expired key expires_at = %d, want 0", got)
+	}
+}
+
+func TestSQLCompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	a, now := newSQLiteAdapter(t)
+
+	ver, err := a.CompareAndSet(ctx, "k", 0, []byte("one"), 0)
+	if err != nil || ver != 1 {
+		t.Fatalf("create: %d, %v; want 1", ver, err)
+	}
+	if _, err := a.CompareAndSet(ctx, "k", 0, []byte("again"), 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("create over an existing key: err = %v, want ErrVersionMismatch", err)
+	}
+	if ver, err = a.CompareAndSet(ctx, "k", 1, []byte("two"), 0); err != nil || ver != 2 {
+		t.Fatalf("update: %d, %v; want 2", ver, err)
+	}
+	if _, err := a.CompareAndSet(ctx, "k", 1, []byte("stale"), 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("stale update: err = %v, want ErrVersionMismatch", err)
+	}
+	// SetState counts as a write too.
+	if err := a.SetState(ctx, "k", []byte("three"), 0); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if _, err := a.CompareAndSet(ctx, "k", 2, []byte("stale"), 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("update after SetState: err = %v, want ErrVersionMismatch", err)
+	}
+	if value, ver, _ := a.GetVersioned(ctx, "k"); string(value) != "three" || ver != 3 {
+		t.Fatalf("GetVersioned = %q, %d; want three, 3", value, ver)
+	}
+
+	// An expired row counts as missing: version 0 recreates it, any other
+	// version fails.
+	if _, err := a.CompareAndSet(ctx, "e", 0, []byte("short"), 5); err != nil {
+		t.Fatalf("create e: %v", err)
+	}
+	*now = now.Add(5 * time.Second)
+	if _, err := a.CompareAndSet(ctx, "e", 1, []byte("late"), 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("update of an expired key: err = %v, want ErrVersionMismatch", err)
+	}
+	if ver, err := a.CompareAndSet(ctx, "e", 0, []byte("fresh"), 0); err != nil || ver != 1 {
+		t.Fatalf("create over an expired key: %d, %v; want 1", ver, err)
+	}
+	if value, _ := a.GetState(ctx, "e"); string(value) != "fresh" {
+		t.Fatalf("value = %q, want fresh", value)
+	}
+}
+
+func TestSQLExpiry(t *testing.T) {
+	ctx := context.Background()
+	a, now := newSQLiteAdapter(t)
+
+	if err := a.SetState(ctx, "short", []byte("1"), 2); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if err := a.SetState(ctx, "forever", []byte("1"), 0); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if _, ttl, err := a.GetStateTTL(ctx, "short"); err != nil || ttl != 2 {
+		t.Fatalf("GetStateTTL = %d, %v; want 2", ttl, err)
+	}
+	if _, ttl, err := a.GetStateTTL(ctx, "forever"); err != nil || ttl != 0 {
+		t.Fatalf("GetStateTTL = %d, %v; want 0", ttl, err)
+	}
+	*now = now.Add(1500 * time.Millisecond)
+	if _, ttl, _ := a.GetStateTTL(ctx, "short"); ttl != -1 {
+		t.Fatalf("GetStateTTL under a second = %d, want -1", ttl)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if _, err := a.GetState(ctx, "short"); !errors.Is(err, ErrStateNotFound) {
+		t.Fatalf("GetState after expiry: err = %v, want ErrStateNotFound", err)
+	}
+	if ok, _ := a.ExistsState(ctx, "short"); ok {
+		t.Fatal("expired key exists")
+	}
+	if got, _ := a.MGetState(ctx, []string{"short", "forever"}); len(got) != 1 {
+		t.Fatalf("MGetState = %q, want only forever", got)
+	}
+	if err := a.ExpireState(ctx, "short", 10); err != nil {
+		t.Fatalf("ExpireState: %v", err)
+	}
+	if ok, _ := a.ExistsState(ctx, "short"); ok {
+		t.Fatal("ExpireState revived an expired key")
+	}
+
+	if err := a.ExpireState(ctx, "forever", 1); err != nil {
+		t.Fatalf("ExpireState: %v", err)
+	}
+	*now = now.Add(time.Second)
+	n, err := a.PurgeExpired(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("PurgeExpired = %d, %v; want 2", n, err)
+	}
+}
+
+func TestSQLScanKeys(t *testing.T) {
+	ctx := context.Background()
+	a, now := newSQLiteAdapter(t)
+
+	var want []string
+	for i := 0; i < 1200; i++ {
+		key := fmt.Sprintf("player:%04d", i)
+		want = append(want, key)
+		if err := a.SetState(ctx, key, []byte("x"), 0); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+	}
+	// Neighbours that LIKE would match: other case, and the wildcards.
+	for _, key := range []string{"Player:1", "PLAYER:2", "playerX", "player", "players:1", "p_ayer:1", "p%:1", "q"} {
+		if err := a.SetState(ctx, key, []byte("x"), 0); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+	}
+	if err := a.SetState(ctx, "player:gone", []byte("x"), 1); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	*now = now.Add(time.Second)
+
+	scan := func(prefix string) []string {
+		var keys []string
+		if err := a.ScanKeys(ctx, prefix, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("ScanKeys(%q): %v", prefix, err)
+		}
+		return keys
+	}
+
+	if got := scan("player:"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ScanKeys(player:) returned %d keys, want %d; first %q", len(got), len(want), got[:min(len(got), 3)])
+	}
+	for prefix, want := range map[string][]string{
+		"Player:": {"Player:1"},
+		"p_":      {"p_ayer:1"},
+		"p%":      {"p%:1"},
+		"players": {"players:1"},
+		"q":       {"q"},
+		"z":       nil,
+	} {
+		if got := scan(prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("ScanKeys(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+	if got := scan(""); len(got) != 1200+8 {
+		t.Errorf("ScanKeys(\"\") returned %d keys, want %d", len(got), 1200+8)
+	}
+
+	// Writing during the scan neither repeats nor skips keys.
+	count := 0
+	stop := errors.New("stop")
+	err := a.ScanKeys(ctx, "player:", func(key string) error {
+		count++
+		if err := a.SetState(ctx, key, []byte("y"), KeepTTL); err != nil {
+			return err
+		}
+		if count == 700 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 700 {
+		t.Fatalf("ScanKeys stopped after %d keys with %v", count, err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":            "",
+		"player:":     "player;",
+		"a\xff":       "b",
+		"\xff\xff":    "",
+		"玩家":          "玩宷",
+		"a\U0010FFFF": "b",
+		"\U0010FFFF":  "",
+		"a\uD7FF":     "a\uE000",
+		"p\xffz":      "p\xff{",
+		"key\x00":     "key\x01",
+	} {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"zinxplusplus/ziface"
+)
+
+// ttlStore is implemented by stores that report how long a value has left,
+// like SQLStateAdapter; ttl is 0 without expiry and negative when under a
+// second remains.
+type ttlStore interface {
+	GetStateTTL(ctx context.Context, key string) (value []byte, ttl int64, err error)
+}
+
+// tieredWriteStripes is the number of write counters keys hash onto.
+const tieredWriteStripes = 256
+
+// TieredStateManager puts a cache tier (usually Redis) in front of a durable
+// store (usually SQL). Reads go to the cache first and fill it from the
+// store on a miss. Writes and deletes go to the store, which always holds
+// the truth, and then invalidate the cache entry; only reads fill it.
+//
+// Writing the cache from the writer would let two concurrent writers leave
+// the older value cached for good. Invalidating avoids that, and a fill
+// that raced a write in this process is dropped again. A fill that races a
+// write made through another server sharing the cache can still leave the
+// old value cached, so set a cache TTL to bound how long that lasts.
+//
+// A cache that fails is bypassed rather than failing the call: reads fall
+// back to the store.
+type TieredStateManager struct {
+	cache ziface.IStateManager
+	store ziface.IStateManager
+	// cacheTTL in seconds caps how long entries live in the cache; 0 leaves
+	// them to the expiration of the stored row.
+	cacheTTL int64
+	// writes counts writes per key stripe so a fill can tell it raced one.
+	writes [tieredWriteStripes]atomic.Uint64
+}
+
+func NewTieredStateManager(cache, store ziface.IStateManager, cacheTTL int64) ziface.IStateManager {
+	return &TieredStateManager{cache: cache, store: store, cacheTTL: cacheTTL}
+}
+
+func (t *TieredStateManager) Cache() ziface.IStateManager {
+	return t.cache
+}
+
+func (t *TieredStateManager) Store() ziface.IStateManager {
+	return t.store
+}
+
+// cacheExpiration is the shorter of expiration and the cache TTL.
+func (t *TieredStateManager) cacheExpiration(expiration int64) int64 {
+	if expiration <= 0 || (t.cacheTTL > 0 && t.cacheTTL < expiration) {
+		return t.cacheTTL
+	}
+	return expiration
+}
+
+func (t *TieredStateManager) GetState(ctx context.Context, key string) ([]byte, error) {
+	value, err := t.cache.GetState(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, ErrStateNotFound) {
+		fmt.Printf("[TieredState] Cache read of %s failed, reading store: %v\n", key, err)
+	}
+
+	writes := t.writeCounter(key)
+	seen := writes.Load()
+	value, ttl, err := t.readStore(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// A filled entry must not outlive the row: it gets the row's remaining
+	// TTL capped by the cache TTL. When the store cannot tell, only a cache
+	// TTL bounds it, and without one the entry is not cached at all.
+	if ttl >= 0 {
+		if err := t.cache.SetState(ctx, key, value, t.cacheExpiration(ttl)); err != nil {
+			fmt.Printf("[TieredState] Cache fill of %s failed: %v\n", key, err)
+		} else if writes.Load() != seen {
+			// A write landed while the store was read; what was filled may
+			// predate it.
+			_ = t.cache.DeleteState(ctx, key)
+		}
+	}
+	return value, nil
+}
+
+// readStore reads key from the store with its remaining TTL, or -1 when it
+// is unknown or too short to cache.
+func (t *TieredStateManager) readStore(ctx context.Context, key string) ([]byte, int64, error) {
+	if ts, ok := t.store.(ttlStore); ok {
+		return ts.GetStateTTL(ctx, key)
+	}
+	value, err := t.store.GetState(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.cacheTTL <= 0 {
+		return value, -1, nil
+	}
+	return value, t.cacheTTL, nil
+}
+
+func (t *TieredStateManager) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
+	if err := t.store.SetState(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	if err := t.invalidate(ctx, key); err != nil {
+		return fmt.Errorf("key %s written to store but cache may be stale: %w", key, err)
+	}
+	return nil
+}
+
+func (t *TieredStateManager) DeleteState(ctx context.Context, key string) error {
+	if err := t.store.DeleteState(ctx, key); err != nil {
+		return err
+	}
+	if err := t.invalidate(ctx, key); err != nil {
+		return fmt.Errorf("key %s deleted from store but still cached: %w", key, err)
+	}
+	return nil
+}
+
+func (t *TieredStateManager) ExistsState(ctx context.Context, key string) (bool, error) {
+	ok, err := t.cache.ExistsState(ctx, key)
+	if err == nil && ok {
+		return true, nil
+	}
+	if err != nil {
+		fmt.Printf("[TieredState] Cache exists of %s failed, asking store: %v\n", key, err)
+	}
+	return t.store.ExistsState(ctx, key)
+}
+
+// ScanKeys scans the store, which holds every key, so MigratePrefix can run
+// through the tiers and keep the cache in step.
+func (t *TieredStateManager) ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error {
+	scanner, ok := t.store.(MigrateStore)
+	if !ok {
+		return fmt.Errorf("store %T cannot scan keys", t.store)
+	}
+	return scanner.ScanKeys(ctx, prefix, fn)
+}
+
+// invalidate drops key from the cache after the store changed. The write
+// counter moves first, so a fill that read the store before this write
+// either sees it and drops its entry or lands before the delete below.
+func (t *TieredStateManager) invalidate(ctx context.Context, key string) error {
+	t.writeCounter(key).Add(1)
+	return t.cache.DeleteState(ctx, key)
+}
+
+func (t *TieredStateManager) writeCounter(key string) *atomic.Uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &t.writes[h.Sum32()%tieredWriteStripes]
+}
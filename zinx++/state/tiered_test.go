@@ -0,0 +1,246 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"zinxplusplus/ziface"
+)
+
+func newRedisCache(t *testing.T) (*miniredis.Miniredis, ziface.IStateManager) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cache, err := NewRedisStateAdapter(RedisConfig{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatalf("NewRedisStateAdapter: %v", err)
+	}
+	t.Cleanup(func() { cache.(*RedisStateAdapter).Close() })
+	return mr, cache
+}
+
+func TestTieredFillKeepsStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newSQLiteAdapter(t)
+	mr, cache := newRedisCache(t)
+
+	for _, tc := range []struct {
+		cacheTTL int64
+		rowTTL   int64
+		want     time.Duration
+	}{
+		{cacheTTL: 0, rowTTL: 0, want: 0},
+		{cacheTTL: 0, rowTTL: 30, want: 30 * time.Second},
+		{cacheTTL: 60, rowTTL: 0, want: 60 * time.Second},
+		{cacheTTL: 60, rowTTL: 30, want: 30 * time.Second},
+		{cacheTTL: 10, rowTTL: 30, want: 10 * time.Second},
+	} {
+		tiered := NewTieredStateManager(cache, store, tc.cacheTTL)
+		if err := store.SetState(ctx, "k", []byte("v"), tc.rowTTL); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+		mr.Del("k")
+		if got, err := tiered.GetState(ctx, "k"); err != nil || string(got) != "v" {
+			t.Fatalf("GetState = %q, %v", got, err)
+		}
+		if !mr.Exists("k") {
+			t.Fatalf("cacheTTL %d, row TTL %d: not filled", tc.cacheTTL, tc.rowTTL)
+		}
+		if got := mr.TTL("k"); got != tc.want {
+			t.Errorf("cacheTTL %d, row TTL %d: cache TTL = %v, want %v", tc.cacheTTL, tc.rowTTL, got, tc.want)
+		}
+	}
+}
+
+func TestTieredFillWithoutStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStateAdapter()
+	mr, cache := newRedisCache(t)
+	if err := store.SetState(ctx, "k", []byte("v"), 30); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	// The store cannot say when the value expires, so without a cache TTL
+	// the value is not cached at all.
+	if got, err := NewTieredStateManager(cache, store, 0).GetState(ctx, "k"); err != nil || string(got) != "v" {
+		t.Fatalf("GetState = %q, %v", got, err)
+	}
+	if mr.Exists("k") {
+		t.Fatal("filled the cache with no bound on the entry's life")
+	}
+
+	if _, err := NewTieredStateManager(cache, store, 5).GetState(ctx, "k"); err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	if got := mr.TTL("k"); got != 5*time.Second {
+		t.Fatalf("cache TTL = %v, want the 5s cache TTL", got)
+	}
+}
+
+func TestTieredKeepTTLEvicts(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newSQLiteAdapter(t)
+	mr, cache := newRedisCache(t)
+	tiered := NewTieredStateManager(cache, store, 0)
+
+	if err := tiered.SetState(ctx, "k", []byte("1"), 30); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	if err := tiered.SetState(ctx, "k", []byte("2"), KeepTTL); err != nil {
+		t.Fatalf("SetState KeepTTL: %v", err)
+	}
+	if mr.Exists("k") {
+		t.Fatal("KeepTTL write left the key cached")
+	}
+	if got, err := tiered.GetState(ctx, "k"); err != nil || string(got) != "2" {
+		t.Fatalf("GetState = %q, %v", got, err)
+	}
+	if got := mr.TTL("k"); got != 30*time.Second {
+		t.Fatalf("refilled cache TTL = %v, want 30s", got)
+	}
+}
+
+func TestMigratePrefixSQL(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newSQLiteAdapter(t)
+	mr, cache := newRedisCache(t)
+
+	schemas := NewSchemaRegistry()
+	schemas.Define("p:", 1)
+	old, err := DefaultCodecs().UseSchemas(schemas).Marshal("p:1", map[string]interface{}{"lvl": 3})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	schemas.Define("p:", 2).Migration(1, func(doc map[string]interface{}) error {
+		doc["level"] = doc["lvl"]
+		delete(doc, "lvl")
+		return nil
+	})
+	codecs := DefaultCodecs().UseSchemas(schemas)
+
+	check := func(sm ziface.IStateManager) {
+		t.Helper()
+		data, err := sm.GetState(ctx, "p:1")
+		if err != nil {
+			t.Fatalf("GetState: %v", err)
+		}
+		if _, changed, err := codecs.Upgrade("p:1", data, nil); err != nil || changed {
+			t.Fatalf("stored blob is not current: changed %v, err %v", changed, err)
+		}
+	}
+
+	for name, sm := range map[string]MigrateStore{
+		"sql":    store,
+		"tiered": NewTieredStateManager(cache, store, 0).(MigrateStore),
+	} {
+		if err := store.SetState(ctx, "p:1", old, 0); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+		if err := cache.SetState(ctx, "p:1", old, 0); err != nil {
+			t.Fatalf("SetState: %v", err)
+		}
+		stats, err := MigratePrefix(ctx, sm, codecs, "p:", MigrateOptions{})
+		if err != nil || stats.Migrated != 1 {
+			t.Fatalf("%s: MigratePrefix = %+v, %v", name, stats, err)
+		}
+		check(store)
+		if name == "tiered" && mr.Exists("p:1") {
+			t.Fatal("tiered migration left the old blob cached")
+		}
+	}
+
+	// A store that cannot scan makes the tiered manager refuse too.
+	var noScan struct{ ziface.IStateManager }
+	noScan.IStateManager = store
+	if _, err := MigratePrefix(ctx, NewTieredStateManager(cache, noScan, 0).(MigrateStore), codecs, "p:", MigrateOptions{}); err == nil {
+		t.Fatal("migrated through a store that cannot scan")
+	}
+}
+
+// pausingStore runs pause after reading or writing value, so tests can
+// interleave other calls at that point.
+type pausingStore struct {
+	ziface.IStateManager
+	value string
+	pause func()
+}
+
+func (p *pausingStore) GetState(ctx context.Context, key string) ([]byte, error) {
+	data, err := p.IStateManager.GetState(ctx, key)
+	if err == nil && string(data) == p.value {
+		p.pause()
+	}
+	return data, err
+}
+
+func (p *pausingStore) SetState(ctx context.Context, key string, value []byte, expiration int64) error {
+	err := p.IStateManager.SetState(ctx, key, value, expiration)
+	if err == nil && string(value) == p.value {
+		p.pause()
+	}
+	return err
+}
+
+func TestTieredInterleavedWriters(t *testing.T) {
+	ctx := context.Background()
+	reached, release := make(chan struct{}), make(chan struct{})
+	store := &pausingStore{IStateManager: NewMemoryStateAdapter(), value: "a", pause: func() {
+		close(reached)
+		<-release
+	}}
+	cache := NewMemoryStateAdapter()
+	tiered := NewTieredStateManager(cache, store, 60)
+
+	// A writes the store, then B writes store and cache, then A finishes.
+	done := make(chan error)
+	go func() { done <- tiered.SetState(ctx, "k", []byte("a"), 0) }()
+	<-reached
+	if err := tiered.SetState(ctx, "k", []byte("b"), 0); err != nil {
+		t.Fatalf("SetState b: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("SetState a: %v", err)
+	}
+
+	stored, _ := store.IStateManager.GetState(ctx, "k")
+	if got, err := tiered.GetState(ctx, "k"); err != nil || string(got) != string(stored) {
+		t.Fatalf("GetState = %q, %v; store holds %q", got, err, stored)
+	}
+	if cached, err := cache.GetState(ctx, "k"); err != nil || string(cached) != string(stored) {
+		t.Fatalf("cache holds %q, %v; store holds %q", cached, err, stored)
+	}
+}
+
+func TestTieredFillRacingWrite(t *testing.T) {
+	ctx := context.Background()
+	reached, release := make(chan struct{}), make(chan struct{})
+	store := &pausingStore{IStateManager: NewMemoryStateAdapter(), value: "old", pause: func() {
+		close(reached)
+		<-release
+	}}
+	cache := NewMemoryStateAdapter()
+	tiered := NewTieredStateManager(cache, store, 60)
+	if err := store.IStateManager.SetState(ctx, "k", []byte("old"), 0); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	// The reader has read "old" from the store when the write lands.
+	done := make(chan struct{})
+	go func() {
+		_, _ = tiered.GetState(ctx, "k")
+		close(done)
+	}()
+	<-reached
+	if err := tiered.SetState(ctx, "k", []byte("new"), 0); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+	close(release)
+	<-done
+
+	if got, err := tiered.GetState(ctx, "k"); err != nil || string(got) != "new" {
+		t.Fatalf("GetState = %q, %v after a racing fill, want new", got, err)
+	}
+}
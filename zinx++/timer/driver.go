@@ -0,0 +1,149 @@
+package timer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Overrun struct {
+	Tick    uint64
+	Elapsed time.Duration
+	Budget  time.Duration
+	Skipped int
+}
+
+type TickStats struct {
+	Ticks        uint64
+	Overruns     uint64
+	SkippedTicks uint64
+	LastDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// TickDriver runs handlers at a fixed timestep. When the owner falls behind it
+// catches up by running up to maxCatchUp ticks back to back; anything beyond
+// that is dropped and reported as an overrun, as is any single tick that takes
+// longer than its step.
+type TickDriver struct {
+	step       time.Duration
+	maxCatchUp int
+
+	handlers  []func(tick uint64, dt time.Duration)
+	onOverrun func(Overrun)
+
+	tick        uint64
+	accumulator time.Duration
+	lastStep    time.Time
+	stats       TickStats
+	lock        sync.Mutex
+}
+
+func NewTickDriver(step time.Duration, maxCatchUp int) *TickDriver {
+	if step <= 0 {
+		step = 50 * time.Millisecond
+	}
+	if maxCatchUp <= 0 {
+		maxCatchUp = 5
+	}
+	return &TickDriver{
+		step:       step,
+		maxCatchUp: maxCatchUp,
+	}
+}
+
+func (td *TickDriver) Step() time.Duration {
+	return td.step
+}
+
+func (td *TickDriver) OnTick(handler func(tick uint64, dt time.Duration)) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	td.handlers = append(td.handlers, handler)
+}
+
+func (td *TickDriver) SetOverrunHandler(handler func(Overrun)) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	td.onOverrun = handler
+}
+
+func (td *TickDriver) Stats() TickStats {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	return td.stats
+}
+
+// Advance runs as many fixed ticks as the time since the previous call
+// covers, on the caller's goroutine.
+func (td *TickDriver) Advance(now time.Time) {
+	td.lock.Lock()
+	if td.lastStep.IsZero() {
+		td.lastStep = now
+		td.lock.Unlock()
+		return
+	}
+	td.accumulator += now.Sub(td.lastStep)
+	td.lastStep = now
+
+	due := int(td.accumulator / td.step)
+	skipped := 0
+	if due > td.maxCatchUp {
+		skipped = due - td.maxCatchUp
+		due = td.maxCatchUp
+	}
+	td.accumulator -= time.Duration(due+skipped) * td.step
+	handlers := td.handlers
+	onOverrun := td.onOverrun
+	td.lock.Unlock()
+
+	if skipped > 0 {
+		td.lock.Lock()
+		td.stats.Overruns++
+		td.stats.SkippedTicks += uint64(skipped)
+		td.lock.Unlock()
+		td.report(onOverrun, Overrun{Tick: td.tick, Elapsed: time.Duration(due+skipped) * td.step, Budget: td.step, Skipped: skipped})
+	}
+
+	for i := 0; i < due; i++ {
+		td.tick++
+		start := time.Now()
+		for _, h := range handlers {
+			td.runHandler(h)
+		}
+		elapsed := time.Since(start)
+
+		td.lock.Lock()
+		td.stats.Ticks++
+		td.stats.LastDuration = elapsed
+		if elapsed > td.stats.MaxDuration {
+			td.stats.MaxDuration = elapsed
+		}
+		overrun := elapsed > td.step
+		if overrun {
+			td.stats.Overruns++
+		}
+		td.lock.Unlock()
+
+		if overrun {
+			td.report(onOverrun, Overrun{Tick: td.tick, Elapsed: elapsed, Budget: td.step})
+		}
+	}
+}
+
+func (td *TickDriver) runHandler(h func(tick uint64, dt time.Duration)) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Timer] Tick %d handler panic: %v\n", td.tick, err)
+		}
+	}()
+	h(td.tick, td.step)
+}
+
+func (td *TickDriver) report(onOverrun func(Overrun), o Overrun) {
+	if onOverrun == nil {
+		fmt.Printf("[Timer] Tick %d overrun: elapsed=%v budget=%v skipped=%d\n", o.Tick, o.Elapsed, o.Budget, o.Skipped)
+		return
+	}
+	onOverrun(o)
+}
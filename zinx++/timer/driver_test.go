@@ -0,0 +1,57 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTickDriverCatchUp(t *testing.T) {
+	td := NewTickDriver(10*time.Millisecond, 3)
+	var ticks []uint64
+	td.OnTick(func(tick uint64, dt time.Duration) {
+		if dt != 10*time.Millisecond {
+			t.Errorf("dt = %v", dt)
+		}
+		ticks = append(ticks, tick)
+	})
+	var overruns []Overrun
+	td.SetOverrunHandler(func(o Overrun) { overruns = append(overruns, o) })
+
+	t0 := time.Now()
+	td.Advance(t0)
+	if len(ticks) != 0 {
+		t.Fatalf("first Advance ran %d ticks", len(ticks))
+	}
+
+	// 25ms: two ticks, 5ms carried over.
+	td.Advance(t0.Add(25 * time.Millisecond))
+	if len(ticks) != 2 {
+		t.Fatalf("ticks after 25ms = %v", ticks)
+	}
+
+	// An 80ms stall plus the carried 5ms covers 8 ticks: 3 run, 5 dropped.
+	td.Advance(t0.Add(105 * time.Millisecond))
+	if len(ticks) != 5 {
+		t.Fatalf("ticks after the stall = %v, want 5 in total", ticks)
+	}
+	if len(overruns) != 1 || overruns[0].Skipped != 5 {
+		t.Fatalf("overruns = %+v, want one with 5 skipped", overruns)
+	}
+
+	// The remaining 5ms plus 10ms is one more tick; the dropped ones are not
+	// replayed.
+	td.Advance(t0.Add(115 * time.Millisecond))
+	if len(ticks) != 6 {
+		t.Fatalf("ticks after the stall cleared = %v, want 6", ticks)
+	}
+	for i, tick := range ticks {
+		if tick != uint64(i+1) {
+			t.Fatalf("tick numbers = %v, want consecutive", ticks)
+		}
+	}
+
+	stats := td.Stats()
+	if stats.Ticks != 6 || stats.SkippedTicks != 5 || stats.Overruns != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,5 @@
+package timer
+
+import "zinxplusplus/ziface"
+
+type IScheduler = ziface.IScheduler
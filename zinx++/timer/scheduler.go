@@ -0,0 +1,108 @@
+package timer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler pairs a TickDriver with a TimerWheel at the same resolution.
+// Started on its own it runs both from a private goroutine; an owner with its
+// own loop (e.g. a scene) calls Pump instead so timers and ticks share that
+// goroutine.
+type Scheduler struct {
+	driver *TickDriver
+	wheel  *TimerWheel
+
+	exit    chan struct{}
+	done    chan struct{}
+	started bool
+	lock    sync.Mutex
+}
+
+func NewScheduler(step time.Duration, maxCatchUp int) *Scheduler {
+	driver := NewTickDriver(step, maxCatchUp)
+	return &Scheduler{
+		driver: driver,
+		wheel:  NewTimerWheel(driver.Step()),
+	}
+}
+
+func (s *Scheduler) AfterFunc(d time.Duration, task func()) uint64 {
+	return s.wheel.AfterFunc(d, task)
+}
+
+func (s *Scheduler) Every(interval time.Duration, task func()) uint64 {
+	return s.wheel.Every(interval, task)
+}
+
+func (s *Scheduler) Cancel(timerID uint64) bool {
+	return s.wheel.Cancel(timerID)
+}
+
+func (s *Scheduler) OnTick(handler func(tick uint64, dt time.Duration)) {
+	s.driver.OnTick(handler)
+}
+
+func (s *Scheduler) SetOverrunHandler(handler func(Overrun)) {
+	s.driver.SetOverrunHandler(handler)
+}
+
+func (s *Scheduler) Stats() TickStats {
+	return s.driver.Stats()
+}
+
+func (s *Scheduler) Step() time.Duration {
+	return s.driver.Step()
+}
+
+func (s *Scheduler) Pump(now time.Time) {
+	s.wheel.Advance(now)
+	s.driver.Advance(now)
+}
+
+func (s *Scheduler) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	s.exit = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.loop(s.exit, s.done)
+	fmt.Printf("[Timer] Scheduler started, step=%v\n", s.driver.Step())
+}
+
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	if !s.started {
+		s.lock.Unlock()
+		return
+	}
+	s.started = false
+	close(s.exit)
+	done := s.done
+	s.lock.Unlock()
+
+	<-done
+	fmt.Println("[Timer] Scheduler stopped.")
+}
+
+func (s *Scheduler) loop(exit, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.driver.Step())
+	defer ticker.Stop()
+
+	s.Pump(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Pump(now)
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerStopWithPendingTimers(t *testing.T) {
+	s := NewScheduler(5*time.Millisecond, 5)
+	fired := make(chan string, 2)
+	s.AfterFunc(10*time.Millisecond, func() { fired <- "early" })
+	s.AfterFunc(60*time.Millisecond, func() { fired <- "late" })
+
+	s.Start()
+	select {
+	case got := <-fired:
+		if got != "early" {
+			t.Fatalf("fired %s first", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire while running")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked with a timer pending")
+	}
+	s.Stop()
+
+	select {
+	case got := <-fired:
+		t.Fatalf("timer %s fired after Stop", got)
+	case <-time.After(150 * time.Millisecond):
+	}
+	if n := s.wheel.Len(); n != 1 {
+		t.Fatalf("pending timers after Stop = %d, want 1", n)
+	}
+
+	// A restart runs what fell due while stopped.
+	s.Start()
+	defer s.Stop()
+	select {
+	case got := <-fired:
+		if got != "late" {
+			t.Fatalf("fired %s after restart", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending timer did not fire after restart")
+	}
+}
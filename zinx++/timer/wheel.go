@@ -0,0 +1,202 @@
+package timer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+type timerEntry struct {
+	id        uint64
+	expire    uint64
+	interval  uint64
+	task      func()
+	cancelled bool
+}
+
+// TimerWheel is a hierarchical timing wheel with wheelLevels levels of
+// wheelSlots slots. It does not own a clock: Advance moves it forward and runs
+// due callbacks on the caller's goroutine, so the owner decides where timers
+// execute.
+type TimerWheel struct {
+	resolution time.Duration
+	origin     time.Time
+	current    uint64
+
+	slots  [wheelLevels][wheelSlots][]*timerEntry
+	timers map[uint64]*timerEntry
+	nextID uint64
+	lock   sync.Mutex
+}
+
+func NewTimerWheel(resolution time.Duration) *TimerWheel {
+	if resolution <= 0 {
+		resolution = 10 * time.Millisecond
+	}
+	return &TimerWheel{
+		resolution: resolution,
+		origin:     time.Now(),
+		timers:     make(map[uint64]*timerEntry),
+	}
+}
+
+func (tw *TimerWheel) Resolution() time.Duration {
+	return tw.resolution
+}
+
+func (tw *TimerWheel) AfterFunc(d time.Duration, task func()) uint64 {
+	return tw.add(tw.toTicks(d), 0, task)
+}
+
+func (tw *TimerWheel) Every(interval time.Duration, task func()) uint64 {
+	ticks := tw.toTicks(interval)
+	return tw.add(ticks, ticks, task)
+}
+
+func (tw *TimerWheel) Cancel(timerID uint64) bool {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	t, ok := tw.timers[timerID]
+	if !ok {
+		return false
+	}
+	t.cancelled = true
+	delete(tw.timers, timerID)
+	return true
+}
+
+func (tw *TimerWheel) Len() int {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	return len(tw.timers)
+}
+
+// Advance runs every timer due at or before now.
+func (tw *TimerWheel) Advance(now time.Time) {
+	target := uint64(now.Sub(tw.origin) / tw.resolution)
+	for {
+		tw.lock.Lock()
+		if tw.current >= target {
+			tw.lock.Unlock()
+			return
+		}
+		due := tw.stepLocked()
+		tw.lock.Unlock()
+
+		for _, t := range due {
+			tw.run(t)
+		}
+	}
+}
+
+func (tw *TimerWheel) toTicks(d time.Duration) uint64 {
+	ticks := uint64((d + tw.resolution - 1) / tw.resolution)
+	if ticks == 0 {
+		ticks = 1
+	}
+	return ticks
+}
+
+func (tw *TimerWheel) add(delay, interval uint64, task func()) uint64 {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	tw.nextID++
+	t := &timerEntry{
+		id:       tw.nextID,
+		expire:   tw.current + delay,
+		interval: interval,
+		task:     task,
+	}
+	tw.timers[t.id] = t
+	tw.placeLocked(t)
+	return t.id
+}
+
+func (tw *TimerWheel) placeLocked(t *timerEntry) {
+	delta := t.expire - tw.current
+	for level := 0; level < wheelLevels; level++ {
+		if delta < uint64(1)<<(wheelBits*(level+1)) || level == wheelLevels-1 {
+			expire := t.expire
+			if level == wheelLevels-1 {
+				// Beyond the top level's span: park it in the furthest slot and
+				// let the cascade re-place it when that slot comes around.
+				limit := tw.current + (uint64(1) << (wheelBits * wheelLevels)) - 1
+				if expire > limit {
+					expire = limit
+				}
+			}
+			slot := (expire >> (wheelBits * level)) & wheelMask
+			tw.slots[level][slot] = append(tw.slots[level][slot], t)
+			return
+		}
+	}
+}
+
+func (tw *TimerWheel) stepLocked() []*timerEntry {
+	tw.current++
+
+	for level := 1; level < wheelLevels; level++ {
+		if tw.current&((uint64(1)<<(wheelBits*level))-1) != 0 {
+			break
+		}
+		slot := (tw.current >> (wheelBits * level)) & wheelMask
+		entries := tw.slots[level][slot]
+		tw.slots[level][slot] = nil
+		for _, t := range entries {
+			if !t.cancelled {
+				tw.placeLocked(t)
+			}
+		}
+	}
+
+	slot := tw.current & wheelMask
+	entries := tw.slots[0][slot]
+	tw.slots[0][slot] = nil
+
+	due := entries[:0]
+	for _, t := range entries {
+		if t.cancelled {
+			continue
+		}
+		if t.expire > tw.current {
+			tw.placeLocked(t)
+			continue
+		}
+		due = append(due, t)
+		if t.interval > 0 {
+			t.expire = tw.current + t.interval
+			tw.placeLocked(t)
+		}
+	}
+	return due
+}
+
+// run fires t unless a callback run earlier in the same Advance cancelled it.
+// A one-shot timer stays cancellable until this point.
+func (tw *TimerWheel) run(t *timerEntry) {
+	tw.lock.Lock()
+	cancelled := t.cancelled
+	if !cancelled && t.interval == 0 {
+		delete(tw.timers, t.id)
+	}
+	tw.lock.Unlock()
+	if cancelled {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Timer] Timer %d callback panic: %v\n", t.id, err)
+		}
+	}()
+	t.task()
+}
@@ -0,0 +1,95 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns the wall time of tick n on tw.
+func at(tw *TimerWheel, n uint64) time.Time {
+	return tw.origin.Add(time.Duration(n) * tw.resolution)
+}
+
+func TestTimerWheelCascade(t *testing.T) {
+	tw := NewTimerWheel(time.Millisecond)
+
+	// Delays on level 0, at level boundaries, deep in the upper levels and
+	// past the span of the top level.
+	delays := []uint64{1, 5, 63, 64, 65, 100, 4095, 4096, 4097, 262144 + 3, 1<<(wheelBits*wheelLevels) + 10}
+	fired := make(map[uint64]uint64)
+	for _, d := range delays {
+		d := d
+		tw.AfterFunc(time.Duration(d)*time.Millisecond, func() { fired[d] = tw.current })
+	}
+
+	tw.Advance(at(tw, delays[len(delays)-1]))
+	for _, d := range delays {
+		got, ok := fired[d]
+		if !ok {
+			t.Errorf("timer with delay %d never fired", d)
+		} else if got != d {
+			t.Errorf("timer with delay %d fired at tick %d", d, got)
+		}
+	}
+	if n := tw.Len(); n != 0 {
+		t.Fatalf("Len = %d after every timer fired", n)
+	}
+}
+
+func TestTimerWheelEveryAcrossLevels(t *testing.T) {
+	tw := NewTimerWheel(time.Millisecond)
+
+	var ticks []uint64
+	tw.Every(100*time.Millisecond, func() { ticks = append(ticks, tw.current) })
+	tw.Advance(at(tw, 1000))
+
+	if len(ticks) != 10 {
+		t.Fatalf("fired %d times in 1000 ticks, want 10: %v", len(ticks), ticks)
+	}
+	for i, tick := range ticks {
+		if want := uint64(i+1) * 100; tick != want {
+			t.Fatalf("run %d at tick %d, want %d", i, tick, want)
+		}
+	}
+}
+
+func TestTimerWheelCancelWhileFiring(t *testing.T) {
+	tw := NewTimerWheel(time.Millisecond)
+
+	// a and b fall due on the same tick; a runs first and cancels b.
+	var ranB bool
+	var idB uint64
+	var cancelledB bool
+	tw.AfterFunc(5*time.Millisecond, func() { cancelledB = tw.Cancel(idB) })
+	idB = tw.AfterFunc(5*time.Millisecond, func() { ranB = true })
+
+	// A repeating timer that cancels itself from its own callback.
+	var runs int
+	var idEvery uint64
+	idEvery = tw.Every(2*time.Millisecond, func() {
+		runs++
+		if runs == 2 && !tw.Cancel(idEvery) {
+			t.Error("Cancel of a running repeating timer returned false")
+		}
+	})
+
+	// A one-shot timer is gone once it started.
+	var idOnce uint64
+	var cancelledOnce bool
+	idOnce = tw.AfterFunc(3*time.Millisecond, func() { cancelledOnce = tw.Cancel(idOnce) })
+
+	tw.Advance(at(tw, 20))
+
+	if !cancelledB || ranB {
+		t.Fatalf("timer cancelled by a callback on the same tick: Cancel = %v, ran = %v", cancelledB, ranB)
+	}
+	if runs != 2 {
+		t.Fatalf("self-cancelled repeating timer ran %d times, want 2", runs)
+	}
+	if cancelledOnce {
+		t.Fatal("Cancel of a one-shot timer from its own callback returned true")
+	}
+	if n := tw.Len(); n != 0 {
+		t.Fatalf("Len = %d, want 0", n)
+	}
+}
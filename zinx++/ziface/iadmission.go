@@ -0,0 +1,26 @@
+package ziface
+
+import (
+	"net"
+	"time"
+)
+
+type IAdmissionController interface {
+	Admit(addr net.Addr) error
+
+	Release(addr net.Addr)
+
+	ReportViolation(addr net.Addr, reason string)
+
+	ReportAuthFailure(addr net.Addr, reason string)
+
+	SetAllowList(cidrs []string) error
+
+	SetDenyList(cidrs []string) error
+
+	SetLimits(maxConnPerIP int, connRatePerIP float64, connBurstPerIP int)
+
+	Ban(ip net.IP, d time.Duration)
+
+	Unban(ip net.IP)
+}
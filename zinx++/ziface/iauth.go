@@ -0,0 +1,10 @@
+package ziface
+
+/*
+IAuthenticator 连接握手认证接口
+返回非 nil identity 表示认证通过；identity 与 error 都为 nil 表示握手尚未完成
+（例如多步 challenge-response）；返回 error 表示认证失败，连接将被关闭。
+*/
+type IAuthenticator interface {
+	Authenticate(request IRequest) (identity interface{}, err error)
+}
@@ -0,0 +1,28 @@
+package ziface
+
+import "context"
+
+/*
+IClient 客户端接口
+与服务端使用相同的 DataPack 封包与 IRouter 路由，供机器人、压测与集成测试复用。
+断线后按退避策略自动重连，每次连上都会产生新的 IConnection。
+*/
+type IClient interface {
+	Dial(ctx context.Context) error
+
+	Stop()
+
+	AddRouter(msgId uint32, router IRouter)
+
+	Conn() IConnection
+
+	IsConnected() bool
+
+	SendMsg(msgId uint32, data []byte) error
+
+	SendBuffMsg(msgId uint32, data []byte) error
+
+	SetOnConnStart(func(connection IConnection))
+
+	SetOnConnStop(func(connection IConnection))
+}
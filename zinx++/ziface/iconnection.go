@@ -8,6 +8,21 @@ import (
 	"github.com/cloudwego/netpoll"
 )
 
+type ConnSendStats struct {
+	QueuedMsgs  int
+	QueuedBytes int
+
+	SentMsgs  uint64
+	SentBytes uint64
+	Flushes   uint64
+
+	DroppedMsgs   uint64
+	DroppedBytes  uint64
+	CoalescedMsgs uint64
+
+	SlowConsumerEvents uint64
+}
+
 type IConnection interface {
 	Start()
 
@@ -25,6 +40,12 @@ type IConnection interface {
 
 	SendBuffMsg(msgId uint32, data []byte) error
 
+	SendPriorityMsg(priority uint8, msgId uint32, data []byte) error
+
+	SendCoalescedMsg(priority uint8, coalesceKey uint64, msgId uint32, data []byte) error
+
+	GetSendStats() ConnSendStats
+
 	SetProperty(key string, value interface{})
 
 	GetProperty(key string) (interface{}, error)
@@ -42,4 +63,12 @@ type IConnection interface {
 	SetCloseCallback(func(connection IConnection) error)
 
 	IsClosed() bool
+
+	IsAuthenticated() bool
+
+	GetIdentity() interface{}
+
+	StartRecording(path string) error
+
+	StopRecording() error
 }
@@ -0,0 +1,38 @@
+package ziface
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ILocker 基于状态后端的分布式锁，用于跨服互斥（交易、公会仓库、唯一物品生成等）。
+每次获取都带 TTL，持有者崩溃后锁会自动过期；Lease 获取的锁在后台自动续期直到 Release。
+锁被他人持有时 TryLock 返回 state.ErrLockHeld；Lock/Lease 会阻塞重试直到获取成功或 ctx 结束。
+*/
+type ILocker interface {
+	TryLock(ctx context.Context, key string, ttl time.Duration) (ILock, error)
+
+	Lock(ctx context.Context, key string, ttl time.Duration) (ILock, error)
+
+	Lease(ctx context.Context, key string, ttl time.Duration) (ILock, error)
+}
+
+/*
+ILock 一次成功的加锁。
+Token 是 fencing token：同一个 key 每次加锁都严格递增，写入受保护资源时带上它，
+资源方拒绝比已见过的更小的 token，即可挡住锁过期后仍在运行的旧持有者。
+Refresh/Release 只对仍是持有者的调用方生效，否则返回 state.ErrLockNotHeld。
+*/
+type ILock interface {
+	Key() string
+
+	Token() uint64
+
+	Refresh(ctx context.Context, ttl time.Duration) error
+
+	Release(ctx context.Context) error
+
+	// Done 在锁被释放或续期失败（锁已丢失）后关闭
+	Done() <-chan struct{}
+}
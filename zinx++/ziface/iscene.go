@@ -0,0 +1,35 @@
+package ziface
+
+import "time"
+
+type IScene interface {
+	GetSceneID() uint64
+
+	Start()
+
+	Stop()
+
+	Post(task func()) error
+
+	Dispatch(request IRequest) error
+
+	AfterFunc(d time.Duration, task func()) (cancel func())
+
+	GetScheduler() IScheduler
+
+	GetAoiManager() IAoiManager
+}
+
+type ISceneManager interface {
+	CreateScene(sceneID uint64) (IScene, error)
+
+	GetScene(sceneID uint64) (IScene, error)
+
+	RemoveScene(sceneID uint64) error
+
+	Forward(sceneID uint64, request IRequest) error
+
+	AddRouter(msgID uint32, router IRouter)
+
+	StopAll()
+}
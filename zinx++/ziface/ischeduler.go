@@ -0,0 +1,17 @@
+package ziface
+
+import "time"
+
+type IScheduler interface {
+	AfterFunc(d time.Duration, task func()) uint64
+
+	Every(interval time.Duration, task func()) uint64
+
+	Cancel(timerID uint64) bool
+
+	OnTick(handler func(tick uint64, dt time.Duration))
+
+	Start()
+
+	Stop()
+}
@@ -0,0 +1,18 @@
+package ziface
+
+/*
+ISerializer 状态对象的编解码器。
+ID 写入存储数据的头部，用于读取时找回编码所用的编解码器，一经使用不可更改；
+Version 随编码格式的不兼容变化递增，读到比自身更新版本写入的数据时拒绝解码。
+*/
+type ISerializer interface {
+	ID() uint8
+
+	Name() string
+
+	Version() uint8
+
+	Marshal(v interface{}) ([]byte, error)
+
+	Unmarshal(data []byte, v interface{}) error
+}
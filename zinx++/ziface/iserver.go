@@ -23,13 +23,27 @@ type IServer interface {
 
 	CallOnConnStop(connection IConnection)
 
+	// AddOnConnStop 追加连接断开钩子，在 SetOnConnStop 设置的钩子之后运行
+	AddOnConnStop(hook func(connection IConnection))
+
+	// AddOnStop 追加服务器停止钩子，在所有连接关闭之后、调度器和工作池停止之前运行
+	AddOnStop(hook func(server IServer))
+
 	GetStateManager() IStateManager
 
 	GetAoiManager() IAoiManager
 
 	GetScriptEngine() IScriptEngine
 
+	GetSessionManager() ISessionManager
+
+	GetScheduler() IScheduler
+
+	GetAdmissionController() IAdmissionController
+
 	ServerName() string
 
 	GetListener() net.Listener
+
+	GetListeners() []net.Listener
 }
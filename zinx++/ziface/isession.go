@@ -0,0 +1,15 @@
+package ziface
+
+type ISessionManager interface {
+	Bind(userID uint64, conn IConnection) error
+
+	Unbind(conn IConnection)
+
+	GetConnByUser(userID uint64) (IConnection, error)
+
+	GetUserByConn(conn IConnection) (uint64, bool)
+
+	SendToUser(userID uint64, msgID uint32, data []byte) error
+
+	Len() int
+}
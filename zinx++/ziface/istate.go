@@ -11,3 +11,69 @@ type IStateManager interface {
 
 	ExistsState(ctx context.Context, key string) (bool, error)
 }
+
+/*
+IExtendedStateManager 在整块读写之外提供批量、哈希字段、原子计数、带版本号的
+CAS 以及流水线批处理，避免为修改一个字段而读-改-写整个对象。
+带版本号的 key 应只通过 GetVersioned/CompareAndSet 访问，GetState 与哈希操作会对它返回
+state.ErrWrongType。
+*/
+type IExtendedStateManager interface {
+	IStateManager
+
+	// MGetState 只返回存在的 key
+	MGetState(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	MSetState(ctx context.Context, values map[string][]byte, expiration int64) error
+
+	ExpireState(ctx context.Context, key string, expiration int64) error
+
+	HGetState(ctx context.Context, key string, field string) ([]byte, error)
+
+	HGetAllState(ctx context.Context, key string) (map[string][]byte, error)
+
+	HSetState(ctx context.Context, key string, fields map[string][]byte) error
+
+	HDelState(ctx context.Context, key string, fields ...string) error
+
+	HIncrBy(ctx context.Context, key string, field string, delta int64) (int64, error)
+
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+
+	// GetVersioned 对不存在的 key 返回 state.ErrStateNotFound
+	GetVersioned(ctx context.Context, key string) (value []byte, version uint64, err error)
+
+	// CompareAndSet 仅当当前版本等于 expectedVersion 时写入（0 表示 key 必须不存在），
+	// 成功返回新版本号，否则返回 state.ErrVersionMismatch
+	CompareAndSet(ctx context.Context, key string, expectedVersion uint64, value []byte, expiration int64) (newVersion uint64, err error)
+
+	Batch() IStateBatch
+
+	// ScanKeys 对每个以 prefix 开头的 key 调用 fn，fn 返回错误时停止遍历；
+	// 遍历期间被修改的 key 可能被漏报或重复报告
+	ScanKeys(ctx context.Context, prefix string, fn func(key string) error) error
+}
+
+/*
+IStateBatch 收集多条写操作，Exec 时一次往返发送。批处理不是事务：
+部分命令失败时其余命令仍可能已生效，Exec 返回第一个错误。
+*/
+type IStateBatch interface {
+	Set(key string, value []byte, expiration int64) IStateBatch
+
+	Delete(key string) IStateBatch
+
+	Expire(key string, expiration int64) IStateBatch
+
+	HSet(key string, fields map[string][]byte) IStateBatch
+
+	HDel(key string, fields ...string) IStateBatch
+
+	HIncrBy(key string, field string, delta int64) IStateBatch
+
+	IncrBy(key string, delta int64) IStateBatch
+
+	Len() int
+
+	Exec(ctx context.Context) error
+}
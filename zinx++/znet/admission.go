@@ -0,0 +1,312 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"zinxplusplus/config"
+)
+
+var (
+	ErrAddrDenied    = errors.New("address denied by admission list")
+	ErrAddrBanned    = errors.New("address temporarily banned")
+	ErrTooManyPerIP  = errors.New("too many connections from address")
+	ErrConnRateLimit = errors.New("connect rate limit exceeded for address")
+)
+
+const admissionIdleTTL = 10 * time.Minute
+
+type ipState struct {
+	conns       int
+	tokens      float64
+	lastRefill  time.Time
+	violations  int
+	authFails   int
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+type AdmissionController struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	maxPerIP int
+	rate     float64
+	burst    float64
+
+	violationsBeforeBan   int
+	authFailuresBeforeBan int
+	banDuration           time.Duration
+
+	perIP map[string]*ipState
+	lock  sync.Mutex
+}
+
+func NewAdmissionController(cfg config.AdmissionConfig) (*AdmissionController, error) {
+	ac := &AdmissionController{
+		perIP: make(map[string]*ipState),
+	}
+	if err := ac.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// Reload replaces lists and limits at runtime. Per-IP counters and active
+// bans are kept.
+func (ac *AdmissionController) Reload(cfg config.AdmissionConfig) error {
+	allow, err := parseCIDRs(cfg.AllowCIDRs)
+	if err != nil {
+		return fmt.Errorf("parse allow list: %w", err)
+	}
+	deny, err := parseCIDRs(cfg.DenyCIDRs)
+	if err != nil {
+		return fmt.Errorf("parse deny list: %w", err)
+	}
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.allow = allow
+	ac.deny = deny
+	ac.setLimitsLocked(cfg.MaxConnPerIP, cfg.ConnRatePerIP, cfg.ConnBurstPerIP)
+	ac.violationsBeforeBan = cfg.ViolationsBeforeBan
+	ac.authFailuresBeforeBan = cfg.AuthFailuresBeforeBan
+	ac.banDuration = time.Duration(cfg.BanDurationMs) * time.Millisecond
+	return nil
+}
+
+func (ac *AdmissionController) SetAllowList(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	ac.lock.Lock()
+	ac.allow = nets
+	ac.lock.Unlock()
+	return nil
+}
+
+func (ac *AdmissionController) SetDenyList(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	ac.lock.Lock()
+	ac.deny = nets
+	ac.lock.Unlock()
+	return nil
+}
+
+func (ac *AdmissionController) SetLimits(maxConnPerIP int, connRatePerIP float64, connBurstPerIP int) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.setLimitsLocked(maxConnPerIP, connRatePerIP, connBurstPerIP)
+}
+
+func (ac *AdmissionController) setLimitsLocked(maxConnPerIP int, connRatePerIP float64, connBurstPerIP int) {
+	ac.maxPerIP = maxConnPerIP
+	ac.rate = connRatePerIP
+	ac.burst = float64(connBurstPerIP)
+	if ac.rate > 0 && ac.burst < 1 {
+		ac.burst = 1
+	}
+}
+
+func (ac *AdmissionController) Admit(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		// Unix sockets and the like carry no IP; nothing to filter on.
+		return nil
+	}
+	now := time.Now()
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if containsIP(ac.deny, ip) || (len(ac.allow) > 0 && !containsIP(ac.allow, ip)) {
+		return fmt.Errorf("%w: %s", ErrAddrDenied, ip)
+	}
+
+	st := ac.stateLocked(ip, now)
+	if now.Before(st.bannedUntil) {
+		return fmt.Errorf("%w: %s until %s", ErrAddrBanned, ip, st.bannedUntil.Format(time.RFC3339))
+	}
+	if ac.maxPerIP > 0 && st.conns >= ac.maxPerIP {
+		return fmt.Errorf("%w: %s has %d", ErrTooManyPerIP, ip, st.conns)
+	}
+	if ac.rate > 0 {
+		st.tokens += now.Sub(st.lastRefill).Seconds() * ac.rate
+		if st.tokens > ac.burst {
+			st.tokens = ac.burst
+		}
+		st.lastRefill = now
+		if st.tokens < 1 {
+			return fmt.Errorf("%w: %s", ErrConnRateLimit, ip)
+		}
+		st.tokens--
+	}
+
+	st.conns++
+	return nil
+}
+
+func (ac *AdmissionController) Release(addr net.Addr) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return
+	}
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if st, ok := ac.perIP[ip.String()]; ok && st.conns > 0 {
+		st.conns--
+		st.lastSeen = time.Now()
+	}
+}
+
+func (ac *AdmissionController) ReportViolation(addr net.Addr, reason string) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return
+	}
+	now := time.Now()
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	st := ac.stateLocked(ip, now)
+	st.violations++
+	fmt.Printf("[Admission] Protocol violation from %s (%d): %s\n", ip, st.violations, reason)
+
+	if ac.violationsBeforeBan > 0 && st.violations >= ac.violationsBeforeBan && ac.banDuration > 0 {
+		st.bannedUntil = now.Add(ac.banDuration)
+		st.violations = 0
+		fmt.Printf("[Admission] Banned %s for %v\n", ip, ac.banDuration)
+	}
+}
+
+// ReportAuthFailure counts a rejected login separately from protocol
+// violations: many players can share one NAT address, so failed logins only
+// lead to a ban when AuthFailuresBeforeBan is set.
+func (ac *AdmissionController) ReportAuthFailure(addr net.Addr, reason string) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return
+	}
+	now := time.Now()
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if ac.authFailuresBeforeBan <= 0 {
+		return
+	}
+
+	st := ac.stateLocked(ip, now)
+	st.authFails++
+	fmt.Printf("[Admission] Auth failure from %s (%d): %s\n", ip, st.authFails, reason)
+
+	if st.authFails >= ac.authFailuresBeforeBan && ac.banDuration > 0 {
+		st.bannedUntil = now.Add(ac.banDuration)
+		st.authFails = 0
+		fmt.Printf("[Admission] Banned %s for %v\n", ip, ac.banDuration)
+	}
+}
+
+func (ac *AdmissionController) Ban(ip net.IP, d time.Duration) {
+	now := time.Now()
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.stateLocked(ip, now).bannedUntil = now.Add(d)
+}
+
+func (ac *AdmissionController) Unban(ip net.IP) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if st, ok := ac.perIP[ip.String()]; ok {
+		st.bannedUntil = time.Time{}
+		st.violations = 0
+		st.authFails = 0
+	}
+}
+
+// Sweep forgets addresses with no live connections, no active ban and no
+// activity within admissionIdleTTL.
+func (ac *AdmissionController) Sweep() {
+	now := time.Now()
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	for key, st := range ac.perIP {
+		if st.conns == 0 && now.After(st.bannedUntil) && now.Sub(st.lastSeen) > admissionIdleTTL {
+			delete(ac.perIP, key)
+		}
+	}
+}
+
+func (ac *AdmissionController) stateLocked(ip net.IP, now time.Time) *ipState {
+	key := ip.String()
+	st, ok := ac.perIP[key]
+	if !ok {
+		st = &ipState{tokens: ac.burst, lastRefill: now}
+		ac.perIP[key] = st
+	}
+	st.lastSeen = now
+	return st
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
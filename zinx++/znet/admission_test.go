@@ -0,0 +1,276 @@
+package znet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"zinxplusplus/config"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func newAdmission(t *testing.T, cfg config.AdmissionConfig) *AdmissionController {
+	t.Helper()
+	ac, err := NewAdmissionController(cfg)
+	if err != nil {
+		t.Fatalf("NewAdmissionController: %v", err)
+	}
+	return ac
+}
+
+// age moves the recorded activity of ip back by d, as if d had passed.
+func age(ac *AdmissionController, ip string, d time.Duration) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	st := ac.perIP[net.ParseIP(ip).String()]
+	st.lastRefill = st.lastRefill.Add(-d)
+	st.lastSeen = st.lastSeen.Add(-d)
+	if !st.bannedUntil.IsZero() {
+		st.bannedUntil = st.bannedUntil.Add(-d)
+	}
+}
+
+func TestAdmissionLists(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		allow []string
+		deny  []string
+		addr  net.Addr
+		want  error
+	}{
+		{name: "no lists", addr: tcpAddr("203.0.113.1")},
+		{name: "v4 denied", deny: []string{"203.0.113.0/24"}, addr: tcpAddr("203.0.113.1"), want: ErrAddrDenied},
+		{name: "v4 outside deny", deny: []string{"203.0.113.0/24"}, addr: tcpAddr("198.51.100.1")},
+		{name: "bare v4 denied", deny: []string{"203.0.113.1"}, addr: tcpAddr("203.0.113.1"), want: ErrAddrDenied},
+		{name: "bare v4 neighbour", deny: []string{"203.0.113.1"}, addr: tcpAddr("203.0.113.2")},
+		{name: "v6 denied", deny: []string{"2001:db8::/32"}, addr: tcpAddr("2001:db8::1"), want: ErrAddrDenied},
+		{name: "bare v6 denied", deny: []string{"2001:db8::1"}, addr: tcpAddr("2001:db8::1"), want: ErrAddrDenied},
+		{name: "v4-mapped v6 peer", deny: []string{"203.0.113.0/24"}, addr: tcpAddr("::ffff:203.0.113.9"), want: ErrAddrDenied},
+		{name: "v4 allowed", allow: []string{"10.0.0.0/8"}, addr: tcpAddr("10.1.2.3")},
+		{name: "v4 not allowed", allow: []string{"10.0.0.0/8"}, addr: tcpAddr("11.1.2.3"), want: ErrAddrDenied},
+		{name: "v6 allowed", allow: []string{"fd00::/8"}, addr: tcpAddr("fd12::1")},
+		{name: "v6 not in v4 allow list", allow: []string{"10.0.0.0/8"}, addr: tcpAddr("fd12::1"), want: ErrAddrDenied},
+		{name: "deny beats allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.9.0.0/16"}, addr: tcpAddr("10.9.1.1"), want: ErrAddrDenied},
+		{name: "unix socket", allow: []string{"10.0.0.0/8"}, addr: &net.UnixAddr{Name: "/tmp/zinx.sock", Net: "unix"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ac := newAdmission(t, config.AdmissionConfig{AllowCIDRs: tc.allow, DenyCIDRs: tc.deny})
+			if err := ac.Admit(tc.addr); !errors.Is(err, tc.want) {
+				t.Fatalf("Admit(%s) = %v, want %v", tc.addr, err, tc.want)
+			}
+		})
+	}
+
+	if _, err := NewAdmissionController(config.AdmissionConfig{DenyCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("accepted an invalid CIDR")
+	}
+}
+
+func TestAdmissionPerIPCap(t *testing.T) {
+	ac := newAdmission(t, config.AdmissionConfig{MaxConnPerIP: 2})
+	a, b := tcpAddr("203.0.113.1"), tcpAddr("2001:db8::1")
+
+	for i := 0; i < 2; i++ {
+		if err := ac.Admit(a); err != nil {
+			t.Fatalf("Admit %d: %v", i, err)
+		}
+	}
+	if err := ac.Admit(a); !errors.Is(err, ErrTooManyPerIP) {
+		t.Fatalf("Admit over the cap = %v", err)
+	}
+	if err := ac.Admit(b); err != nil {
+		t.Fatalf("other address hit the cap: %v", err)
+	}
+
+	ac.Release(a)
+	if err := ac.Admit(a); err != nil {
+		t.Fatalf("Admit after Release: %v", err)
+	}
+
+	// Extra releases never push the count below zero.
+	for i := 0; i < 5; i++ {
+		ac.Release(a)
+	}
+	ac.Release(tcpAddr("198.51.100.1"))
+	for i := 0; i < 2; i++ {
+		if err := ac.Admit(a); err != nil {
+			t.Fatalf("Admit %d after releasing all: %v", i, err)
+		}
+	}
+	if err := ac.Admit(a); !errors.Is(err, ErrTooManyPerIP) {
+		t.Fatalf("cap not enforced after extra releases: %v", err)
+	}
+}
+
+func TestAdmissionTokenBucket(t *testing.T) {
+	ac := newAdmission(t, config.AdmissionConfig{ConnRatePerIP: 10, ConnBurstPerIP: 2})
+	const ip = "203.0.113.1"
+	admit := func() error {
+		err := ac.Admit(tcpAddr(ip))
+		if err == nil {
+			ac.Release(tcpAddr(ip))
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := admit(); err != nil {
+			t.Fatalf("burst connect %d: %v", i, err)
+		}
+	}
+	if err := admit(); !errors.Is(err, ErrConnRateLimit) {
+		t.Fatalf("connect past the burst = %v", err)
+	}
+
+	// 10/s refills one token in 100ms.
+	age(ac, ip, 100*time.Millisecond)
+	if err := admit(); err != nil {
+		t.Fatalf("connect after refill: %v", err)
+	}
+	if err := admit(); !errors.Is(err, ErrConnRateLimit) {
+		t.Fatalf("second connect after one token = %v", err)
+	}
+
+	// A long pause refills no more than the burst.
+	age(ac, ip, time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := admit(); err != nil {
+			t.Fatalf("connect %d after a pause: %v", i, err)
+		}
+	}
+	if err := admit(); !errors.Is(err, ErrConnRateLimit) {
+		t.Fatalf("bucket grew past the burst: %v", err)
+	}
+
+	// A rate without a burst still lets one connection through.
+	single := newAdmission(t, config.AdmissionConfig{ConnRatePerIP: 1})
+	if err := single.Admit(tcpAddr(ip)); err != nil {
+		t.Fatalf("rate without burst: %v", err)
+	}
+}
+
+func TestAdmissionBanExpiry(t *testing.T) {
+	ac := newAdmission(t, config.AdmissionConfig{
+		ViolationsBeforeBan:   2,
+		AuthFailuresBeforeBan: 3,
+		BanDurationMs:         60000,
+	})
+	const ip = "2001:db8::7"
+	addr := tcpAddr(ip)
+
+	ac.ReportViolation(addr, "bad frame")
+	if err := ac.Admit(addr); err != nil {
+		t.Fatalf("banned after one violation: %v", err)
+	}
+	ac.Release(addr)
+	ac.ReportViolation(addr, "bad frame")
+	if err := ac.Admit(addr); !errors.Is(err, ErrAddrBanned) {
+		t.Fatalf("Admit after two violations = %v", err)
+	}
+	if err := ac.Admit(tcpAddr("2001:db8::8")); err != nil {
+		t.Fatalf("ban spilled to a neighbour: %v", err)
+	}
+
+	age(ac, ip, time.Minute+time.Second)
+	if err := ac.Admit(addr); err != nil {
+		t.Fatalf("Admit after the ban expired: %v", err)
+	}
+	ac.Release(addr)
+
+	// The count restarted with the ban, and auth failures count separately.
+	ac.ReportViolation(addr, "bad frame")
+	ac.ReportAuthFailure(addr, "wrong password")
+	ac.ReportAuthFailure(addr, "wrong password")
+	if err := ac.Admit(addr); err != nil {
+		t.Fatalf("banned before either threshold: %v", err)
+	}
+	ac.Release(addr)
+	ac.ReportAuthFailure(addr, "wrong password")
+	if err := ac.Admit(addr); !errors.Is(err, ErrAddrBanned) {
+		t.Fatalf("Admit after three auth failures = %v", err)
+	}
+
+	ac.Unban(net.ParseIP(ip))
+	if err := ac.Admit(addr); err != nil {
+		t.Fatalf("Admit after Unban: %v", err)
+	}
+}
+
+func TestAdmissionReloadKeepsConnections(t *testing.T) {
+	ac := newAdmission(t, config.AdmissionConfig{MaxConnPerIP: 2, ViolationsBeforeBan: 1, BanDurationMs: 60000})
+	a, b, c := tcpAddr("203.0.113.1"), tcpAddr("198.51.100.1"), tcpAddr("192.0.2.1")
+	for _, addr := range []net.Addr{a, a, b} {
+		if err := ac.Admit(addr); err != nil {
+			t.Fatalf("Admit %s: %v", addr, err)
+		}
+	}
+	ac.ReportViolation(c, "bad frame")
+
+	if err := ac.Reload(config.AdmissionConfig{
+		DenyCIDRs:    []string{"198.51.100.0/24"},
+		MaxConnPerIP: 2,
+	}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// Connections held across the reload still count against the cap.
+	if err := ac.Admit(a); !errors.Is(err, ErrTooManyPerIP) {
+		t.Fatalf("Admit over the cap after Reload = %v", err)
+	}
+	// The new deny list applies to new connections only; releasing one
+	// admitted before the reload still frees its slot.
+	if err := ac.Admit(b); !errors.Is(err, ErrAddrDenied) {
+		t.Fatalf("Admit from the newly denied range = %v", err)
+	}
+	ac.Release(b)
+	ac.Release(a)
+	if err := ac.Admit(a); err != nil {
+		t.Fatalf("Admit after Release: %v", err)
+	}
+	// Bans survive a reload.
+	if err := ac.Admit(c); !errors.Is(err, ErrAddrBanned) {
+		t.Fatalf("ban lost on Reload: %v", err)
+	}
+
+	// A bad list leaves the old one in place.
+	if err := ac.Reload(config.AdmissionConfig{DenyCIDRs: []string{"nonsense"}}); err == nil {
+		t.Fatal("Reload accepted an invalid CIDR")
+	}
+	if err := ac.Admit(b); !errors.Is(err, ErrAddrDenied) {
+		t.Fatalf("deny list after a failed Reload = %v", err)
+	}
+}
+
+func TestAdmissionSweep(t *testing.T) {
+	ac := newAdmission(t, config.AdmissionConfig{ViolationsBeforeBan: 1, BanDurationMs: int(2 * admissionIdleTTL / time.Millisecond)})
+	idle, held, banned, recent := tcpAddr("203.0.113.1"), tcpAddr("203.0.113.2"), tcpAddr("203.0.113.3"), tcpAddr("203.0.113.4")
+
+	_ = ac.Admit(idle)
+	ac.Release(idle)
+	_ = ac.Admit(held)
+	ac.ReportViolation(banned, "bad frame")
+	_ = ac.Admit(recent)
+	ac.Release(recent)
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+		age(ac, ip, admissionIdleTTL+time.Second)
+	}
+
+	ac.Sweep()
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	for ip, want := range map[string]bool{
+		"203.0.113.1": false,
+		"203.0.113.2": true,
+		"203.0.113.3": true,
+		"203.0.113.4": true,
+	} {
+		if _, ok := ac.perIP[ip]; ok != want {
+			t.Errorf("%s tracked after Sweep = %v, want %v", ip, ok, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+const (
+	AuthReasonOK              uint32 = 0
+	AuthReasonRejected        uint32 = 1
+	AuthReasonTimeout         uint32 = 2
+	AuthReasonSessionConflict uint32 = 3
+	AuthReasonInternal        uint32 = 4
+
+	MsgIDAuthResult uint32 = 0xFFFFFF03
+)
+
+var ErrNotAuthenticated = errors.New("connection is not authenticated")
+
+// AuthError lets an authenticator choose the reason code sent to the client.
+type AuthError struct {
+	Code   uint32
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth failed (code=%d): %s", e.Code, e.Reason)
+}
+
+type authGate struct {
+	server        *Server
+	authenticator ziface.IAuthenticator
+	authMsgIDs    map[uint32]struct{}
+	preAuthMsgIDs map[uint32]struct{}
+	timeout       time.Duration
+	resultMsgID   uint32
+}
+
+func newAuthGate(s *Server, opts *ServerOptions) *authGate {
+	if opts.Authenticator == nil {
+		return nil
+	}
+	g := &authGate{
+		server:        s,
+		authenticator: opts.Authenticator,
+		authMsgIDs:    make(map[uint32]struct{}, len(opts.AuthMsgIDs)),
+		preAuthMsgIDs: make(map[uint32]struct{}, len(opts.PreAuthMsgIDs)),
+		timeout:       time.Duration(opts.AuthTimeoutMs) * time.Millisecond,
+		resultMsgID:   opts.AuthResultMsgID,
+	}
+	if g.resultMsgID == 0 {
+		g.resultMsgID = MsgIDAuthResult
+	}
+	for _, id := range opts.AuthMsgIDs {
+		g.authMsgIDs[id] = struct{}{}
+	}
+	for _, id := range opts.PreAuthMsgIDs {
+		g.preAuthMsgIDs[id] = struct{}{}
+	}
+	// Resuming a parked session re-establishes an already authenticated identity.
+	if opts.SessionResumeGraceMs > 0 {
+		g.preAuthMsgIDs[MsgIDSessionResume] = struct{}{}
+	}
+	return g
+}
+
+func (g *authGate) allows(c *Connection, msgID uint32) bool {
+	if c.IsAuthenticated() {
+		return true
+	}
+	if _, ok := g.authMsgIDs[msgID]; ok {
+		return true
+	}
+	_, ok := g.preAuthMsgIDs[msgID]
+	return ok
+}
+
+func (g *authGate) armDeadline(c *Connection) {
+	if g.timeout <= 0 {
+		return
+	}
+	timerID := g.server.scheduler.AfterFunc(g.timeout, func() {
+		if !c.IsAuthenticated() && !c.IsClosed() {
+			g.fail(c, &AuthError{Code: AuthReasonTimeout, Reason: "handshake deadline exceeded"})
+		}
+	})
+	c.authTimerID.Store(timerID)
+}
+
+func (g *authGate) cancelDeadline(c *Connection) {
+	if id := c.authTimerID.Swap(0); id != 0 {
+		g.server.scheduler.Cancel(id)
+	}
+}
+
+func (g *authGate) succeed(c *Connection, identity interface{}) {
+	if userID, ok := identity.(uint64); ok {
+		if err := g.server.sessionMgr.Bind(userID, c); err != nil {
+			g.fail(c, &AuthError{Code: AuthReasonSessionConflict, Reason: err.Error()})
+			return
+		}
+	}
+
+	c.markAuthenticated(identity)
+	g.sendResult(c, AuthReasonOK)
+	fmt.Printf("[Auth] ConnID = %d authenticated as %v\n", c.connID, identity)
+}
+
+func (g *authGate) fail(c *Connection, err error) {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		authErr = &AuthError{Code: AuthReasonRejected, Reason: err.Error()}
+	}
+	g.cancelDeadline(c)
+
+	fmt.Printf("[Auth] ConnID = %d %v, closing.\n", c.connID, authErr)
+	if authErr.Code == AuthReasonRejected {
+		c.reportAuthFailure(authErr.Error())
+	}
+	g.sendResult(c, authErr.Code)
+
+	// Let the writer flush the result before the socket goes away.
+	time.AfterFunc(sessionKickDelay, c.Stop)
+}
+
+func (g *authGate) sendResult(c *Connection, code uint32) {
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, code)
+	if err := c.sendUntrackedMsg(g.resultMsgID, payload); err != nil {
+		fmt.Printf("[Auth] Send auth result to ConnID = %d error: %v\n", c.connID, err)
+	}
+}
+
+// authRouter runs the authenticator on the worker pool like any other
+// handler, so slow credential checks never block the netpoll reader.
+type authRouter struct {
+	BaseRouter
+	gate *authGate
+}
+
+func (r *authRouter) Handle(request ziface.IRequest) {
+	c, ok := request.GetConnection().(*Connection)
+	if !ok || c.IsAuthenticated() || c.IsClosed() {
+		return
+	}
+
+	identity, err := r.gate.authenticator.Authenticate(request)
+	switch {
+	case err != nil:
+		r.gate.fail(c, err)
+	case identity != nil:
+		r.gate.succeed(c, identity)
+	}
+}
@@ -0,0 +1,121 @@
+package znet_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"zinxplusplus/config"
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+const (
+	msgLogin uint32 = 1
+	msgEcho  uint32 = 10
+)
+
+// passwordAuth accepts "secret" as user 5.
+type passwordAuth struct{}
+
+func (passwordAuth) Authenticate(request ziface.IRequest) (interface{}, error) {
+	if string(request.GetData()) == "secret" {
+		return uint64(5), nil
+	}
+	return nil, errors.New("wrong password")
+}
+
+func authCode(t *testing.T, data []byte) uint32 {
+	t.Helper()
+	if len(data) != 4 {
+		t.Fatalf("auth result payload %v", data)
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+func startAuthServer(t *testing.T, timeoutMs int) string {
+	srv, addr := startServer(t, znet.WithAuthenticator(passwordAuth{}, []uint32{msgLogin}, timeoutMs))
+	srv.AddRouter(msgEcho, &echoRouter{})
+	return addr
+}
+
+func TestAuthGateDropsRequestsBeforeLogin(t *testing.T) {
+	c := connect(t, "tcp", startAuthServer(t, 0), znet.MsgIDAuthResult, msgEcho+1)
+
+	c.send(msgEcho, []byte("early"))
+	if msgID, _, ok := c.recv(200 * time.Millisecond); ok {
+		t.Fatalf("unauthenticated request was handled, got reply %d", msgID)
+	}
+
+	c.send(msgLogin, []byte("secret"))
+	if code := authCode(t, c.expect(znet.MsgIDAuthResult, time.Second)); code != znet.AuthReasonOK {
+		t.Fatalf("auth result = %d", code)
+	}
+	c.send(msgEcho, []byte("late"))
+	if got := c.expect(msgEcho+1, time.Second); string(got) != "late" {
+		t.Fatalf("echo = %q", got)
+	}
+}
+
+func TestAuthGateRejectsAndCloses(t *testing.T) {
+	c := connect(t, "tcp", startAuthServer(t, 0), znet.MsgIDAuthResult, msgEcho+1)
+
+	c.send(msgLogin, []byte("guess"))
+	if code := authCode(t, c.expect(znet.MsgIDAuthResult, time.Second)); code != znet.AuthReasonRejected {
+		t.Fatalf("auth result = %d, want rejected", code)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Fatal("connection still open after failed auth")
+	}
+}
+
+func TestAuthGateTimeout(t *testing.T) {
+	c := connect(t, "tcp", startAuthServer(t, 100), znet.MsgIDAuthResult)
+
+	if code := authCode(t, c.expect(znet.MsgIDAuthResult, 2*time.Second)); code != znet.AuthReasonTimeout {
+		t.Fatalf("auth result = %d, want timeout", code)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Fatal("connection still open after auth timeout")
+	}
+}
+
+func failLogin(t *testing.T, addr string) {
+	t.Helper()
+	c := connect(t, "tcp", addr, znet.MsgIDAuthResult)
+	c.send(msgLogin, []byte("guess"))
+	if code := authCode(t, c.expect(znet.MsgIDAuthResult, time.Second)); code != znet.AuthReasonRejected {
+		t.Fatalf("auth result = %d, want rejected", code)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Fatal("connection still open after failed auth")
+	}
+}
+
+func TestAuthFailuresDoNotBanByDefault(t *testing.T) {
+	addr := startAuthServer(t, 0)
+	for i := 0; i < 4; i++ {
+		failLogin(t, addr)
+	}
+
+	c := connect(t, "tcp", addr, znet.MsgIDAuthResult)
+	c.send(msgLogin, []byte("secret"))
+	if code := authCode(t, c.expect(znet.MsgIDAuthResult, time.Second)); code != znet.AuthReasonOK {
+		t.Fatalf("auth result = %d after failed logins from the same address", code)
+	}
+}
+
+func TestAuthFailuresBeforeBan(t *testing.T) {
+	_, addr := startServer(t,
+		znet.WithAuthenticator(passwordAuth{}, []uint32{msgLogin}, 0),
+		znet.WithAdmission(config.AdmissionConfig{AuthFailuresBeforeBan: 2, BanDurationMs: 60000}),
+	)
+	failLogin(t, addr)
+	failLogin(t, addr)
+
+	c := connect(t, "tcp", addr, znet.MsgIDAuthResult)
+	if !c.closed(2 * time.Second) {
+		t.Fatal("banned address was admitted")
+	}
+}
@@ -0,0 +1,255 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+var (
+	ErrClientNotConnected = errors.New("client is not connected")
+	ErrClientStarted      = errors.New("client already dialed")
+	ErrClientStopped      = errors.New("client is stopped")
+)
+
+// Client dials a Zinx++ server and keeps the link up. Incoming messages are
+// routed by msgID to IRouter handlers on the connection's reader goroutine, so
+// handlers of one connection run in order.
+type Client struct {
+	network string
+	address string
+	opts    *ClientOptions
+
+	routers *routerSet
+	conn    atomic.Pointer[ClientConnection]
+
+	onConnStart func(ziface.IConnection)
+	onConnStop  func(ziface.IConnection)
+
+	nextConnID uint64
+	started    atomic.Bool
+
+	exit chan struct{}
+	stop sync.Once
+	// done is closed when the run started by the latest Dial returns.
+	done     chan struct{}
+	doneLock sync.Mutex
+}
+
+func NewClient(network, address string, opts ...ClientOption) ziface.IClient {
+	clientOpts := newClientOptions(opts...)
+	return &Client{
+		network:     network,
+		address:     address,
+		opts:        clientOpts,
+		routers:     &routerSet{apis: make(map[uint32]ziface.IRouter)},
+		onConnStart: clientOpts.OnConnStart,
+		onConnStop:  clientOpts.OnConnStop,
+		exit:        make(chan struct{}),
+	}
+}
+
+// Dial connects, retrying with backoff until ctx is done, and then keeps the
+// connection alive in the background until Stop. Once the link is lost for
+// good, because reconnecting is disabled or gave up, Dial may be called again.
+func (cl *Client) Dial(ctx context.Context) error {
+	if !cl.started.CompareAndSwap(false, true) {
+		return ErrClientStarted
+	}
+
+	conn, err := cl.dialLoop(ctx)
+	if err != nil {
+		cl.started.Store(false)
+		return err
+	}
+	done := make(chan struct{})
+	cl.doneLock.Lock()
+	cl.done = done
+	cl.doneLock.Unlock()
+	go cl.run(conn, done)
+	return nil
+}
+
+func (cl *Client) Stop() {
+	cl.stop.Do(func() {
+		close(cl.exit)
+	})
+	if conn := cl.conn.Load(); conn != nil {
+		conn.Stop()
+	}
+	cl.doneLock.Lock()
+	done := cl.done
+	cl.doneLock.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (cl *Client) AddRouter(msgId uint32, router ziface.IRouter) {
+	cl.routers.add(msgId, router)
+	fmt.Printf("[Client] Add Router success! msgID = %d\n", msgId)
+}
+
+func (cl *Client) Conn() ziface.IConnection {
+	if conn := cl.conn.Load(); conn != nil {
+		return conn
+	}
+	return nil
+}
+
+func (cl *Client) IsConnected() bool {
+	conn := cl.conn.Load()
+	return conn != nil && !conn.IsClosed()
+}
+
+func (cl *Client) SendMsg(msgId uint32, data []byte) error {
+	conn := cl.conn.Load()
+	if conn == nil {
+		return ErrClientNotConnected
+	}
+	return conn.SendMsg(msgId, data)
+}
+
+func (cl *Client) SendBuffMsg(msgId uint32, data []byte) error {
+	conn := cl.conn.Load()
+	if conn == nil {
+		return ErrClientNotConnected
+	}
+	return conn.SendBuffMsg(msgId, data)
+}
+
+func (cl *Client) SetOnConnStart(hook func(ziface.IConnection)) {
+	cl.onConnStart = hook
+}
+
+func (cl *Client) SetOnConnStop(hook func(ziface.IConnection)) {
+	cl.onConnStop = hook
+}
+
+func (cl *Client) run(conn *ClientConnection, done chan struct{}) {
+	defer func() {
+		cl.started.Store(false)
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-conn.exitChan:
+		case <-cl.exit:
+			conn.Stop()
+			return
+		}
+		cl.conn.CompareAndSwap(conn, nil)
+
+		if !cl.opts.Reconnect {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-cl.exit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		next, err := cl.dialLoop(ctx)
+		cancel()
+		if err != nil {
+			return
+		}
+		conn = next
+	}
+}
+
+func (cl *Client) dialLoop(ctx context.Context) (*ClientConnection, error) {
+	delay := cl.opts.ReconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-cl.exit:
+			return nil, ErrClientStopped
+		default:
+		}
+
+		conn, err := cl.dialOnce(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		fmt.Printf("[Client] Dial %s %s attempt %d failed: %v\n", cl.network, cl.address, attempt, err)
+		if !cl.opts.Reconnect {
+			return nil, err
+		}
+
+		// Full jitter keeps a fleet of bots from reconnecting in lockstep.
+		wait := time.Duration(rand.Int63n(int64(delay)) + 1)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("dial %s: %w (last error: %v)", cl.address, ctx.Err(), err)
+		case <-cl.exit:
+			return nil, ErrClientStopped
+		}
+		delay *= 2
+		if delay > cl.opts.ReconnectMaxDelay {
+			delay = cl.opts.ReconnectMaxDelay
+		}
+	}
+}
+
+func (cl *Client) dialOnce(ctx context.Context) (*ClientConnection, error) {
+	dialer := net.Dialer{Timeout: cl.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, cl.network, cl.address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newClientConnection(cl, netConn, atomic.AddUint64(&cl.nextConnID, 1))
+	cl.conn.Store(conn)
+	conn.Start()
+	return conn, nil
+}
+
+func (cl *Client) callOnConnStart(conn ziface.IConnection) {
+	if cl.onConnStart == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Client] OnConnStart panic: %v\n", err)
+		}
+	}()
+	cl.onConnStart(conn)
+}
+
+func (cl *Client) callOnConnStop(conn ziface.IConnection) {
+	if cl.onConnStop == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Client] OnConnStop panic: %v\n", err)
+		}
+	}()
+	cl.onConnStop(conn)
+}
+
+func (cl *Client) doMsgHandler(request ziface.IRequest) {
+	router, ok := cl.routers.get(request.GetMsgID())
+	if !ok {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("[Client] Handler panic: MsgID=%d, Error=%v\n", request.GetMsgID(), err)
+		}
+	}()
+	router.PreHandle(request)
+	router.Handle(request)
+	router.PostHandle(request)
+}
@@ -0,0 +1,369 @@
+package znet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/ziface"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// ClientConnection is the client side of one dialed link. It implements
+// IConnection so routers written for the server can be reused by bots.
+type ClientConnection struct {
+	client *Client
+	conn   net.Conn
+	connID uint64
+
+	isClosed  bool
+	closeLock sync.RWMutex
+	exitChan  chan struct{}
+
+	outQueue *outQueue
+
+	property     map[string]interface{}
+	propertyLock sync.RWMutex
+
+	authenticated atomic.Bool
+	lastRecv      atomic.Int64
+
+	recorder atomic.Pointer[PacketRecorder]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeCallback func(connection ziface.IConnection) error
+	callbackLock  sync.Mutex
+}
+
+func newClientConnection(client *Client, conn net.Conn, connID uint64) *ClientConnection {
+	c := &ClientConnection{
+		client:   client,
+		conn:     conn,
+		connID:   connID,
+		exitChan: make(chan struct{}),
+		outQueue: newOutQueue(client.opts.MaxOutQueueBytes, SlowConsumerDropLowest),
+		property: make(map[string]interface{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.lastRecv.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *ClientConnection) Start() {
+	fmt.Printf("[Client] ConnID = %d connected to %s\n", c.connID, c.conn.RemoteAddr())
+
+	go c.startReader()
+	go c.startWriter()
+	if c.client.opts.HeartbeatInterval > 0 {
+		go c.startHeartbeat()
+	}
+
+	c.client.callOnConnStart(c)
+}
+
+func (c *ClientConnection) Stop() {
+	c.closeLock.Lock()
+	if c.isClosed {
+		c.closeLock.Unlock()
+		return
+	}
+	c.isClosed = true
+	close(c.exitChan)
+	c.closeLock.Unlock()
+
+	if err := c.conn.Close(); err != nil {
+		fmt.Printf("[Client] Close ConnID = %d error: %v\n", c.connID, err)
+	}
+
+	c.client.callOnConnStop(c)
+
+	c.callbackLock.Lock()
+	callback := c.closeCallback
+	c.callbackLock.Unlock()
+	if callback != nil {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Printf("[Client] CloseCallback panic: %v\n", err)
+				}
+			}()
+			if err := callback(c); err != nil {
+				fmt.Printf("[Client] CloseCallback error: %v\n", err)
+			}
+		}()
+	}
+
+	if err := c.StopRecording(); err != nil && !errors.Is(err, ErrNotRecording) {
+		fmt.Printf("[Client] Close recording ConnID = %d error: %v\n", c.connID, err)
+	}
+
+	c.cancel()
+	fmt.Printf("[Client] ConnID = %d stopped.\n", c.connID)
+}
+
+// GetConnection returns nil: client links are plain net.Conn, not netpoll.
+func (c *ClientConnection) GetConnection() netpoll.Connection {
+	return nil
+}
+
+func (c *ClientConnection) GetConnID() uint64 {
+	return c.connID
+}
+
+func (c *ClientConnection) GetWorkerID() uint32 {
+	return 0
+}
+
+func (c *ClientConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *ClientConnection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *ClientConnection) SendMsg(msgId uint32, data []byte) error {
+	return c.send(&outboundMsg{msgID: msgId, priority: PriorityGameplay}, data, c.client.opts.SendMsgWait)
+}
+
+func (c *ClientConnection) SendBuffMsg(msgId uint32, data []byte) error {
+	return c.send(&outboundMsg{msgID: msgId, priority: PriorityGameplay}, data, 0)
+}
+
+func (c *ClientConnection) SendPriorityMsg(priority uint8, msgId uint32, data []byte) error {
+	if priority >= numPriorities {
+		return fmt.Errorf("invalid send priority %d, msgId=%d", priority, msgId)
+	}
+	return c.send(&outboundMsg{msgID: msgId, priority: priority}, data, 0)
+}
+
+func (c *ClientConnection) SendCoalescedMsg(priority uint8, coalesceKey uint64, msgId uint32, data []byte) error {
+	if priority >= numPriorities {
+		return fmt.Errorf("invalid send priority %d, msgId=%d", priority, msgId)
+	}
+	return c.send(&outboundMsg{msgID: msgId, priority: priority, coalesceKey: coalesceKey}, data, 0)
+}
+
+func (c *ClientConnection) GetSendStats() ziface.ConnSendStats {
+	return c.outQueue.snapshot()
+}
+
+func (c *ClientConnection) send(out *outboundMsg, data []byte, wait time.Duration) error {
+	if c.IsClosed() {
+		return errors.New("connection closed when send msg")
+	}
+
+	msg, err := c.client.opts.DataPack.Pack(NewMsgPackage(out.msgID, data))
+	if err != nil {
+		return fmt.Errorf("pack error msg id = %d: %w", out.msgID, err)
+	}
+	out.data = msg
+
+	_, err = c.outQueue.push(out, wait, c.exitChan)
+	return err
+}
+
+func (c *ClientConnection) SetProperty(key string, value interface{}) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	c.property[key] = value
+}
+
+func (c *ClientConnection) GetProperty(key string) (interface{}, error) {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	if value, ok := c.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("no property found")
+}
+
+func (c *ClientConnection) RemoveProperty(key string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	delete(c.property, key)
+}
+
+func (c *ClientConnection) Context() context.Context {
+	return c.ctx
+}
+
+func (c *ClientConnection) SetReadTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return c.conn.SetReadDeadline(time.Time{})
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// SetIdleTimeout is not supported on client links; WithHeartbeat covers idle
+// detection.
+func (c *ClientConnection) SetIdleTimeout(timeout time.Duration) error {
+	return errors.New("client connections use WithHeartbeat for idle detection")
+}
+
+func (c *ClientConnection) SetCloseCallback(callback func(connection ziface.IConnection) error) {
+	c.callbackLock.Lock()
+	defer c.callbackLock.Unlock()
+	c.closeCallback = callback
+}
+
+func (c *ClientConnection) IsClosed() bool {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	return c.isClosed
+}
+
+// IsAuthenticated reports whether the server sent an OK auth result on this
+// link.
+func (c *ClientConnection) IsAuthenticated() bool {
+	return c.authenticated.Load()
+}
+
+func (c *ClientConnection) GetIdentity() interface{} {
+	return nil
+}
+
+func (c *ClientConnection) StartRecording(path string) error {
+	rec, err := CreatePacketRecorder(path, c.connID, c.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	if !c.recorder.CompareAndSwap(nil, rec) {
+		rec.Close()
+		return fmt.Errorf("%w: ConnID=%d", ErrAlreadyRecording, c.connID)
+	}
+	return nil
+}
+
+func (c *ClientConnection) StopRecording() error {
+	rec := c.recorder.Swap(nil)
+	if rec == nil {
+		return ErrNotRecording
+	}
+	return rec.Close()
+}
+
+func (c *ClientConnection) startReader() {
+	defer c.Stop()
+
+	reader := netpoll.NewReader(c.conn)
+	dataPack := c.client.opts.DataPack
+	for {
+		msg, err := dataPack.Unpack(reader)
+		if err != nil {
+			if !c.IsClosed() {
+				fmt.Printf("[Client] Unpack error for ConnID = %d: %v\n", c.connID, err)
+			}
+			return
+		}
+
+		var data []byte
+		if msg.GetDataLen() > 0 {
+			body, err := reader.Next(int(msg.GetDataLen()))
+			if err != nil {
+				if !c.IsClosed() {
+					fmt.Printf("[Client] Read body error for ConnID = %d, msgID = %d: %v\n", c.connID, msg.GetMsgID(), err)
+				}
+				return
+			}
+			data = make([]byte, len(body))
+			copy(data, body)
+			_ = reader.Release()
+		}
+		msg.SetData(data)
+		c.lastRecv.Store(time.Now().UnixNano())
+		if rec := c.recorder.Load(); rec != nil {
+			_ = rec.Record(RecordInbound, msg.GetMsgID(), data)
+			_ = rec.Flush()
+		}
+
+		if msg.GetMsgID() == MsgIDAuthResult && len(data) >= 4 && binary.LittleEndian.Uint32(data) == AuthReasonOK {
+			c.authenticated.Store(true)
+		}
+
+		c.client.doMsgHandler(&Request{conn: c, msg: msg})
+	}
+}
+
+func (c *ClientConnection) startWriter() {
+	batch := make([]*outboundMsg, 0, 64)
+	var buf []byte
+	for {
+		select {
+		case <-c.outQueue.ready:
+			for {
+				batch = c.outQueue.popBatch(64*1024, batch[:0])
+				if len(batch) == 0 {
+					break
+				}
+				buf = buf[:0]
+				for _, out := range batch {
+					buf = append(buf, out.data...)
+				}
+				if c.client.opts.WriteTimeout > 0 {
+					_ = c.conn.SetWriteDeadline(time.Now().Add(c.client.opts.WriteTimeout))
+				}
+				if _, err := c.conn.Write(buf); err != nil {
+					fmt.Printf("[Client] Write error for ConnID = %d: %v\n", c.connID, err)
+					c.Stop()
+					return
+				}
+				c.outQueue.markSent(batch)
+				c.recordBatch(batch)
+				for i := range batch {
+					batch[i] = nil
+				}
+			}
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+func (c *ClientConnection) recordBatch(batch []*outboundMsg) {
+	rec := c.recorder.Load()
+	if rec == nil {
+		return
+	}
+	headLen := int(c.client.opts.DataPack.GetHeadLen())
+	for _, out := range batch {
+		if len(out.data) >= headLen {
+			_ = rec.Record(RecordOutbound, out.msgID, out.data[headLen:])
+		}
+	}
+	_ = rec.Flush()
+}
+
+func (c *ClientConnection) startHeartbeat() {
+	opts := c.client.opts
+	ticker := time.NewTicker(opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if opts.HeartbeatTimeout > 0 && time.Since(time.Unix(0, c.lastRecv.Load())) > opts.HeartbeatTimeout {
+				fmt.Printf("[Client] ConnID = %d heartbeat timeout, closing.\n", c.connID)
+				c.Stop()
+				return
+			}
+			var data []byte
+			if opts.HeartbeatData != nil {
+				data = opts.HeartbeatData()
+			}
+			if err := c.SendPriorityMsg(PriorityCritical, opts.HeartbeatMsgID, data); err != nil {
+				fmt.Printf("[Client] Send heartbeat error for ConnID = %d: %v\n", c.connID, err)
+			}
+		case <-c.exitChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package znet
+
+import (
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+type ClientOption func(*ClientOptions)
+
+type ClientOptions struct {
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	SendMsgWait  time.Duration
+
+	Reconnect         bool
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+	HeartbeatMsgID    uint32
+	HeartbeatData     func() []byte
+
+	DataPack         ziface.IDataPack
+	MaxOutQueueBytes int
+
+	OnConnStart func(connection ziface.IConnection)
+	OnConnStop  func(connection ziface.IConnection)
+}
+
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.DialTimeout = timeout
+	}
+}
+
+func WithClientWriteTimeout(timeout time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.WriteTimeout = timeout
+	}
+}
+
+// WithReconnect sets the backoff between redials; the delay doubles after
+// every failed attempt up to maxDelay. Zero minDelay disables reconnecting.
+func WithReconnect(minDelay, maxDelay time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.Reconnect = minDelay > 0
+		o.ReconnectMinDelay = minDelay
+		o.ReconnectMaxDelay = maxDelay
+	}
+}
+
+// WithHeartbeat sends msgID every interval and drops the connection when
+// nothing has been received for timeout. A nil data func sends an empty body.
+func WithHeartbeat(interval, timeout time.Duration, msgID uint32, data func() []byte) ClientOption {
+	return func(o *ClientOptions) {
+		o.HeartbeatInterval = interval
+		o.HeartbeatTimeout = timeout
+		o.HeartbeatMsgID = msgID
+		o.HeartbeatData = data
+	}
+}
+
+func WithClientDataPack(dataPack ziface.IDataPack) ClientOption {
+	return func(o *ClientOptions) {
+		o.DataPack = dataPack
+	}
+}
+
+func WithClientMaxOutQueueBytes(maxBytes int) ClientOption {
+	return func(o *ClientOptions) {
+		o.MaxOutQueueBytes = maxBytes
+	}
+}
+
+func WithClientOnConnStart(hook func(ziface.IConnection)) ClientOption {
+	return func(o *ClientOptions) {
+		o.OnConnStart = hook
+	}
+}
+
+func WithClientOnConnStop(hook func(ziface.IConnection)) ClientOption {
+	return func(o *ClientOptions) {
+		o.OnConnStop = hook
+	}
+}
+
+func newClientOptions(opts ...ClientOption) *ClientOptions {
+	opt := &ClientOptions{
+		DialTimeout:       5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		SendMsgWait:       3 * time.Second,
+		Reconnect:         true,
+		ReconnectMinDelay: 500 * time.Millisecond,
+		ReconnectMaxDelay: 30 * time.Second,
+		MaxOutQueueBytes:  1 << 20,
+	}
+
+	for _, o := range opts {
+		o(opt)
+	}
+
+	if opt.DataPack == nil {
+		opt.DataPack = NewDataPack()
+	}
+	if opt.ReconnectMaxDelay < opt.ReconnectMinDelay {
+		opt.ReconnectMaxDelay = opt.ReconnectMinDelay
+	}
+	return opt
+}
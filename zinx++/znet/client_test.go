@@ -0,0 +1,256 @@
+package znet_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+const msgPing uint32 = 30
+
+// serverConns collects the server side of every accepted connection.
+func serverConns(capacity int) (znet.Option, chan ziface.IConnection) {
+	conns := make(chan ziface.IConnection, capacity)
+	return znet.WithOnConnStart(func(conn ziface.IConnection) { conns <- conn }), conns
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientDialAndStop(t *testing.T) {
+	srv, addr := startServer(t)
+	srv.AddRouter(msgEcho, &echoRouter{})
+
+	var stops atomic.Int32
+	inbox := make(chan received, 1)
+	client := znet.NewClient("tcp", addr, znet.WithClientOnConnStop(func(ziface.IConnection) { stops.Add(1) }))
+	client.AddRouter(msgEcho+1, &inboxRouter{inbox: inbox})
+
+	if err := client.SendMsg(msgEcho, nil); !errors.Is(err, znet.ErrClientNotConnected) {
+		t.Fatalf("SendMsg before Dial = %v", err)
+	}
+	if err := client.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err := client.Dial(context.Background()); !errors.Is(err, znet.ErrClientStarted) {
+		t.Fatalf("second Dial = %v", err)
+	}
+	if !client.IsConnected() || client.Conn() == nil {
+		t.Fatal("not connected after Dial")
+	}
+
+	if err := client.SendMsg(msgEcho, []byte("hello")); err != nil {
+		t.Fatalf("SendMsg: %v", err)
+	}
+	select {
+	case msg := <-inbox:
+		if string(msg.data) != "hello" {
+			t.Fatalf("echo = %q", msg.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no echo")
+	}
+
+	client.Stop()
+	client.Stop()
+	if client.IsConnected() {
+		t.Fatal("still connected after Stop")
+	}
+	if n := stops.Load(); n != 1 {
+		t.Fatalf("OnConnStop ran %d times", n)
+	}
+	if err := client.Dial(context.Background()); !errors.Is(err, znet.ErrClientStopped) {
+		t.Fatalf("Dial after Stop = %v", err)
+	}
+	waitFor(t, "the server to drop the connection", func() bool { return srv.GetConnMgr().Len() == 0 })
+}
+
+func TestClientRedialsWithBackoff(t *testing.T) {
+	// Reserve a port, then leave it closed until the server comes up.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	noRetry := znet.NewClient("tcp", addr, znet.WithReconnect(0, 0))
+	if err := noRetry.Dial(context.Background()); err == nil {
+		t.Fatal("Dial without reconnect succeeded with no server")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	giveUp := znet.NewClient("tcp", addr, znet.WithReconnect(10*time.Millisecond, 20*time.Millisecond))
+	if err := giveUp.Dial(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Dial with an expiring context = %v", err)
+	}
+
+	var starts atomic.Int32
+	client := znet.NewClient("tcp", addr,
+		znet.WithReconnect(10*time.Millisecond, 50*time.Millisecond),
+		znet.WithClientOnConnStart(func(ziface.IConnection) { starts.Add(1) }),
+	)
+	defer client.Stop()
+
+	dialed := make(chan error, 1)
+	begin := time.Now()
+	go func() { dialed <- client.Dial(context.Background()) }()
+
+	time.Sleep(150 * time.Millisecond)
+	hook, conns := serverConns(4)
+	startServer(t, hook, znet.WithListener(znet.ListenerSpec{Name: "late", Network: "tcp", Address: addr}))
+
+	select {
+	case err := <-dialed:
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Dial did not connect once the server was up")
+	}
+	if elapsed := time.Since(begin); elapsed < 150*time.Millisecond {
+		t.Fatalf("Dial returned after %v, before the server existed", elapsed)
+	}
+
+	// The server drops the link; the client dials a new one on its own.
+	first := client.Conn()
+	(<-conns).Stop()
+	select {
+	case <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	waitFor(t, "the new connection", func() bool {
+		conn := client.Conn()
+		return conn != nil && conn != first && client.IsConnected()
+	})
+	if n := starts.Load(); n != 2 {
+		t.Fatalf("OnConnStart ran %d times, want 2", n)
+	}
+}
+
+func TestClientDialAgainAfterDrop(t *testing.T) {
+	hook, conns := serverConns(2)
+	_, addr := startServer(t, hook)
+
+	client := znet.NewClient("tcp", addr, znet.WithReconnect(0, 0))
+	defer client.Stop()
+	if err := client.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	(<-conns).Stop()
+	waitFor(t, "the client to notice the drop", func() bool { return !client.IsConnected() })
+
+	// Without reconnect the link is gone for good, so Dial is allowed again.
+	waitFor(t, "Dial to be accepted again", func() bool {
+		err := client.Dial(context.Background())
+		if err != nil && !errors.Is(err, znet.ErrClientStarted) {
+			t.Fatalf("Dial after drop: %v", err)
+		}
+		return err == nil
+	})
+	if !client.IsConnected() {
+		t.Fatal("not connected after the second Dial")
+	}
+}
+
+func TestClientHeartbeat(t *testing.T) {
+	srv, addr := startServer(t)
+	srv.AddRouter(msgEcho, &echoRouter{})
+
+	// Answered heartbeats keep the link up well past the timeout.
+	var drops atomic.Int32
+	alive := znet.NewClient("tcp", addr,
+		znet.WithReconnect(0, 0),
+		znet.WithHeartbeat(20*time.Millisecond, 100*time.Millisecond, msgEcho, func() []byte { return []byte("hb") }),
+		znet.WithClientOnConnStop(func(ziface.IConnection) { drops.Add(1) }),
+	)
+	defer alive.Stop()
+	if err := alive.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	// Unanswered ones end it after the timeout.
+	silent := znet.NewClient("tcp", addr,
+		znet.WithReconnect(0, 0),
+		znet.WithHeartbeat(20*time.Millisecond, 100*time.Millisecond, msgPing, nil),
+	)
+	defer silent.Stop()
+	if err := silent.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if !alive.IsConnected() || drops.Load() != 0 {
+		t.Fatal("heartbeat timeout dropped a link whose heartbeats were answered")
+	}
+	if silent.IsConnected() {
+		t.Fatal("link without heartbeat replies is still up")
+	}
+}
+
+func TestClientDataPackWithLimit(t *testing.T) {
+	srv, addr := startServer(t, znet.WithMaxPacketSize(64<<10))
+	srv.AddRouter(msgEcho, &echoRouter{})
+	body := make([]byte, 16<<10)
+
+	// The reply is larger than the global MaxPacketSize but within the
+	// client's own limit.
+	inbox := make(chan received, 1)
+	client := znet.NewClient("tcp", addr,
+		znet.WithReconnect(0, 0),
+		znet.WithClientDataPack(znet.NewDataPackWithLimit(64<<10)),
+	)
+	client.AddRouter(msgEcho+1, &inboxRouter{inbox: inbox})
+	if err := client.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Stop()
+	if err := client.SendMsg(msgEcho, body); err != nil {
+		t.Fatalf("SendMsg: %v", err)
+	}
+	select {
+	case msg := <-inbox:
+		if len(msg.data) != len(body) {
+			t.Fatalf("echo of %d bytes, want %d", len(msg.data), len(body))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no echo")
+	}
+
+	// A smaller limit drops the connection on the same reply.
+	stopped := make(chan struct{})
+	small := znet.NewClient("tcp", addr,
+		znet.WithReconnect(0, 0),
+		znet.WithClientDataPack(znet.NewDataPackWithLimit(1024)),
+		znet.WithClientOnConnStop(func(ziface.IConnection) { close(stopped) }),
+	)
+	small.AddRouter(msgEcho+1, &inboxRouter{inbox: make(chan received, 1)})
+	if err := small.Dial(context.Background()); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer small.Stop()
+	if err := small.SendMsg(msgEcho, body); err != nil {
+		t.Fatalf("SendMsg: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("oversized reply accepted past the client's limit")
+	}
+}
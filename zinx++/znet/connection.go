@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 	"zinxplusplus/config"
 	"zinxplusplus/ziface"
@@ -31,15 +33,38 @@ type Connection struct {
 
 	dataPack ziface.IDataPack
 
+	config *config.Store
+
 	property map[string]interface{}
 
 	propertyLock sync.RWMutex
 
 	exitChan chan struct{}
 
-	msgChan chan []byte
+	outQueue *outQueue
+	// writeLock is held by the writer from popping a batch until it is
+	// recorded, so parkUnsent sees either all or none of a batch.
+	writeLock sync.Mutex
+
+	onSlowConsumer func(connection ziface.IConnection, stats ziface.ConnSendStats)
+
+	listener *serverListener
+
+	admission *AdmissionController
+	admitted  atomic.Bool
 
-	msgBuffChan chan []byte
+	proxyPending atomic.Bool
+	started      atomic.Bool
+	proxiedAddr  atomic.Pointer[net.TCPAddr]
+
+	auth          *authGate
+	authenticated atomic.Bool
+	authTimerID   atomic.Uint64
+	identity      interface{}
+
+	session atomic.Pointer[Session]
+
+	recorder atomic.Pointer[PacketRecorder]
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -52,6 +77,16 @@ type Connection struct {
 }
 
 func NewConnection(server ziface.IServer, conn netpoll.Connection, connID uint64, workerID uint32, msgHandler ziface.IMsgHandler) (ziface.IConnection, error) {
+	return newConnection(server, conn, connID, workerID, msgHandler, nil)
+}
+
+func newConnection(server ziface.IServer, conn netpoll.Connection, connID uint64, workerID uint32, msgHandler ziface.IMsgHandler, l *serverListener) (ziface.IConnection, error) {
+
+	store := config.GlobalStore()
+	if s, ok := server.(*Server); ok {
+		store = s.config
+	}
+	settings := store.Load().Server
 
 	c := &Connection{
 		server:     server,
@@ -60,12 +95,32 @@ func NewConnection(server ziface.IServer, conn netpoll.Connection, connID uint64
 		workerID:   workerID,
 		isClosed:   false,
 		msgHandler: msgHandler,
-		dataPack:   NewDataPack(),
+		dataPack:   newDataPack(store),
+		config:     store,
 		property:   make(map[string]interface{}),
 		exitChan:   make(chan struct{}, 1),
 
-		msgChan:     make(chan []byte, config.GlobalConfig.Server.MaxMsgChanLen),
-		msgBuffChan: make(chan []byte, config.GlobalConfig.Server.MaxMsgBuffChanLen),
+		outQueue: newOutQueue(settings.MaxOutQueueBytes, settings.SlowConsumerPolicy),
+	}
+
+	if s, ok := server.(*Server); ok {
+		c.onSlowConsumer = s.opts.OnSlowConsumer
+		c.admission = s.admission
+		c.auth = s.auth
+		c.proxyPending.Store(s.fromTrustedProxy(conn.RemoteAddr()))
+	}
+	if l != nil {
+		c.listener = l
+		if l.spec.DataPack != nil {
+			c.dataPack = l.spec.DataPack
+		}
+		if l.spec.SkipAuth {
+			c.auth = nil
+		}
+	}
+	c.admitted.Store(!c.proxyPending.Load())
+	if c.proxyPending.Load() {
+		_ = conn.SetReadTimeout(time.Duration(settings.ProxyHeaderTimeoutMs) * time.Millisecond)
 	}
 
 	c.ctx, c.cancel = context.WithCancel(context.Background())
@@ -89,10 +144,26 @@ func (c *Connection) Start() {
 	}
 	c.closeLock.RUnlock()
 
+	// Behind a proxy the connection starts once the real address is known.
+	if c.proxyPending.Load() || !c.started.CompareAndSwap(false, true) {
+		return
+	}
+
 	fmt.Printf("[Connection] ConnID = %d starting...\n", c.connID)
 
 	go c.startWriter()
 
+	if c.auth != nil {
+		c.auth.armDeadline(c)
+	}
+
+	if s, ok := c.server.(*Server); ok && s.opts.RecordDir != "" && (s.opts.RecordFilter == nil || s.opts.RecordFilter(c)) {
+		path := filepath.Join(s.opts.RecordDir, fmt.Sprintf("conn-%d-%d.zrec", c.connID, time.Now().Unix()))
+		if err := c.StartRecording(path); err != nil {
+			fmt.Printf("[Connection] Start recording ConnID = %d error: %v\n", c.connID, err)
+		}
+	}
+
 	c.server.CallOnConnStart(c)
 }
 
@@ -132,6 +203,10 @@ func (c *Connection) Stop() {
 		fmt.Printf("[Connection] Remove from ConnManager error: %v\n", err)
 	}
 
+	if c.admission != nil && c.admitted.CompareAndSwap(true, false) {
+		c.admission.Release(c.RemoteAddr())
+	}
+
 	if !c.conn.IsActive() {
 		fmt.Printf("[Connection] Netpoll ConnID = %d already inactive.\n", c.connID)
 	} else {
@@ -142,6 +217,10 @@ func (c *Connection) Stop() {
 		}
 	}
 
+	if err := c.StopRecording(); err != nil && !errors.Is(err, ErrNotRecording) {
+		fmt.Printf("[Connection] Close recording ConnID = %d error: %v\n", c.connID, err)
+	}
+
 	c.cancel()
 
 	fmt.Printf("[Connection] ConnID = %d stopped.\n", c.connID)
@@ -160,6 +239,9 @@ func (c *Connection) GetWorkerID() uint32 {
 }
 
 func (c *Connection) RemoteAddr() net.Addr {
+	if addr := c.proxiedAddr.Load(); addr != nil {
+		return addr
+	}
 	return c.conn.RemoteAddr()
 }
 
@@ -168,6 +250,35 @@ func (c *Connection) LocalAddr() net.Addr {
 }
 
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
+	return c.send(&outboundMsg{msgID: msgId, priority: PriorityGameplay}, data, c.sendMsgTimeout())
+}
+
+func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
+	return c.send(&outboundMsg{msgID: msgId, priority: PriorityGameplay}, data, 0)
+}
+
+func (c *Connection) SendPriorityMsg(priority uint8, msgId uint32, data []byte) error {
+	if priority >= numPriorities {
+		return fmt.Errorf("invalid send priority %d, msgId=%d", priority, msgId)
+	}
+	return c.send(&outboundMsg{msgID: msgId, priority: priority}, data, 0)
+}
+
+// SendCoalescedMsg replaces a still-queued message with the same coalesceKey
+// in place instead of queueing another one, e.g. for position updates of an
+// entity where only the latest matters. coalesceKey 0 disables coalescing.
+func (c *Connection) SendCoalescedMsg(priority uint8, coalesceKey uint64, msgId uint32, data []byte) error {
+	if priority >= numPriorities {
+		return fmt.Errorf("invalid send priority %d, msgId=%d", priority, msgId)
+	}
+	return c.send(&outboundMsg{msgID: msgId, priority: priority, coalesceKey: coalesceKey}, data, 0)
+}
+
+func (c *Connection) GetSendStats() ziface.ConnSendStats {
+	return c.outQueue.snapshot()
+}
+
+func (c *Connection) send(out *outboundMsg, data []byte, wait time.Duration) error {
 	c.closeLock.RLock()
 	if c.isClosed {
 		c.closeLock.RUnlock()
@@ -175,43 +286,64 @@ func (c *Connection) SendMsg(msgId uint32, data []byte) error {
 	}
 	c.closeLock.RUnlock()
 
-	msg, err := c.dataPack.Pack(NewMsgPackage(msgId, data))
+	msg, err := c.dataPack.Pack(NewMsgPackage(out.msgID, data))
 	if err != nil {
-		return fmt.Errorf("pack error msg id = %d: %w", msgId, err)
+		return fmt.Errorf("pack error msg id = %d: %w", out.msgID, err)
 	}
+	out.data = msg
 
-	select {
-	case c.msgChan <- msg:
-		return nil
-	case <-time.After(time.Duration(config.GlobalConfig.Server.SendMsgTimeoutMs) * time.Millisecond):
-		return fmt.Errorf("send msg timeout (channel full?), msgId=%d", msgId)
-	case <-c.exitChan:
-		return errors.New("connection closed when send msg")
-	}
+	return c.enqueueMsg(out, wait)
 }
 
-func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
-	c.closeLock.RLock()
-	if c.isClosed {
-		c.closeLock.RUnlock()
-		return errors.New("connection closed when send buff msg")
+func (c *Connection) enqueueMsg(out *outboundMsg, wait time.Duration) error {
+	slow, err := c.outQueue.push(out, wait, c.exitChan)
+	if !slow {
+		return err
 	}
-	c.closeLock.RUnlock()
 
-	msg, err := c.dataPack.Pack(NewMsgPackage(msgId, data))
-	if err != nil {
-		return fmt.Errorf("pack error buff msg id = %d: %w", msgId, err)
+	switch c.outQueue.policy {
+	case SlowConsumerDisconnect:
+		fmt.Printf("[Connection] Slow consumer ConnID = %d, disconnecting.\n", c.connID)
+		go c.Stop()
+		return fmt.Errorf("%w: ConnID=%d", ErrSlowConsumer, c.connID)
+	case SlowConsumerCallback:
+		if c.onSlowConsumer != nil {
+			stats := c.outQueue.snapshot()
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						fmt.Printf("[Connection] OnSlowConsumer panic: %v\n", r)
+					}
+				}()
+				c.onSlowConsumer(c, stats)
+			}()
+		}
 	}
+	return err
+}
+
+func (c *Connection) sendMsgTimeout() time.Duration {
+	return time.Duration(c.config.Load().Server.SendMsgTimeoutMs) * time.Millisecond
+}
 
-	select {
-	case c.msgBuffChan <- msg:
-		return nil
-	case <-c.exitChan:
-		return errors.New("connection closed when send buff msg")
-	default:
+func (c *Connection) sendUntrackedMsg(msgId uint32, data []byte) error {
+	return c.send(&outboundMsg{msgID: msgId, priority: PriorityCritical, untracked: true}, data, 0)
+}
 
-		return fmt.Errorf("send buff msg channel is full, msgId=%d", msgId)
+// parkUnsent closes the out-queue and records every tracked message still in
+// it into sess, after anything the writer is flushing. The connection stops
+// recording into sess afterwards.
+func (c *Connection) parkUnsent(sess *Session) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	headLen := int(c.dataPack.GetHeadLen())
+	for _, out := range c.outQueue.close() {
+		if !out.untracked && len(out.data) >= headLen {
+			sess.record(out.msgID, out.data[headLen:])
+		}
 	}
+	c.session.CompareAndSwap(sess, nil)
 }
 
 func (c *Connection) SetProperty(key string, value interface{}) {
@@ -238,6 +370,16 @@ func (c *Connection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+func (c *Connection) snapshotProperties() map[string]interface{} {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	props := make(map[string]interface{}, len(c.property))
+	for k, v := range c.property {
+		props[k] = v
+	}
+	return props
+}
+
 func (c *Connection) Context() context.Context {
 	return c.ctx
 }
@@ -274,11 +416,65 @@ func (c *Connection) IsClosed() bool {
 	return c.isClosed
 }
 
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated.Load()
+}
+
+func (c *Connection) GetIdentity() interface{} {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+	return c.identity
+}
+
+// StartRecording writes every frame of this connection from now on to path,
+// see recorder.go for the format.
+func (c *Connection) StartRecording(path string) error {
+	rec, err := CreatePacketRecorder(path, c.connID, c.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	if !c.recorder.CompareAndSwap(nil, rec) {
+		rec.Close()
+		return fmt.Errorf("%w: ConnID=%d", ErrAlreadyRecording, c.connID)
+	}
+	fmt.Printf("[Connection] Recording ConnID = %d to %s\n", c.connID, path)
+	return nil
+}
+
+func (c *Connection) StopRecording() error {
+	rec := c.recorder.Swap(nil)
+	if rec == nil {
+		return ErrNotRecording
+	}
+	return rec.Close()
+}
+
+func (c *Connection) markAuthenticated(identity interface{}) {
+	if c.auth != nil {
+		c.auth.cancelDeadline(c)
+	}
+	c.propertyLock.Lock()
+	c.identity = identity
+	c.propertyLock.Unlock()
+	c.authenticated.Store(true)
+}
+
 func (c *Connection) handleAPI(ctx context.Context, connection netpoll.Connection) error {
 	fmt.Println("连接到达：", connection.LocalAddr())
 
 	c.updateActivity()
 
+	if c.proxyPending.Load() {
+		if err := c.acceptProxyHeader(connection); err != nil {
+			fmt.Printf("[Connection] PROXY header error for ConnID = %d from %s: %v\n", c.connID, connection.RemoteAddr(), err)
+			c.Stop()
+			return err
+		}
+		if connection.Reader().Len() == 0 {
+			return nil
+		}
+	}
+
 	for {
 
 		reader := connection.Reader()
@@ -292,12 +488,14 @@ func (c *Connection) handleAPI(ctx context.Context, connection netpoll.Connectio
 			}
 			if errors.Is(err, ErrDataTooLarge) {
 				fmt.Printf("[Connection] Data too large error for ConnID = %d: %v\n", c.connID, err)
+				c.reportViolation(err.Error())
 
 				c.Stop()
 				return err
 			}
 
 			fmt.Printf("[Connection] Unpack error for ConnID = %d: %v\n", c.connID, err)
+			c.reportViolation(err.Error())
 			c.Stop()
 			return err
 		}
@@ -323,12 +521,18 @@ func (c *Connection) handleAPI(ctx context.Context, connection netpoll.Connectio
 		}
 		msg.SetData(data)
 
+		if rec := c.recorder.Load(); rec != nil {
+			_ = rec.Record(RecordInbound, msg.GetMsgID(), data)
+		}
+
 		req := &Request{
 			conn: c,
 			msg:  msg,
 		}
 
-		if config.GlobalConfig.Server.WorkerPoolSize > 0 {
+		if c.auth != nil && !c.auth.allows(c, req.GetMsgID()) {
+			fmt.Printf("[Connection] %v: ConnID = %d, dropping MsgID = %d\n", ErrNotAuthenticated, c.connID, req.GetMsgID())
+		} else if c.config.Load().Server.WorkerPoolSize > 0 {
 			if sendErr := c.msgHandler.SendMsgToTaskQueue(req); sendErr != nil {
 				fmt.Printf("[Connection] SendMsgToTaskQueue error for ConnID = %d, MsgID = %d: %v\n", c.connID, req.GetMsgID(), sendErr)
 			}
@@ -342,6 +546,44 @@ func (c *Connection) handleAPI(ctx context.Context, connection netpoll.Connectio
 		}
 	}
 
+	if rec := c.recorder.Load(); rec != nil {
+		_ = rec.Flush()
+	}
+	return nil
+}
+
+// acceptProxyHeader reads the PROXY header sent by a trusted balancer, swaps
+// in the real client address and runs the admission check deferred at accept.
+func (c *Connection) acceptProxyHeader(connection netpoll.Connection) error {
+	reader := connection.Reader()
+	addr, err := readProxyHeader(reader)
+	if err != nil {
+		return err
+	}
+	_ = reader.Release()
+	// Back from the header deadline to the one onNetpollPrepare set.
+	_ = connection.SetReadTimeout(time.Duration(c.config.Load().Server.ReadTimeoutMs) * time.Millisecond)
+
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		c.proxiedAddr.Store(tcpAddr)
+		fmt.Printf("[Connection] ConnID = %d real client %s via proxy %s\n", c.connID, tcpAddr, connection.RemoteAddr())
+	}
+
+	if c.admission != nil {
+		if err := c.admission.Admit(c.RemoteAddr()); err != nil {
+			return err
+		}
+	}
+	c.admitted.Store(true)
+	if c.IsClosed() && c.admitted.CompareAndSwap(true, false) && c.admission != nil {
+		c.admission.Release(c.RemoteAddr())
+	}
+
+	c.proxyPending.Store(false)
+	// Synchronous: frames that arrived with the header are dispatched right
+	// after this returns and must see OnConnStart, the auth deadline and the
+	// recorder already in place.
+	c.Start()
 	return nil
 }
 
@@ -350,21 +592,39 @@ func (c *Connection) startWriter() {
 	defer fmt.Printf("[Writer Goroutine] Stopped for ConnID = %d\n", c.connID)
 
 	writer := c.conn.Writer()
+	settings := c.config.Load().Server
+	maxBatch := settings.MaxWriteBatchBytes
+	flushDelay := time.Duration(settings.WriteFlushDelayMs) * time.Millisecond
+	batch := make([]*outboundMsg, 0, 64)
 
 	for {
 		select {
-		case data := <-c.msgChan:
-			if err := c.writeToNetpoll(writer, data); err != nil {
-				fmt.Printf("[Connection] Write msgChan error for ConnID = %d: %v\n", c.connID, err)
-
-				c.Stop()
-				return
+		case <-c.outQueue.ready:
+			// Nagle-like: give concurrent senders a moment to add to the batch
+			// unless there is already a full batch waiting.
+			if flushDelay > 0 && (maxBatch <= 0 || c.outQueue.pendingBytes() < maxBatch) {
+				select {
+				case <-time.After(flushDelay):
+				case <-c.exitChan:
+					return
+				}
 			}
-		case data := <-c.msgBuffChan:
-			if err := c.writeToNetpoll(writer, data); err != nil {
-				fmt.Printf("[Connection] Write msgBuffChan error for ConnID = %d: %v\n", c.connID, err)
-				c.Stop()
-				return
+
+			for {
+				c.writeLock.Lock()
+				batch = c.outQueue.popBatch(maxBatch, batch[:0])
+				if len(batch) == 0 {
+					c.writeLock.Unlock()
+					break
+				}
+				err := c.writeBatch(writer, batch)
+				c.writeLock.Unlock()
+				if err != nil {
+					fmt.Printf("[Connection] Write outbound error for ConnID = %d: %v\n", c.connID, err)
+
+					c.Stop()
+					return
+				}
 			}
 		case <-c.exitChan:
 
@@ -373,18 +633,51 @@ func (c *Connection) startWriter() {
 	}
 }
 
-func (c *Connection) writeToNetpoll(writer netpoll.Writer, data []byte) error {
+// writeBatch copies a whole batch into one netpoll buffer so it goes out with
+// a single Flush, i.e. one write syscall instead of one per message. Tracked
+// messages are recorded into the session even when the write fails, so a
+// resume replays them.
+func (c *Connection) writeBatch(writer netpoll.Writer, batch []*outboundMsg) error {
+	headLen := int(c.dataPack.GetHeadLen())
+	sess := c.session.Load()
+	defer func() {
+		for i, out := range batch {
+			if sess != nil && !out.untracked && len(out.data) >= headLen {
+				sess.record(out.msgID, out.data[headLen:])
+			}
+			batch[i] = nil
+		}
+	}()
+
+	total := 0
+	for _, out := range batch {
+		total += len(out.data)
+	}
 
-	alloc, err := writer.Malloc(len(data))
+	alloc, err := writer.Malloc(total)
 	if err != nil {
 		return fmt.Errorf("writer malloc error: %w", err)
 	}
-	copy(alloc, data)
+	offset := 0
+	for _, out := range batch {
+		offset += copy(alloc[offset:], out.data)
+	}
 	if err = writer.Flush(); err != nil {
 		return fmt.Errorf("writer flush error: %w", err)
 	}
 
 	c.updateActivity()
+	c.outQueue.markSent(batch)
+
+	rec := c.recorder.Load()
+	for _, out := range batch {
+		if rec != nil && len(out.data) >= headLen {
+			_ = rec.Record(RecordOutbound, out.msgID, out.data[headLen:])
+		}
+	}
+	if rec != nil {
+		_ = rec.Flush()
+	}
 	return nil
 }
 
@@ -395,6 +688,18 @@ func (c *Connection) netpollCloseCallback(connection netpoll.Connection) error {
 	return nil
 }
 
+func (c *Connection) reportViolation(reason string) {
+	if c.admission != nil {
+		c.admission.ReportViolation(c.RemoteAddr(), reason)
+	}
+}
+
+func (c *Connection) reportAuthFailure(reason string) {
+	if c.admission != nil {
+		c.admission.ReportAuthFailure(c.RemoteAddr(), reason)
+	}
+}
+
 func (c *Connection) updateActivity() {
 
 	c.lastActivityTime = time.Now()
@@ -59,15 +59,20 @@ func (cm *ConnManager) Len() int {
 }
 
 func (cm *ConnManager) ClearConn() {
-	cm.connLock.Lock()
-	defer cm.connLock.Unlock()
+	// Stop calls back into Remove, so stop outside the lock.
+	cm.connLock.RLock()
+	conns := make([]ziface.IConnection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		conns = append(conns, conn)
+	}
+	cm.connLock.RUnlock()
 
-	for connID, conn := range cm.connections {
+	for _, conn := range conns {
 
 		conn.Stop()
 
-		fmt.Printf("[ConnManager] Stopping ConnID = %d in ClearConn\n", connID)
+		fmt.Printf("[ConnManager] Stopping ConnID = %d in ClearConn\n", conn.GetConnID())
 	}
 
-	fmt.Printf("[ConnManager] All connections cleared. Current conns = %d\n", len(cm.connections))
+	fmt.Printf("[ConnManager] All connections cleared. Current conns = %d\n", cm.Len())
 }
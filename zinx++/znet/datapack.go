@@ -19,10 +19,29 @@ var (
 	ErrDataTooLarge      = errors.New("received msg data too large")
 )
 
-type DataPack struct{}
+// DataPack frames messages as dataLen(u32) msgID(u32) data, little endian.
+// The packet size limit comes from its config store.
+type DataPack struct {
+	config *config.Store
+}
 
+// NewDataPack limits packet size from the global config; servers use their
+// own.
 func NewDataPack() ziface.IDataPack {
-	return &DataPack{}
+	return newDataPack(config.GlobalStore())
+}
+
+// NewDataPackWithLimit rejects bodies larger than maxPacketSize whatever the
+// global config says; 0 accepts any size. Tools use it to size a client
+// without touching the global config.
+func NewDataPackWithLimit(maxPacketSize uint32) ziface.IDataPack {
+	cfg := config.Defaults()
+	cfg.Server.MaxPacketSize = maxPacketSize
+	return newDataPack(config.NewStore(cfg))
+}
+
+func newDataPack(store *config.Store) *DataPack {
+	return &DataPack{config: store}
 }
 
 func (dp *DataPack) GetHeadLen() uint32 {
@@ -81,7 +100,7 @@ func (dp *DataPack) Unpack(reader netpoll.Reader) (ziface.IMessage, error) {
 		return nil, fmt.Errorf("unpack read msgid error: %w", err)
 	}
 
-	maxPacketSize := config.GlobalConfig.Server.MaxPacketSize
+	maxPacketSize := dp.config.Load().Server.MaxPacketSize
 	if maxPacketSize > 0 && msg.DataLen > maxPacketSize {
 
 		reader.Release()
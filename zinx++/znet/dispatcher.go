@@ -0,0 +1,334 @@
+package znet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+const (
+	DispatchConnID      = "conn-id"
+	DispatchHashKey     = "hash-key"
+	DispatchLeastLoaded = "least-loaded"
+	DispatchActor       = "actor"
+
+	hashRingReplicas = 64
+	actorIdleTimeout = 30 * time.Second
+)
+
+// DispatchKeyFunc returns the ordering key of a request. Requests sharing a
+// key are always processed in arrival order, whatever the dispatch mode.
+type DispatchKeyFunc func(request ziface.IRequest) string
+
+func ConnIDKey(request ziface.IRequest) string {
+	return "c" + strconv.FormatUint(request.GetConnection().GetConnID(), 10)
+}
+
+// SessionKey keys by the bound user ID so a reconnecting player keeps its
+// worker. Requests sent before the session is bound fall back to ConnIDKey;
+// the selectors keep a connection on that key until its requests in flight
+// have drained, so the switch at Bind cannot reorder them.
+func SessionKey(request ziface.IRequest) string {
+	return PropertyKey(PropKeyUserID)(request)
+}
+
+func PropertyKey(prop string) DispatchKeyFunc {
+	return func(request ziface.IRequest) string {
+		value, err := request.GetConnection().GetProperty(prop)
+		if err != nil || value == nil {
+			return ConnIDKey(request)
+		}
+		return "p" + fmt.Sprint(value)
+	}
+}
+
+// stickyKeys holds each connection on the key its in-flight requests were
+// dispatched with. A key function whose answer changes for a connection, like
+// SessionKey once the user is bound, only takes effect after they drain.
+type stickyKeys struct {
+	keyFn DispatchKeyFunc
+	conns map[uint64]*stickyKey
+	lock  sync.Mutex
+}
+
+type stickyKey struct {
+	key      string
+	inflight int
+}
+
+func newStickyKeys(keyFn DispatchKeyFunc) *stickyKeys {
+	if keyFn == nil {
+		keyFn = SessionKey
+	}
+	return &stickyKeys{keyFn: keyFn, conns: make(map[uint64]*stickyKey)}
+}
+
+// key returns the request's dispatch key; every call must be matched by one
+// done once the request has been handled or dropped.
+func (s *stickyKeys) key(request ziface.IRequest) string {
+	want := s.keyFn(request)
+	connID := request.GetConnection().GetConnID()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sk, ok := s.conns[connID]
+	if !ok {
+		sk = &stickyKey{key: want}
+		s.conns[connID] = sk
+	}
+	sk.inflight++
+	return sk.key
+}
+
+func (s *stickyKeys) done(request ziface.IRequest) {
+	connID := request.GetConnection().GetConnID()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sk, ok := s.conns[connID]; ok {
+		sk.inflight--
+		if sk.inflight <= 0 {
+			delete(s.conns, connID)
+		}
+	}
+}
+
+type WorkerSelector interface {
+	SelectWorker(request ziface.IRequest) uint32
+
+	Done(request ziface.IRequest, workerID uint32)
+}
+
+func NewWorkerSelector(mode string, poolSize uint32, keyFn DispatchKeyFunc) (WorkerSelector, error) {
+	switch mode {
+	case "", DispatchConnID:
+		return &connIDSelector{poolSize: poolSize}, nil
+	case DispatchHashKey:
+		return newHashKeySelector(poolSize, newStickyKeys(keyFn)), nil
+	case DispatchLeastLoaded:
+		return newLeastLoadedSelector(poolSize, newStickyKeys(keyFn)), nil
+	default:
+		return nil, fmt.Errorf("unknown worker dispatch mode: %s", mode)
+	}
+}
+
+type connIDSelector struct {
+	poolSize uint32
+}
+
+func (s *connIDSelector) SelectWorker(request ziface.IRequest) uint32 {
+	return uint32(request.GetConnection().GetConnID() % uint64(s.poolSize))
+}
+
+func (s *connIDSelector) Done(request ziface.IRequest, workerID uint32) {}
+
+type hashRingNode struct {
+	hash     uint32
+	workerID uint32
+}
+
+type hashKeySelector struct {
+	keys *stickyKeys
+	ring []hashRingNode
+}
+
+func newHashKeySelector(poolSize uint32, keys *stickyKeys) *hashKeySelector {
+	ring := make([]hashRingNode, 0, int(poolSize)*hashRingReplicas)
+	for w := uint32(0); w < poolSize; w++ {
+		for r := 0; r < hashRingReplicas; r++ {
+			ring = append(ring, hashRingNode{
+				hash:     hashKey(fmt.Sprintf("worker-%d-%d", w, r)),
+				workerID: w,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return &hashKeySelector{keys: keys, ring: ring}
+}
+
+func (s *hashKeySelector) SelectWorker(request ziface.IRequest) uint32 {
+	h := hashKey(s.keys.key(request))
+	idx := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if idx == len(s.ring) {
+		idx = 0
+	}
+	return s.ring[idx].workerID
+}
+
+func (s *hashKeySelector) Done(request ziface.IRequest, workerID uint32) {
+	s.keys.done(request)
+}
+
+type keyBinding struct {
+	workerID uint32
+	inflight int
+}
+
+// leastLoadedSelector pins a key to the least busy worker while it has
+// requests in flight; once they drain the key may move, which cannot reorder
+// anything because nothing of it is queued anywhere.
+type leastLoadedSelector struct {
+	keys     *stickyKeys
+	load     []int64
+	bindings map[string]*keyBinding
+	requests sync.Map
+	lock     sync.Mutex
+}
+
+func newLeastLoadedSelector(poolSize uint32, keys *stickyKeys) *leastLoadedSelector {
+	return &leastLoadedSelector{
+		keys:     keys,
+		load:     make([]int64, poolSize),
+		bindings: make(map[string]*keyBinding),
+	}
+}
+
+func (s *leastLoadedSelector) SelectWorker(request ziface.IRequest) uint32 {
+	key := s.keys.key(request)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.bindings[key]
+	if !ok {
+		best := 0
+		for i := 1; i < len(s.load); i++ {
+			if atomic.LoadInt64(&s.load[i]) < atomic.LoadInt64(&s.load[best]) {
+				best = i
+			}
+		}
+		b = &keyBinding{workerID: uint32(best)}
+		s.bindings[key] = b
+	}
+	b.inflight++
+	atomic.AddInt64(&s.load[b.workerID], 1)
+	s.requests.Store(request, key)
+	return b.workerID
+}
+
+func (s *leastLoadedSelector) Done(request ziface.IRequest, workerID uint32) {
+	atomic.AddInt64(&s.load[workerID], -1)
+	s.keys.done(request)
+
+	v, ok := s.requests.LoadAndDelete(request)
+	if !ok {
+		return
+	}
+	key := v.(string)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if b, ok := s.bindings[key]; ok {
+		b.inflight--
+		if b.inflight <= 0 {
+			delete(s.bindings, key)
+		}
+	}
+}
+
+type actorMailbox struct {
+	tasks   chan ziface.IRequest
+	pending int
+}
+
+// actorDispatcher gives every key its own mailbox goroutine, created on first
+// use and retired after actorIdleTimeout without traffic.
+type actorDispatcher struct {
+	mh        *MsgHandle
+	keys      *stickyKeys
+	queueLen  uint32
+	mailboxes map[string]*actorMailbox
+	lock      sync.Mutex
+}
+
+func newActorDispatcher(mh *MsgHandle, keyFn DispatchKeyFunc, queueLen uint32) *actorDispatcher {
+	if queueLen == 0 {
+		queueLen = 1024
+	}
+	return &actorDispatcher{
+		mh:        mh,
+		keys:      newStickyKeys(keyFn),
+		queueLen:  queueLen,
+		mailboxes: make(map[string]*actorMailbox),
+	}
+}
+
+func (ad *actorDispatcher) dispatch(request ziface.IRequest, timeout time.Duration) error {
+	key := ad.keys.key(request)
+
+	ad.lock.Lock()
+	mb, ok := ad.mailboxes[key]
+	if !ok {
+		mb = &actorMailbox{tasks: make(chan ziface.IRequest, ad.queueLen)}
+		ad.mailboxes[key] = mb
+		ad.mh.wg.Add(1)
+		go ad.run(key, mb)
+	}
+	// pending keeps run from retiring the mailbox while we wait for space.
+	mb.pending++
+	ad.lock.Unlock()
+
+	defer func() {
+		ad.lock.Lock()
+		mb.pending--
+		ad.lock.Unlock()
+	}()
+
+	select {
+	case mb.tasks <- request:
+		return nil
+	case <-time.After(timeout):
+		ad.keys.done(request)
+		return fmt.Errorf("send actor mailbox timeout, key=%s, mailbox maybe full", key)
+	}
+}
+
+func (ad *actorDispatcher) run(key string, mb *actorMailbox) {
+	defer ad.mh.wg.Done()
+
+	idle := time.NewTimer(actorIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case request := <-mb.tasks:
+			ad.mh.DoMsgHandler(request)
+			ad.keys.done(request)
+			idle.Reset(actorIdleTimeout)
+		case <-idle.C:
+			ad.lock.Lock()
+			if len(mb.tasks) == 0 && mb.pending == 0 {
+				delete(ad.mailboxes, key)
+				ad.lock.Unlock()
+				return
+			}
+			ad.lock.Unlock()
+			idle.Reset(actorIdleTimeout)
+		case <-ad.mh.stopChan:
+			for {
+				select {
+				case request := <-mb.tasks:
+					ad.mh.DoMsgHandler(request)
+					ad.keys.done(request)
+				default:
+					ad.lock.Lock()
+					delete(ad.mailboxes, key)
+					ad.lock.Unlock()
+					return
+				}
+			}
+		}
+	}
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,46 @@
+package znet_test
+
+import (
+	"testing"
+
+	"zinxplusplus/znet"
+	"zinxplusplus/ztest"
+)
+
+func TestSessionKeySwitchWaitsForInflight(t *testing.T) {
+	for _, mode := range []string{znet.DispatchHashKey, znet.DispatchLeastLoaded} {
+		t.Run(mode, func(t *testing.T) {
+			sel, err := znet.NewWorkerSelector(mode, 64, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := ztest.NewFakeConnection(1)
+
+			login := ztest.Request(conn, 1, nil)
+			loginWorker := sel.SelectWorker(login)
+
+			// The login handler binds the user while it is still in flight.
+			conn.SetProperty(znet.PropKeyUserID, uint64(42))
+			next := ztest.Request(conn, 2, nil)
+			if w := sel.SelectWorker(next); w != loginWorker {
+				t.Fatalf("request after bind went to worker %d while login is in flight on %d", w, loginWorker)
+			}
+			sel.Done(login, loginWorker)
+			sel.Done(next, loginWorker)
+
+			// Drained: the connection now follows the user, wherever the
+			// user's other connection went.
+			other := ztest.NewFakeConnection(2)
+			other.SetProperty(znet.PropKeyUserID, uint64(42))
+			otherReq := ztest.Request(other, 1, nil)
+			userWorker := sel.SelectWorker(otherReq)
+
+			later := ztest.Request(conn, 3, nil)
+			if w := sel.SelectWorker(later); w != userWorker {
+				t.Fatalf("drained connection went to worker %d, user is on %d", w, userWorker)
+			}
+			sel.Done(later, userWorker)
+			sel.Done(otherReq, userWorker)
+		})
+	}
+}
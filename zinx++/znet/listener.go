@@ -0,0 +1,150 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"zinxplusplus/config"
+	"zinxplusplus/ziface"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var (
+	ErrListenerNotFound = errors.New("listener not found")
+	ErrSharedRouters    = errors.New("listener shares the server router set")
+)
+
+// ListenerSpec describes one endpoint the server accepts connections on.
+// All listeners share the server's ConnManager and worker pool.
+type ListenerSpec struct {
+	Name    string
+	Network string // tcp, tcp4, tcp6 or unix
+	Address string // host:port, or the socket path for unix
+
+	// Routers gives the listener its own router set, e.g. to keep admin
+	// messages off the public port. Nil shares the server's routers.
+	Routers map[uint32]ziface.IRouter
+	// DataPack overrides the framing for this listener. Nil uses the default.
+	DataPack ziface.IDataPack
+	// SkipAuth lets connections through without the authentication handshake.
+	SkipAuth bool
+}
+
+type routerSet struct {
+	apis map[uint32]ziface.IRouter
+	lock sync.RWMutex
+}
+
+func (rs *routerSet) add(msgID uint32, router ziface.IRouter) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if _, ok := rs.apis[msgID]; ok {
+		panic("repeated api, msgID = " + strconv.Itoa(int(msgID)))
+	}
+	rs.apis[msgID] = router
+}
+
+func (rs *routerSet) get(msgID uint32) (ziface.IRouter, bool) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	router, ok := rs.apis[msgID]
+	return router, ok
+}
+
+type serverListener struct {
+	spec      ListenerSpec
+	routers   *routerSet
+	listener  net.Listener
+	eventLoop netpoll.EventLoop
+}
+
+func newServerListener(spec ListenerSpec) *serverListener {
+	l := &serverListener{spec: spec}
+	if spec.Routers != nil {
+		l.routers = &routerSet{apis: make(map[uint32]ziface.IRouter, len(spec.Routers))}
+		for msgID, router := range spec.Routers {
+			l.routers.add(msgID, router)
+		}
+	}
+	return l
+}
+
+// start creates the listener and its own EventLoop; a netpoll EventLoop
+// serves exactly one listener.
+func (l *serverListener) start(s *Server) error {
+	if l.spec.Network == "unix" {
+		// A socket file left behind by a crashed process blocks bind.
+		if fi, err := os.Stat(l.spec.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(l.spec.Address)
+		}
+	}
+
+	listener, err := netpoll.CreateListener(l.spec.Network, l.spec.Address)
+	if err != nil {
+		return fmt.Errorf("listen %s %s: %w", l.spec.Network, l.spec.Address, err)
+	}
+	l.listener = listener
+
+	eventLoop, err := netpoll.NewEventLoop(s.onNetpollRequest,
+		netpoll.WithReadTimeout(time.Duration(s.opts.ReadTimeoutMs)*time.Millisecond),
+		netpoll.WithIdleTimeout(time.Duration(s.opts.IdleTimeoutMs)*time.Millisecond),
+		netpoll.WithOnPrepare(func(conn netpoll.Connection) context.Context {
+			return s.onNetpollPrepare(l, conn)
+		}),
+	)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("create netpoll eventloop for %s: %w", l.spec.Name, err)
+	}
+	l.eventLoop = eventLoop
+
+	go func() {
+		if err := eventLoop.Serve(listener); err != nil && !errors.Is(err, netpoll.ErrConnClosed) {
+			fmt.Printf("[Server] Netpoll Serve error on listener %s: %v\n", l.spec.Name, err)
+		}
+		fmt.Printf("[Server] Netpoll Serve loop for listener %s exited.\n", l.spec.Name)
+	}()
+
+	fmt.Printf("[Server] Listener %s created successfully at %s://%s\n", l.spec.Name, l.spec.Network, l.spec.Address)
+	return nil
+}
+
+func (l *serverListener) stop(ctx context.Context) {
+	if l.eventLoop != nil {
+		if err := l.eventLoop.Shutdown(ctx); err != nil {
+			fmt.Printf("[Server] Netpoll Shutdown error on listener %s: %v\n", l.spec.Name, err)
+		} else {
+			fmt.Printf("[Server] Netpoll EventLoop for listener %s shutdown.\n", l.spec.Name)
+		}
+	} else if l.listener != nil {
+		l.listener.Close()
+	}
+	if l.spec.Network == "unix" && l.listener != nil {
+		// Closing does not unlink the socket file, which would make the
+		// next start fail with "address already in use".
+		if err := os.Remove(l.spec.Address); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("[Server] Remove socket file %s error: %v\n", l.spec.Address, err)
+		}
+	}
+}
+
+func listenerConfigs(specs []ListenerSpec) []config.ListenerConfig {
+	cfgs := make([]config.ListenerConfig, 0, len(specs))
+	for _, spec := range specs {
+		cfgs = append(cfgs, config.ListenerConfig{
+			Name:     spec.Name,
+			Network:  spec.Network,
+			Address:  spec.Address,
+			SkipAuth: spec.SkipAuth,
+		})
+	}
+	return cfgs
+}
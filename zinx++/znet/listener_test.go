@@ -0,0 +1,43 @@
+package znet_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zinxplusplus/znet"
+)
+
+func startUnixServer(t *testing.T, path string) *znet.Server {
+	t.Helper()
+	srv := znet.NewServer(
+		znet.WithName("ZinxUnixTest"),
+		znet.WithListener(znet.ListenerSpec{Name: "local", Network: "unix", Address: path}),
+	).(*znet.Server)
+	srv.AddRouter(msgEcho, &echoRouter{})
+	srv.Start()
+	return srv
+}
+
+func TestUnixListenerRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.sock")
+
+	// Round 0 stops before any client connected, later rounds after serving one.
+	for round := 0; round < 3; round++ {
+		srv := startUnixServer(t, path)
+		if round > 0 {
+			c := connect(t, "unix", path, msgEcho+1)
+			c.send(msgEcho, []byte("hi"))
+			if got := c.expect(msgEcho+1, time.Second); string(got) != "hi" {
+				t.Fatalf("round %d: echo = %q", round, got)
+			}
+			c.client.Stop()
+		}
+		srv.Stop()
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("round %d: socket file left behind after stop: %v", round, err)
+		}
+	}
+}
@@ -2,6 +2,14 @@ package znet
 
 import "zinxplusplus/ziface"
 
+type outboundMsg struct {
+	msgID       uint32
+	data        []byte
+	priority    uint8
+	coalesceKey uint64
+	untracked   bool
+}
+
 type Message struct {
 	Id      uint32
 	DataLen uint32
@@ -17,10 +17,21 @@ type MsgHandle struct {
 	apisLock       sync.RWMutex
 	wg             sync.WaitGroup
 	stopChan       chan struct{}
+
+	selector WorkerSelector
+	actors   *actorDispatcher
+
+	config *config.Store
 }
 
+// NewMsgHandle sizes the worker pool from the global config; servers use
+// their own.
 func NewMsgHandle() ziface.IMsgHandler {
-	poolSize := config.GlobalConfig.Server.WorkerPoolSize
+	return newMsgHandle(config.GlobalStore())
+}
+
+func newMsgHandle(store *config.Store) *MsgHandle {
+	poolSize := store.Load().Server.WorkerPoolSize
 	if poolSize <= 0 {
 
 		fmt.Println("[Warning] WorkerPoolSize is not configured or <= 0, defaulting to 1")
@@ -32,13 +43,35 @@ func NewMsgHandle() ziface.IMsgHandler {
 
 		TaskQueue: make([]chan ziface.IRequest, poolSize),
 		stopChan:  make(chan struct{}),
+		selector:  &connIDSelector{poolSize: poolSize},
+		config:    store,
 	}
 }
 
+// SetDispatchMode picks how requests are spread over workers. It must be
+// called before StartWorkerPool.
+func (mh *MsgHandle) SetDispatchMode(mode string, keyFn DispatchKeyFunc) error {
+	if mode == DispatchActor {
+		mh.actors = newActorDispatcher(mh, keyFn, mh.config.Load().Server.MaxWorkerTaskLen)
+		return nil
+	}
+
+	selector, err := NewWorkerSelector(mode, mh.WorkerPoolSize, keyFn)
+	if err != nil {
+		return err
+	}
+	mh.actors = nil
+	mh.selector = selector
+	return nil
+}
+
+func (mh *MsgHandle) SetWorkerSelector(selector WorkerSelector) {
+	mh.actors = nil
+	mh.selector = selector
+}
+
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
-	mh.apisLock.RLock()
-	handler, ok := mh.Apis[request.GetMsgID()]
-	mh.apisLock.RUnlock()
+	handler, ok := mh.getRouter(request)
 
 	if !ok {
 		fmt.Printf("[MsgHandle] API msgID = %d is not FOUND!\n", request.GetMsgID())
@@ -60,6 +93,19 @@ func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
 	}()
 }
 
+// getRouter resolves the request against the router set of the listener its
+// connection came in on, falling back to the handler's own routers.
+func (mh *MsgHandle) getRouter(request ziface.IRequest) (ziface.IRouter, bool) {
+	if c, ok := request.GetConnection().(*Connection); ok && c.listener != nil && c.listener.routers != nil {
+		return c.listener.routers.get(request.GetMsgID())
+	}
+
+	mh.apisLock.RLock()
+	defer mh.apisLock.RUnlock()
+	handler, ok := mh.Apis[request.GetMsgID()]
+	return handler, ok
+}
+
 func (mh *MsgHandle) AddRouter(msgID uint32, router ziface.IRouter) {
 	mh.apisLock.Lock()
 	defer mh.apisLock.Unlock()
@@ -84,11 +130,16 @@ func (mh *MsgHandle) StartWorkerPool() {
 
 	}
 
+	if mh.actors != nil {
+		fmt.Println("[MsgHandle] Actor dispatch mode, mailboxes are started on demand.")
+		return
+	}
+
 	fmt.Printf("[MsgHandle] Starting Worker Pool (Size: %d)...\n", mh.WorkerPoolSize)
 
 	for i := uint32(0); i < mh.WorkerPoolSize; i++ {
 
-		taskQueueLen := config.GlobalConfig.Server.MaxWorkerTaskLen
+		taskQueueLen := mh.config.Load().Server.MaxWorkerTaskLen
 		if taskQueueLen <= 0 {
 			taskQueueLen = 1024
 		}
@@ -116,6 +167,7 @@ func (mh *MsgHandle) startOneWorker(workerID uint32, taskQueue chan ziface.IRequ
 			if request != nil {
 
 				mh.DoMsgHandler(request)
+				mh.selector.Done(request, workerID)
 			}
 		case <-mh.stopChan:
 			fmt.Printf("[Worker] Worker ID = %d received stop signal, stopping.\n", workerID)
@@ -127,6 +179,7 @@ func (mh *MsgHandle) startOneWorker(workerID uint32, taskQueue chan ziface.IRequ
 						break
 					}
 					mh.DoMsgHandler(request)
+					mh.selector.Done(request, workerID)
 				default:
 					break
 				}
@@ -154,16 +207,22 @@ func (mh *MsgHandle) StopWorkerPool() {
 
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) error {
 
-	connID := request.GetConnection().GetConnID()
-	workerID := uint32(connID % uint64(mh.WorkerPoolSize))
+	timeout := time.Duration(mh.config.Load().Server.SendTaskQueueTimeoutMs) * time.Millisecond
+	if mh.actors != nil {
+		return mh.actors.dispatch(request, timeout)
+	}
+
+	workerID := mh.selector.SelectWorker(request)
 
 	select {
 	case mh.TaskQueue[workerID] <- request:
 		return nil
-	case <-time.After(time.Duration(config.GlobalConfig.Server.SendTaskQueueTimeoutMs) * time.Millisecond):
+	case <-time.After(timeout):
+		mh.selector.Done(request, workerID)
 		return fmt.Errorf("send task queue timeout, WorkerID=%d, queue maybe full", workerID)
 
 	default:
+		mh.selector.Done(request, workerID)
 		return fmt.Errorf("send task queue failed, WorkerID=%d, queue maybe full", workerID)
 	}
 
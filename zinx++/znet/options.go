@@ -2,6 +2,8 @@ package znet
 
 import (
 	"fmt"
+	"zinxplusplus/config"
+	"zinxplusplus/timer"
 	"zinxplusplus/ziface"
 )
 
@@ -28,9 +30,55 @@ type ServerOptions struct {
 	NetpollNumLoops    int
 	NetpollLoadBalance string
 
-	MaxMsgChanLen     uint32
+	// Deprecated: ignored; the outbound queue is bounded by MaxOutQueueBytes.
+	MaxMsgChanLen uint32
+	// Deprecated: ignored; the outbound queue is bounded by MaxOutQueueBytes.
 	MaxMsgBuffChanLen uint32
 
+	MaxOutQueueBytes   int
+	SlowConsumerPolicy string
+	OnSlowConsumer     func(connection ziface.IConnection, stats ziface.ConnSendStats)
+
+	MaxWriteBatchBytes int
+	WriteFlushDelayMs  int
+
+	SessionPolicy     string
+	SessionKickMsgID  uint32
+	SessionKickNotice string
+
+	SessionResumeGraceMs   int
+	SessionReplayBufferLen int
+	OnSessionExpire        func(userID uint64)
+
+	DispatchMode    string
+	DispatchKeyFunc DispatchKeyFunc
+
+	TickIntervalMs  int
+	MaxCatchUpTicks int
+	OnTickOverrun   func(overrun timer.Overrun)
+
+	Admission *config.AdmissionConfig
+
+	Authenticator   ziface.IAuthenticator
+	AuthMsgIDs      []uint32
+	PreAuthMsgIDs   []uint32
+	AuthTimeoutMs   int
+	AuthResultMsgID uint32
+
+	ProxyProtocol        bool
+	TrustedProxyCIDRs    []string
+	ProxyHeaderTimeoutMs int
+
+	Listeners []ListenerSpec
+
+	RecordDir    string
+	RecordFilter func(connection ziface.IConnection) bool
+
+	ConfigLoader         *config.Loader
+	ConfigReload         bool
+	ConfigPath           string
+	ConfigPollIntervalMs int
+
 	OnConnStart func(connection ziface.IConnection)
 	OnConnStop  func(connection ziface.IConnection)
 }
@@ -106,15 +154,162 @@ func WithSendTaskQueueTimeout(timeoutMs int) Option {
 	}
 }
 
+// Deprecated: a no-op; the outbound queue is bounded in bytes, see
+// WithMaxOutQueueBytes.
 func WithMaxMsgChanLen(len uint32) Option {
+	return func(o *ServerOptions) {}
+}
+
+// Deprecated: a no-op; the outbound queue is bounded in bytes, see
+// WithMaxOutQueueBytes.
+func WithMaxMsgBuffChanLen(len uint32) Option {
+	return func(o *ServerOptions) {}
+}
+
+func WithMaxOutQueueBytes(maxBytes int) Option {
 	return func(o *ServerOptions) {
-		o.MaxMsgChanLen = len
+		o.MaxOutQueueBytes = maxBytes
 	}
 }
 
-func WithMaxMsgBuffChanLen(len uint32) Option {
+func WithSlowConsumerPolicy(policy string, hook func(connection ziface.IConnection, stats ziface.ConnSendStats)) Option {
+	return func(o *ServerOptions) {
+		o.SlowConsumerPolicy = policy
+		o.OnSlowConsumer = hook
+	}
+}
+
+func WithWriteBatching(maxBatchBytes int, flushDelayMs int) Option {
+	return func(o *ServerOptions) {
+		o.MaxWriteBatchBytes = maxBatchBytes
+		o.WriteFlushDelayMs = flushDelayMs
+	}
+}
+
+func WithAdmission(cfg config.AdmissionConfig) Option {
 	return func(o *ServerOptions) {
-		o.MaxMsgBuffChanLen = len
+		o.Admission = &cfg
+	}
+}
+
+// WithListener adds an endpoint. Once any listener is given, IPVersion/IP/Port
+// no longer open a default one.
+func WithListener(spec ListenerSpec) Option {
+	return func(o *ServerOptions) {
+		o.Listeners = append(o.Listeners, spec)
+	}
+}
+
+// WithPacketRecording records connections accepted by filter (all when nil)
+// into dir, one file per connection. Meant for chasing down a bug report, not
+// for always-on use.
+func WithPacketRecording(dir string, filter func(ziface.IConnection) bool) Option {
+	return func(o *ServerOptions) {
+		o.RecordDir = dir
+		o.RecordFilter = filter
+	}
+}
+
+// WithConfigLoader layers the loader's file, environment and flags over the
+// settings made by the other options, so operators can override what the
+// code sets. Without it NewServer uses config.DefaultLoader, if any.
+func WithConfigLoader(loader *config.Loader) Option {
+	return func(o *ServerOptions) {
+		o.ConfigLoader = loader
+	}
+}
+
+// WithConfigReload watches the config file and applies changes while running;
+// SIGHUP reloads it immediately instead of stopping the server. path is used
+// when no loader is configured, otherwise the loader's file is watched.
+// pollIntervalMs <= 0 disables polling so only SIGHUP reloads.
+func WithConfigReload(path string, pollIntervalMs int) Option {
+	return func(o *ServerOptions) {
+		o.ConfigReload = true
+		o.ConfigPath = path
+		o.ConfigPollIntervalMs = pollIntervalMs
+	}
+}
+
+// WithProxyProtocol expects a PROXY protocol v1/v2 header from peers inside
+// trustedCIDRs and uses the client address it carries. Peers outside the list
+// are treated as direct clients, so they cannot spoof their address.
+func WithProxyProtocol(trustedCIDRs []string, headerTimeoutMs int) Option {
+	return func(o *ServerOptions) {
+		o.ProxyProtocol = true
+		o.TrustedProxyCIDRs = trustedCIDRs
+		if headerTimeoutMs > 0 {
+			o.ProxyHeaderTimeoutMs = headerTimeoutMs
+		}
+	}
+}
+
+// WithAuthenticator enables the handshake stage: until authenticator accepts
+// the connection, only authMsgIDs (sent to the authenticator) and the
+// pre-auth whitelist reach the worker pool.
+func WithAuthenticator(authenticator ziface.IAuthenticator, authMsgIDs []uint32, timeoutMs int) Option {
+	return func(o *ServerOptions) {
+		o.Authenticator = authenticator
+		o.AuthMsgIDs = authMsgIDs
+		o.AuthTimeoutMs = timeoutMs
+	}
+}
+
+func WithPreAuthMsgIDs(msgIDs ...uint32) Option {
+	return func(o *ServerOptions) {
+		o.PreAuthMsgIDs = append(o.PreAuthMsgIDs, msgIDs...)
+	}
+}
+
+func WithAuthResultMsgID(msgID uint32) Option {
+	return func(o *ServerOptions) {
+		o.AuthResultMsgID = msgID
+	}
+}
+
+func WithSessionPolicy(policy string) Option {
+	return func(o *ServerOptions) {
+		o.SessionPolicy = policy
+	}
+}
+
+func WithSessionKickNotice(msgID uint32, notice string) Option {
+	return func(o *ServerOptions) {
+		o.SessionKickMsgID = msgID
+		o.SessionKickNotice = notice
+	}
+}
+
+func WithSessionResume(graceMs int, replayBufferLen int) Option {
+	return func(o *ServerOptions) {
+		o.SessionResumeGraceMs = graceMs
+		o.SessionReplayBufferLen = replayBufferLen
+	}
+}
+
+func WithOnSessionExpire(hook func(userID uint64)) Option {
+	return func(o *ServerOptions) {
+		o.OnSessionExpire = hook
+	}
+}
+
+func WithDispatchMode(mode string, keyFn DispatchKeyFunc) Option {
+	return func(o *ServerOptions) {
+		o.DispatchMode = mode
+		o.DispatchKeyFunc = keyFn
+	}
+}
+
+func WithTick(intervalMs int, maxCatchUpTicks int) Option {
+	return func(o *ServerOptions) {
+		o.TickIntervalMs = intervalMs
+		o.MaxCatchUpTicks = maxCatchUpTicks
+	}
+}
+
+func WithOnTickOverrun(hook func(overrun timer.Overrun)) Option {
+	return func(o *ServerOptions) {
+		o.OnTickOverrun = hook
 	}
 }
 
@@ -145,18 +340,36 @@ func newOptions(opts ...Option) *ServerOptions {
 		IdleTimeoutMs:          600000,
 		SendMsgTimeoutMs:       3000,
 		SendTaskQueueTimeoutMs: 100,
-		MaxMsgChanLen:          1,
-		MaxMsgBuffChanLen:      1024,
+		MaxOutQueueBytes:       1 << 20,
+		SlowConsumerPolicy:     SlowConsumerDropLowest,
+		MaxWriteBatchBytes:     64 * 1024,
+		WriteFlushDelayMs:      0,
 		OnConnStart:            nil,
 		OnConnStop:             nil,
 		NetpollNumLoops:        0,
 		NetpollLoadBalance:     "round-robin",
+		SessionPolicy:          SessionPolicyKickOld,
+		SessionKickMsgID:       0,
+		SessionKickNotice:      "logged in from another location",
+		SessionResumeGraceMs:   0,
+		SessionReplayBufferLen: 256,
+		DispatchMode:           DispatchConnID,
+		TickIntervalMs:         50,
+		MaxCatchUpTicks:        5,
+		AuthTimeoutMs:          10000,
+		ProxyHeaderTimeoutMs:   5000,
 	}
 
 	for _, o := range opts {
 		o(opt)
 	}
 
+	for i := range opt.Listeners {
+		if opt.Listeners[i].Name == "" {
+			opt.Listeners[i].Name = config.DefaultListenerName(i)
+		}
+	}
+
 	if opt.WorkerPoolSize == 0 {
 		opt.WorkerPoolSize = 1
 		fmt.Println("[Options] Warning: WorkerPoolSize configured to 0, defaulting to 1.")
@@ -164,3 +377,121 @@ func newOptions(opts ...Option) *ServerOptions {
 
 	return opt
 }
+
+// addDefaultListener opens a listener on IPVersion/IP:Port when none was
+// given. It runs after the config layers are applied so a port set in the
+// file is honoured.
+func (o *ServerOptions) addDefaultListener() {
+	if len(o.Listeners) == 0 {
+		o.Listeners = []ListenerSpec{{
+			Name:    "default",
+			Network: o.IPVersion,
+			Address: fmt.Sprintf("%s:%d", o.IP, o.Port),
+		}}
+	}
+}
+
+// serverConfig mirrors the options into the config section.
+func (o *ServerOptions) serverConfig() config.ServerConfig {
+	return config.ServerConfig{
+		Name:                   o.Name,
+		IPVersion:              o.IPVersion,
+		IP:                     o.IP,
+		Port:                   o.Port,
+		MaxConn:                o.MaxConn,
+		MaxPacketSize:          o.MaxPacketSize,
+		WorkerPoolSize:         o.WorkerPoolSize,
+		MaxWorkerTaskLen:       o.MaxWorkerTaskLen,
+		ReadTimeoutMs:          o.ReadTimeoutMs,
+		IdleTimeoutMs:          o.IdleTimeoutMs,
+		WriteTimeoutMs:         o.WriteTimeoutMs,
+		SendMsgTimeoutMs:       o.SendMsgTimeoutMs,
+		SendTaskQueueTimeoutMs: o.SendTaskQueueTimeoutMs,
+		MaxOutQueueBytes:       o.MaxOutQueueBytes,
+		SlowConsumerPolicy:     o.SlowConsumerPolicy,
+		MaxWriteBatchBytes:     o.MaxWriteBatchBytes,
+		WriteFlushDelayMs:      o.WriteFlushDelayMs,
+		NetpollNumLoops:        o.NetpollNumLoops,
+		NetpollLoadBalance:     o.NetpollLoadBalance,
+		SessionPolicy:          o.SessionPolicy,
+		SessionKickMsgID:       o.SessionKickMsgID,
+		SessionKickNotice:      o.SessionKickNotice,
+		SessionResumeGraceMs:   o.SessionResumeGraceMs,
+		SessionReplayBufferLen: o.SessionReplayBufferLen,
+		DispatchMode:           o.DispatchMode,
+		TickIntervalMs:         o.TickIntervalMs,
+		MaxCatchUpTicks:        o.MaxCatchUpTicks,
+		AuthTimeoutMs:          o.AuthTimeoutMs,
+		ProxyProtocol:          o.ProxyProtocol,
+		TrustedProxyCIDRs:      o.TrustedProxyCIDRs,
+		ProxyHeaderTimeoutMs:   o.ProxyHeaderTimeoutMs,
+		Listeners:              listenerConfigs(o.Listeners),
+		RecordDir:              o.RecordDir,
+	}
+}
+
+// baseConfig is the layer the config loader starts from: the options for the
+// server section and the active config for the others.
+func (o *ServerOptions) baseConfig() *config.Config {
+	cfg := config.Global().Clone()
+	cfg.Server = o.serverConfig()
+	if o.Admission != nil {
+		cfg.Admission = *o.Admission
+	}
+	return cfg
+}
+
+// applyServerConfig is the inverse of serverConfig, used after the config
+// layers ran. Listeners keep the routers and framing of the spec with the same
+// name.
+func (o *ServerOptions) applyServerConfig(c config.ServerConfig) {
+	o.Name = c.Name
+	o.IPVersion = c.IPVersion
+	o.IP = c.IP
+	o.Port = c.Port
+	o.MaxConn = c.MaxConn
+	o.MaxPacketSize = c.MaxPacketSize
+	o.WorkerPoolSize = c.WorkerPoolSize
+	o.MaxWorkerTaskLen = c.MaxWorkerTaskLen
+	o.ReadTimeoutMs = c.ReadTimeoutMs
+	o.IdleTimeoutMs = c.IdleTimeoutMs
+	o.WriteTimeoutMs = c.WriteTimeoutMs
+	o.SendMsgTimeoutMs = c.SendMsgTimeoutMs
+	o.SendTaskQueueTimeoutMs = c.SendTaskQueueTimeoutMs
+	o.MaxOutQueueBytes = c.MaxOutQueueBytes
+	o.SlowConsumerPolicy = c.SlowConsumerPolicy
+	o.MaxWriteBatchBytes = c.MaxWriteBatchBytes
+	o.WriteFlushDelayMs = c.WriteFlushDelayMs
+	o.NetpollNumLoops = c.NetpollNumLoops
+	o.NetpollLoadBalance = c.NetpollLoadBalance
+	o.SessionPolicy = c.SessionPolicy
+	o.SessionKickMsgID = c.SessionKickMsgID
+	o.SessionKickNotice = c.SessionKickNotice
+	o.SessionResumeGraceMs = c.SessionResumeGraceMs
+	o.SessionReplayBufferLen = c.SessionReplayBufferLen
+	o.DispatchMode = c.DispatchMode
+	o.TickIntervalMs = c.TickIntervalMs
+	o.MaxCatchUpTicks = c.MaxCatchUpTicks
+	o.AuthTimeoutMs = c.AuthTimeoutMs
+	o.ProxyProtocol = c.ProxyProtocol
+	o.TrustedProxyCIDRs = c.TrustedProxyCIDRs
+	o.ProxyHeaderTimeoutMs = c.ProxyHeaderTimeoutMs
+	o.RecordDir = c.RecordDir
+
+	specs := make([]ListenerSpec, 0, len(c.Listeners))
+	for i, lc := range c.Listeners {
+		if lc.Name == "" {
+			lc.Name = config.DefaultListenerName(i)
+		}
+		spec := ListenerSpec{Name: lc.Name}
+		for _, existing := range o.Listeners {
+			if existing.Name == lc.Name {
+				spec = existing
+				break
+			}
+		}
+		spec.Network, spec.Address, spec.SkipAuth = lc.Network, lc.Address, lc.SkipAuth
+		specs = append(specs, spec)
+	}
+	o.Listeners = specs
+}
@@ -0,0 +1,256 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+const (
+	PriorityCritical uint8 = iota
+	PriorityGameplay
+	PriorityBulk
+
+	numPriorities = 3
+
+	SlowConsumerDropLowest = "drop-lowest"
+	SlowConsumerDisconnect = "disconnect"
+	SlowConsumerCallback   = "callback"
+)
+
+var (
+	ErrSendQueueFull = errors.New("send queue byte limit exceeded")
+	ErrSlowConsumer  = errors.New("slow consumer disconnected")
+)
+
+// outQueue buffers packed messages for one connection in three priority
+// classes. Within a class messages keep their order; the writer always drains
+// the most important non-empty class first. Critical messages are never
+// refused or evicted so control traffic cannot be starved by gameplay.
+type outQueue struct {
+	classes   [numPriorities][]*outboundMsg
+	coalesced map[uint64]*outboundMsg
+	bytes     int
+	maxBytes  int
+	policy    string
+
+	ready chan struct{}
+	space chan struct{}
+
+	stats  ziface.ConnSendStats
+	closed bool
+	lock   sync.Mutex
+}
+
+func newOutQueue(maxBytes int, policy string) *outQueue {
+	switch policy {
+	case SlowConsumerDisconnect, SlowConsumerCallback:
+	default:
+		policy = SlowConsumerDropLowest
+	}
+	return &outQueue{
+		coalesced: make(map[uint64]*outboundMsg),
+		maxBytes:  maxBytes,
+		policy:    policy,
+		ready:     make(chan struct{}, 1),
+		space:     make(chan struct{}),
+	}
+}
+
+// push queues out, waiting up to wait for room before the slow-consumer
+// policy applies. It reports whether the policy was triggered.
+func (q *outQueue) push(out *outboundMsg, wait time.Duration, exit <-chan struct{}) (bool, error) {
+	var deadline <-chan time.Time
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return false, errors.New("connection closed when send msg")
+		}
+		if q.tryPushLocked(out) {
+			q.lock.Unlock()
+			q.signalReady()
+			return false, nil
+		}
+		if wait <= 0 {
+			break
+		}
+		space := q.space
+		q.lock.Unlock()
+
+		if deadline == nil {
+			deadline = time.After(wait)
+		}
+		select {
+		case <-space:
+			continue
+		case <-deadline:
+			wait = 0
+			continue
+		case <-exit:
+			return false, errors.New("connection closed when send msg")
+		}
+	}
+	defer q.lock.Unlock()
+
+	q.stats.SlowConsumerEvents++
+	if q.policy == SlowConsumerDropLowest && q.evictLocked(out) && q.tryPushLocked(out) {
+		q.signalReady()
+		return true, nil
+	}
+
+	q.stats.DroppedMsgs++
+	q.stats.DroppedBytes += uint64(len(out.data))
+	return true, fmt.Errorf("%w: msgId=%d, queued=%d bytes, limit=%d bytes", ErrSendQueueFull, out.msgID, q.bytes, q.maxBytes)
+}
+
+func (q *outQueue) tryPushLocked(out *outboundMsg) bool {
+	if out.coalesceKey != 0 {
+		if prev, ok := q.coalesced[out.coalesceKey]; ok && prev.priority == out.priority {
+			delta := len(out.data) - len(prev.data)
+			if delta <= 0 || q.fitsLocked(out.priority, delta) {
+				prev.msgID = out.msgID
+				prev.data = out.data
+				q.bytes += delta
+				q.stats.QueuedBytes += delta
+				q.stats.CoalescedMsgs++
+				return true
+			}
+			return false
+		}
+	}
+	if !q.fitsLocked(out.priority, len(out.data)) {
+		return false
+	}
+	q.appendLocked(out)
+	return true
+}
+
+func (q *outQueue) fitsLocked(priority uint8, size int) bool {
+	return priority == PriorityCritical || q.maxBytes <= 0 || q.bytes == 0 || q.bytes+size <= q.maxBytes
+}
+
+func (q *outQueue) appendLocked(out *outboundMsg) {
+	q.classes[out.priority] = append(q.classes[out.priority], out)
+	if out.coalesceKey != 0 {
+		q.coalesced[out.coalesceKey] = out
+	}
+	q.bytes += len(out.data)
+	q.stats.QueuedMsgs++
+	q.stats.QueuedBytes += len(out.data)
+}
+
+// evictLocked drops the oldest messages of classes no more important than
+// out, least important first, until out fits. It drops nothing when that
+// cannot free enough.
+func (q *outQueue) evictLocked(out *outboundMsg) bool {
+	need := q.bytes + len(out.data) - q.maxBytes
+	lowest := int(out.priority)
+	if lowest == int(PriorityCritical) {
+		lowest++
+	}
+	freeable := 0
+	for p := lowest; p < numPriorities; p++ {
+		for _, victim := range q.classes[p] {
+			freeable += len(victim.data)
+		}
+	}
+	if freeable < need {
+		return false
+	}
+
+	for p := numPriorities - 1; p >= lowest && need > 0; p-- {
+		for len(q.classes[p]) > 0 && need > 0 {
+			victim := q.classes[p][0]
+			q.classes[p] = q.classes[p][1:]
+			q.removeLocked(victim)
+			q.stats.DroppedMsgs++
+			q.stats.DroppedBytes += uint64(len(victim.data))
+			need -= len(victim.data)
+		}
+	}
+	return need <= 0
+}
+
+func (q *outQueue) removeLocked(out *outboundMsg) {
+	if out.coalesceKey != 0 && q.coalesced[out.coalesceKey] == out {
+		delete(q.coalesced, out.coalesceKey)
+	}
+	q.bytes -= len(out.data)
+	q.stats.QueuedMsgs--
+	q.stats.QueuedBytes -= len(out.data)
+}
+
+// popBatch moves queued messages into dst in write order until the next one
+// would push the batch past maxBytes. It always takes at least one message.
+func (q *outQueue) popBatch(maxBytes int, dst []*outboundMsg) []*outboundMsg {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	size := 0
+	for p := 0; p < numPriorities; p++ {
+		for len(q.classes[p]) > 0 {
+			out := q.classes[p][0]
+			if len(dst) > 0 && maxBytes > 0 && size+len(out.data) > maxBytes {
+				q.notifySpaceLocked(len(dst))
+				return dst
+			}
+			q.classes[p][0] = nil
+			q.classes[p] = q.classes[p][1:]
+			q.removeLocked(out)
+			dst = append(dst, out)
+			size += len(out.data)
+		}
+	}
+	q.notifySpaceLocked(len(dst))
+	return dst
+}
+
+func (q *outQueue) notifySpaceLocked(popped int) {
+	if popped == 0 {
+		return
+	}
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+// close refuses further pushes and returns what was still queued, in write
+// order.
+func (q *outQueue) close() []*outboundMsg {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	return q.popBatch(0, nil)
+}
+
+func (q *outQueue) pendingBytes() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.bytes
+}
+
+func (q *outQueue) markSent(batch []*outboundMsg) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, out := range batch {
+		q.stats.SentMsgs++
+		q.stats.SentBytes += uint64(len(out.data))
+	}
+	q.stats.Flushes++
+}
+
+func (q *outQueue) signalReady() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *outQueue) snapshot() ziface.ConnSendStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.stats
+}
@@ -0,0 +1,75 @@
+package znet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// countingWriter counts Write calls; a netpoll writer issues one per Flush,
+// so this is the number of write syscalls a socket would see.
+type countingWriter struct {
+	writes int
+	bytes  int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+// BenchmarkOutQueueBroadcast queues one broadcast tick (fanout messages of
+// 64 bytes) per iteration and drains it the way the writer does. maxBatch=1
+// writes every message on its own, which is what the writer did before
+// batching; writes/op is the syscall count per tick.
+func BenchmarkOutQueueBroadcast(b *testing.B) {
+	for _, fanout := range []int{16, 256} {
+		for _, maxBatch := range []int{1, 4 << 10, 64 << 10} {
+			b.Run(fmt.Sprintf("fanout=%d/maxBatch=%d", fanout, maxBatch), func(b *testing.B) {
+				benchmarkBroadcast(b, fanout, maxBatch)
+			})
+		}
+	}
+}
+
+func benchmarkBroadcast(b *testing.B, fanout, maxBatch int) {
+	dp := NewDataPack()
+	c := &Connection{dataPack: dp, outQueue: newOutQueue(0, "")}
+	sink := &countingWriter{}
+	writer := netpoll.NewWriter(sink)
+
+	payload := make([]byte, 64)
+	msgs := make([][]byte, fanout)
+	for i := range msgs {
+		packed, err := dp.Pack(NewMsgPackage(uint32(i), payload))
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgs[i] = packed
+	}
+	batch := make([]*outboundMsg, 0, 64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, data := range msgs {
+			if _, err := c.outQueue.push(&outboundMsg{msgID: uint32(j), data: data, priority: PriorityGameplay}, 0, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for {
+			batch = c.outQueue.popBatch(maxBatch, batch[:0])
+			if len(batch) == 0 {
+				break
+			}
+			if err := c.writeBatch(writer, batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(sink.writes)/float64(b.N), "writes/op")
+	b.SetBytes(int64(sink.bytes / b.N))
+}
@@ -0,0 +1,141 @@
+package znet
+
+import (
+	"errors"
+	"testing"
+)
+
+func queued(msgID uint32, size int, priority uint8) *outboundMsg {
+	return &outboundMsg{msgID: msgID, data: make([]byte, size), priority: priority}
+}
+
+func popIDs(q *outQueue) []uint32 {
+	var ids []uint32
+	for _, out := range q.popBatch(0, nil) {
+		ids = append(ids, out.msgID)
+	}
+	return ids
+}
+
+func equalIDs(got, want []uint32) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOutQueuePriorityOrder(t *testing.T) {
+	q := newOutQueue(0, "")
+	for _, out := range []*outboundMsg{
+		queued(1, 10, PriorityBulk),
+		queued(2, 10, PriorityGameplay),
+		queued(3, 10, PriorityCritical),
+		queued(4, 10, PriorityGameplay),
+		queued(5, 10, PriorityCritical),
+	} {
+		if _, err := q.push(out, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := popIDs(q), []uint32{3, 5, 2, 4, 1}; !equalIDs(got, want) {
+		t.Fatalf("write order = %v, want %v", got, want)
+	}
+}
+
+func TestOutQueueCoalesce(t *testing.T) {
+	q := newOutQueue(0, "")
+	first := queued(1, 10, PriorityGameplay)
+	first.coalesceKey = 7
+	second := queued(2, 20, PriorityGameplay)
+	second.coalesceKey = 7
+	for _, out := range []*outboundMsg{first, queued(3, 5, PriorityGameplay), second} {
+		if _, err := q.push(out, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := q.snapshot(); stats.QueuedMsgs != 2 || stats.QueuedBytes != 25 || stats.CoalescedMsgs != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	// The replacement keeps the queue position of the message it replaced.
+	if got, want := popIDs(q), []uint32{2, 3}; !equalIDs(got, want) {
+		t.Fatalf("write order = %v, want %v", got, want)
+	}
+}
+
+func TestOutQueueByteLimit(t *testing.T) {
+	q := newOutQueue(100, SlowConsumerDisconnect)
+	if _, err := q.push(queued(1, 80, PriorityGameplay), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	slow, err := q.push(queued(2, 40, PriorityGameplay), 0, nil)
+	if !slow || !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("push over the limit: slow=%v err=%v", slow, err)
+	}
+	// Control traffic is never refused.
+	if _, err := q.push(queued(3, 40, PriorityCritical), 0, nil); err != nil {
+		t.Fatalf("critical push over the limit: %v", err)
+	}
+	if stats := q.snapshot(); stats.QueuedBytes != 120 || stats.DroppedMsgs != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestOutQueueDropLowest(t *testing.T) {
+	q := newOutQueue(100, SlowConsumerDropLowest)
+	for _, out := range []*outboundMsg{
+		queued(1, 40, PriorityBulk),
+		queued(2, 40, PriorityGameplay),
+	} {
+		if _, err := q.push(out, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slow, err := q.push(queued(3, 40, PriorityGameplay), 0, nil)
+	if !slow || err != nil {
+		t.Fatalf("push evicting bulk: slow=%v err=%v", slow, err)
+	}
+	if got, want := popIDs(q), []uint32{2, 3}; !equalIDs(got, want) {
+		t.Fatalf("write order = %v, want %v", got, want)
+	}
+}
+
+func TestOutQueueClose(t *testing.T) {
+	q := newOutQueue(0, "")
+	if _, err := q.push(queued(1, 10, PriorityGameplay), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rest := q.close(); len(rest) != 1 || rest[0].msgID != 1 {
+		t.Fatalf("close returned %+v", rest)
+	}
+	if _, err := q.push(queued(2, 10, PriorityCritical), 0, nil); err == nil {
+		t.Fatal("push after close succeeded")
+	}
+}
+
+func TestOutQueueDropLowestKeepsQueueWhenItCannotFit(t *testing.T) {
+	q := newOutQueue(100, SlowConsumerDropLowest)
+	for _, out := range []*outboundMsg{
+		queued(1, 50, PriorityGameplay),
+		queued(2, 20, PriorityBulk),
+	} {
+		if _, err := q.push(out, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A bulk message may only evict bulk, and that frees too little.
+	slow, err := q.push(queued(3, 60, PriorityBulk), 0, nil)
+	if !slow || !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("push: slow=%v err=%v", slow, err)
+	}
+	if got, want := popIDs(q), []uint32{1, 2}; !equalIDs(got, want) {
+		t.Fatalf("queue after refused push = %v, want %v", got, want)
+	}
+	if stats := q.snapshot(); stats.DroppedMsgs != 1 {
+		t.Fatalf("dropped %d msgs, want only the refused one", stats.DroppedMsgs)
+	}
+}
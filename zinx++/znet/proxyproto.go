@@ -0,0 +1,124 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var ErrBadProxyHeader = errors.New("invalid PROXY protocol header")
+
+const (
+	proxyV1MaxLen    = 107
+	proxyV2HeaderLen = 16
+	// Largest v2 payload we accept; real headers with TLVs stay far below it.
+	proxyV2MaxPayload = 2048
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from r and
+// returns the original client address. A nil address means the header was
+// valid but carries no usable client (v1 UNKNOWN, v2 LOCAL or non-TCP
+// families); the caller keeps the peer address then.
+func readProxyHeader(r netpoll.Reader) (net.Addr, error) {
+	// 12 bytes covers the v2 signature and is shorter than any v1 header.
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		return readProxyV1(r)
+	}
+	return nil, fmt.Errorf("%w: missing signature", ErrBadProxyHeader)
+}
+
+func readProxyV1(r netpoll.Reader) (net.Addr, error) {
+	var line []byte
+	for n := len(proxyV2Signature); ; n++ {
+		if n > proxyV1MaxLen {
+			return nil, fmt.Errorf("%w: v1 header longer than %d bytes", ErrBadProxyHeader, proxyV1MaxLen)
+		}
+		buf, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(buf, []byte("\r\n")) {
+			line = buf
+			break
+		}
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if err := r.Skip(len(line)); err != nil {
+		return nil, err
+	}
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: v1 %q", ErrBadProxyHeader, line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: v1 source %s:%s", ErrBadProxyHeader, fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r netpoll.Reader) (net.Addr, error) {
+	hdr, err := r.Peek(proxyV2HeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	verCmd, family := hdr[12], hdr[13]
+	size := int(binary.BigEndian.Uint16(hdr[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 version %d", ErrBadProxyHeader, verCmd>>4)
+	}
+	if size > proxyV2MaxPayload {
+		return nil, fmt.Errorf("%w: v2 payload %d bytes", ErrBadProxyHeader, size)
+	}
+
+	raw, err := r.Next(proxyV2HeaderLen + size)
+	if err != nil {
+		return nil, err
+	}
+	payload := raw[proxyV2HeaderLen:]
+
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL: health check from the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: v2 command %d", ErrBadProxyHeader, verCmd&0x0F)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("%w: v2 short IPv4 block", ErrBadProxyHeader)
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, payload[0:4])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("%w: v2 short IPv6 block", ErrBadProxyHeader)
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, payload[0:16])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,204 @@
+package znet_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+// wireConn speaks the default framing over a plain socket, for tests that
+// must put bytes on the wire no Client would send, like a PROXY header.
+type wireConn struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dial(t *testing.T, network, addr string) *wireConn {
+	t.Helper()
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wireConn{t: t, conn: conn}
+}
+
+func (w *wireConn) write(raw []byte) {
+	w.t.Helper()
+	if _, err := w.conn.Write(raw); err != nil {
+		w.t.Fatalf("write: %v", err)
+	}
+}
+
+func (w *wireConn) send(msgID uint32, data []byte) {
+	w.t.Helper()
+	frame := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:8], msgID)
+	copy(frame[8:], data)
+	w.write(frame)
+}
+
+// recv reads the next frame; ok is false on timeout or when the server closed
+// the connection.
+func (w *wireConn) recv(timeout time.Duration) (msgID uint32, data []byte, ok bool) {
+	_ = w.conn.SetReadDeadline(time.Now().Add(timeout))
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(w.conn, head); err != nil {
+		return 0, nil, false
+	}
+	data = make([]byte, binary.LittleEndian.Uint32(head[0:4]))
+	if _, err := io.ReadFull(w.conn, data); err != nil {
+		return 0, nil, false
+	}
+	return binary.LittleEndian.Uint32(head[4:8]), data, true
+}
+
+func (w *wireConn) expect(msgID uint32, timeout time.Duration) []byte {
+	w.t.Helper()
+	got, data, ok := w.recv(timeout)
+	if !ok {
+		w.t.Fatalf("no message %d within %v", msgID, timeout)
+	}
+	if got != msgID {
+		w.t.Fatalf("got message %d (%q), want %d", got, data, msgID)
+	}
+	return data
+}
+
+// closed reports whether the server closed the connection within timeout.
+func (w *wireConn) closed(timeout time.Duration) bool {
+	_ = w.conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 64)
+	for {
+		if _, err := w.conn.Read(buf); err != nil {
+			ne, isNet := err.(net.Error)
+			return !(isNet && ne.Timeout())
+		}
+	}
+}
+
+const msgWhoAmI uint32 = 20
+
+// addrRouter replies with the client address the connection reports.
+type addrRouter struct {
+	znet.BaseRouter
+}
+
+func (r *addrRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	_ = conn.SendMsg(request.GetMsgID()+1, []byte(conn.RemoteAddr().String()))
+}
+
+func startProxyServer(t *testing.T) string {
+	srv, addr := startServer(t,
+		znet.WithProxyProtocol([]string{"127.0.0.0/8"}, 1000),
+		znet.WithReadTimeout(200),
+	)
+	srv.AddRouter(msgWhoAmI, &addrRouter{})
+	return addr
+}
+
+func TestProxyHeaderSetsClientAddr(t *testing.T) {
+	c := dial(t, "tcp", startProxyServer(t))
+
+	c.write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 8999\r\n"))
+	c.send(msgWhoAmI, nil)
+	if got := string(c.expect(msgWhoAmI+1, time.Second)); got != "203.0.113.7:40000" {
+		t.Fatalf("RemoteAddr = %s, want the address from the PROXY header", got)
+	}
+}
+
+func TestProxyHeaderKeepsReadTimeout(t *testing.T) {
+	c := dial(t, "tcp", startProxyServer(t))
+
+	c.write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 8999\r\n"))
+	// A frame header announcing 10 bytes, followed by only 2 of them.
+	c.write([]byte{10, 0, 0, 0, byte(msgWhoAmI), 0, 0, 0, 'h', 'i'})
+	if !c.closed(3 * time.Second) {
+		t.Fatal("stalled proxied connection was not closed by the read timeout")
+	}
+}
+
+func proxyV2Header(src net.IP, srcPort uint16) []byte {
+	hdr := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	hdr = append(hdr, 0x21, 0x11, 0, 12) // v2 PROXY, TCP over IPv4, 12 byte block
+	hdr = append(hdr, src.To4()...)
+	hdr = append(hdr, 127, 0, 0, 1)
+	hdr = binary.BigEndian.AppendUint16(hdr, srcPort)
+	hdr = binary.BigEndian.AppendUint16(hdr, 8999)
+	return hdr
+}
+
+func TestProxyHeaderV2SetsClientAddr(t *testing.T) {
+	c := dial(t, "tcp", startProxyServer(t))
+
+	c.write(proxyV2Header(net.ParseIP("198.51.100.9"), 51000))
+	c.send(msgWhoAmI, nil)
+	if got := string(c.expect(msgWhoAmI+1, time.Second)); got != "198.51.100.9:51000" {
+		t.Fatalf("RemoteAddr = %s, want the address from the v2 header", got)
+	}
+}
+
+func TestProxyHeaderFromUntrustedPeerIsNotHonoured(t *testing.T) {
+	srv, addr := startServer(t, znet.WithProxyProtocol([]string{"10.0.0.0/8"}, 1000))
+	srv.AddRouter(msgWhoAmI, &addrRouter{})
+
+	// Without a header the untrusted peer is served under its own address.
+	plain := connect(t, "tcp", addr, msgWhoAmI+1)
+	plain.send(msgWhoAmI, nil)
+	if got := string(plain.expect(msgWhoAmI+1, time.Second)); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Fatalf("RemoteAddr = %s, want the socket peer", got)
+	}
+
+	// A header is just malformed framing from a peer that is not a proxy.
+	spoof := dial(t, "tcp", addr)
+	spoof.write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 8999\r\n"))
+	spoof.send(msgWhoAmI, nil)
+	if msgID, data, ok := spoof.recv(500 * time.Millisecond); ok {
+		t.Fatalf("spoofed header was accepted, got reply %d %q", msgID, data)
+	}
+	if !spoof.closed(2 * time.Second) {
+		t.Fatal("connection with a PROXY header from an untrusted peer stayed open")
+	}
+}
+
+// startedRouter replies with the marker OnConnStart stored on the connection.
+type startedRouter struct {
+	znet.BaseRouter
+}
+
+func (r *startedRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	v, err := conn.GetProperty("started")
+	if err != nil {
+		v = "no"
+	}
+	_ = conn.SendMsg(request.GetMsgID()+1, []byte(v.(string)))
+}
+
+func TestProxyHeaderStartsBeforeDispatch(t *testing.T) {
+	srv, addr := startServer(t,
+		znet.WithProxyProtocol([]string{"127.0.0.0/8"}, 1000),
+		znet.WithWorkerPoolSize(0),
+		znet.WithOnConnStart(func(conn ziface.IConnection) {
+			time.Sleep(50 * time.Millisecond)
+			conn.SetProperty("started", "yes")
+		}),
+	)
+	srv.AddRouter(msgWhoAmI, &startedRouter{})
+
+	c := dial(t, "tcp", addr)
+	frame := []byte("PROXY TCP4 203.0.113.7 127.0.0.1 40000 8999\r\n")
+	frame = append(frame, 0, 0, 0, 0, byte(msgWhoAmI), 0, 0, 0)
+	c.write(frame) // header and first frame in one segment
+	if got := string(c.expect(msgWhoAmI+1, time.Second)); got != "yes" {
+		t.Fatalf("handler ran before OnConnStart (started = %s)", got)
+	}
+}
@@ -0,0 +1,250 @@
+package znet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"zinxplusplus/config"
+)
+
+/*
+Packet recording file format (all integers little-endian):
+
+	file    := header record*
+	header  := magic[8] version:u16 connID:u64 startUnixNano:i64 addrLen:u16 remoteAddr[addrLen]
+	record  := unixNano:i64 direction:u8 msgID:u32 dataLen:u32 data[dataLen]
+
+magic is "ZINXREC\x00" and version is 1. direction is relative to the side
+that recorded: 0 for frames it received (client requests in a server-side
+recording) and 1 for frames it sent. data is the message body without the
+DataPack header. A file cut short by a crash is valid up to its last complete
+record.
+*/
+
+const (
+	RecordInbound  uint8 = 0
+	RecordOutbound uint8 = 1
+
+	recordVersion    uint16 = 1
+	recordHeaderSize        = 8 + 1 + 4 + 4
+
+	// DefaultMaxOutboundRecordLen is the PacketReader limit for frames the
+	// recording side sent. DataPack.Pack does not apply MaxPacketSize to
+	// those, so they get a limit that only stops corrupt lengths.
+	DefaultMaxOutboundRecordLen uint32 = 64 << 20
+)
+
+var (
+	recordMagic = [8]byte{'Z', 'I', 'N', 'X', 'R', 'E', 'C', 0}
+
+	ErrNotRecording     = errors.New("connection is not being recorded")
+	ErrAlreadyRecording = errors.New("connection is already being recorded")
+	ErrBadRecording     = errors.New("not a packet recording")
+	ErrRecordTooLarge   = errors.New("recorded frame exceeds the reader's size limit")
+)
+
+type RecordHeader struct {
+	Version    uint16
+	ConnID     uint64
+	StartedAt  time.Time
+	RemoteAddr string
+}
+
+type PacketRecord struct {
+	Time      time.Time
+	Direction uint8
+	MsgID     uint32
+	Data      []byte
+}
+
+// PacketRecorder appends frames of one connection to a recording. It is safe
+// for concurrent use by the reader and writer goroutines.
+type PacketRecorder struct {
+	w      *bufio.Writer
+	closer io.Closer
+	lock   sync.Mutex
+	err    error
+}
+
+func NewPacketRecorder(w io.Writer, connID uint64, remoteAddr string) (*PacketRecorder, error) {
+	r := &PacketRecorder{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+
+	hdr := make([]byte, 0, 8+2+8+8+2+len(remoteAddr))
+	hdr = append(hdr, recordMagic[:]...)
+	hdr = binary.LittleEndian.AppendUint16(hdr, recordVersion)
+	hdr = binary.LittleEndian.AppendUint64(hdr, connID)
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(time.Now().UnixNano()))
+	hdr = binary.LittleEndian.AppendUint16(hdr, uint16(len(remoteAddr)))
+	hdr = append(hdr, remoteAddr...)
+	if _, err := r.w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("write recording header: %w", err)
+	}
+	return r, nil
+}
+
+func CreatePacketRecorder(path string, connID uint64, remoteAddr string) (*PacketRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create recording %s: %w", path, err)
+	}
+	r, err := NewPacketRecorder(f, connID, remoteAddr)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record appends one frame. After the first write error the recorder stops
+// writing and keeps returning that error; recording never fails the
+// connection itself.
+func (r *PacketRecorder) Record(direction uint8, msgID uint32, data []byte) error {
+	var hdr [recordHeaderSize]byte
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(time.Now().UnixNano()))
+	hdr[8] = direction
+	binary.LittleEndian.PutUint32(hdr[9:13], msgID)
+	binary.LittleEndian.PutUint32(hdr[13:17], uint32(len(data)))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if _, err := r.w.Write(hdr[:]); err != nil {
+		r.err = err
+		return err
+	}
+	if _, err := r.w.Write(data); err != nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *PacketRecorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+func (r *PacketRecorder) Close() error {
+	err := r.Flush()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// PacketReader iterates over a recording written by PacketRecorder.
+type PacketReader struct {
+	r      *bufio.Reader
+	closer io.Closer
+	Header RecordHeader
+	// MaxDataLen bounds the length of RecordInbound frames Next accepts, so a
+	// corrupt file cannot make it allocate gigabytes. It starts at the global
+	// config's MaxPacketSize, which those frames passed on arrival; 0
+	// disables the check.
+	MaxDataLen uint32
+	// MaxOutboundDataLen does the same for RecordOutbound frames, which no
+	// packet size limit applied to when they were sent. It starts at
+	// DefaultMaxOutboundRecordLen; 0 disables the check.
+	MaxOutboundDataLen uint32
+}
+
+func NewPacketReader(r io.Reader) (*PacketReader, error) {
+	pr := &PacketReader{
+		r:                  bufio.NewReader(r),
+		MaxDataLen:         config.Global().Server.MaxPacketSize,
+		MaxOutboundDataLen: DefaultMaxOutboundRecordLen,
+	}
+	if c, ok := r.(io.Closer); ok {
+		pr.closer = c
+	}
+
+	var fixed [8 + 2 + 8 + 8 + 2]byte
+	if _, err := io.ReadFull(pr.r, fixed[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRecording, err)
+	}
+	if [8]byte(fixed[0:8]) != recordMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrBadRecording)
+	}
+	pr.Header.Version = binary.LittleEndian.Uint16(fixed[8:10])
+	if pr.Header.Version != recordVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadRecording, pr.Header.Version)
+	}
+	pr.Header.ConnID = binary.LittleEndian.Uint64(fixed[10:18])
+	pr.Header.StartedAt = time.Unix(0, int64(binary.LittleEndian.Uint64(fixed[18:26])))
+	addr := make([]byte, binary.LittleEndian.Uint16(fixed[26:28]))
+	if _, err := io.ReadFull(pr.r, addr); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRecording, err)
+	}
+	pr.Header.RemoteAddr = string(addr)
+	return pr, nil
+}
+
+func OpenPacketReader(path string) (*PacketReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := NewPacketReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the recording. A
+// truncated trailing record is reported as io.EOF as well.
+func (pr *PacketReader) Next() (*PacketRecord, error) {
+	var hdr [recordHeaderSize]byte
+	if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	dataLen := binary.LittleEndian.Uint32(hdr[13:17])
+	limit := pr.MaxDataLen
+	if hdr[8] == RecordOutbound {
+		limit = pr.MaxOutboundDataLen
+	}
+	if limit > 0 && dataLen > limit {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrRecordTooLarge, dataLen, limit)
+	}
+	rec := &PacketRecord{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[0:8]))),
+		Direction: hdr[8],
+		MsgID:     binary.LittleEndian.Uint32(hdr[9:13]),
+		Data:      make([]byte, dataLen),
+	}
+	if _, err := io.ReadFull(pr.r, rec.Data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (pr *PacketReader) Close() error {
+	if pr.closer != nil {
+		return pr.closer.Close()
+	}
+	return nil
+}
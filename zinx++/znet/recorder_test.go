@@ -0,0 +1,100 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"zinxplusplus/config"
+)
+
+func TestPacketRecordingRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewPacketRecorder(&buf, 42, "10.0.0.1:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rec.Record(RecordInbound, 1, []byte("ping"))
+	_ = rec.Record(RecordOutbound, 2, []byte("pong"))
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := NewPacketReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Header.ConnID != 42 || pr.Header.RemoteAddr != "10.0.0.1:5000" {
+		t.Fatalf("header = %+v", pr.Header)
+	}
+	for _, want := range []PacketRecord{
+		{Direction: RecordInbound, MsgID: 1, Data: []byte("ping")},
+		{Direction: RecordOutbound, MsgID: 2, Data: []byte("pong")},
+	} {
+		got, err := pr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Direction != want.Direction || got.MsgID != want.MsgID || !bytes.Equal(got.Data, want.Data) {
+			t.Fatalf("record = %+v, want %+v", got, want)
+		}
+	}
+	if _, err := pr.Next(); err != io.EOF {
+		t.Fatalf("after last record: %v", err)
+	}
+}
+
+func TestPacketReaderRejectsOversizedRecord(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewPacketRecorder(&buf, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rec.Flush()
+
+	// A record claiming a 4 GiB body, with none of it present.
+	var hdr [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[13:17], 0xFFFFFFFF)
+	buf.Write(hdr[:])
+
+	pr, err := NewPacketReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr.MaxDataLen = 4096
+	if _, err := pr.Next(); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("oversized record: %v", err)
+	}
+}
+
+func TestPacketReaderAcceptsLargeOutboundRecord(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewPacketRecorder(&buf, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := bytes.Repeat([]byte{'x'}, int(config.Global().Server.MaxPacketSize)*4)
+	_ = rec.Record(RecordOutbound, 7, push)
+	_ = rec.Record(RecordInbound, 8, push)
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := NewPacketReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pr.Next()
+	if err != nil {
+		t.Fatalf("outbound frame above MaxPacketSize: %v", err)
+	}
+	if got.MsgID != 7 || !bytes.Equal(got.Data, push) {
+		t.Fatalf("record = msgID %d, %d bytes", got.MsgID, len(got.Data))
+	}
+	// Inbound frames that large could never have been received.
+	if _, err := pr.Next(); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("oversized inbound record: %v", err)
+	}
+}
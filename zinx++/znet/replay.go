@@ -0,0 +1,79 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+// ReplayOptions controls how a recording is played back.
+type ReplayOptions struct {
+	// Speed scales the recorded gaps between frames: 1 keeps the original
+	// pacing, 2 plays twice as fast, 0 sends back to back.
+	Speed float64
+	// Direction selects which frames are replayed, normally RecordInbound to
+	// re-send what the client sent.
+	Direction uint8
+	// Filter skips a frame when it returns false. Nil replays everything.
+	Filter func(record *PacketRecord) bool
+}
+
+// ReplayToMsgHandle runs the recorded client frames through mh.DoMsgHandler
+// on the calling goroutine, one at a time in recorded order, bypassing the
+// worker pool so handler side effects are deterministic. conn is what
+// handlers see as the request's connection, typically a test double.
+func ReplayToMsgHandle(ctx context.Context, pr *PacketReader, mh ziface.IMsgHandler, conn ziface.IConnection, opts ReplayOptions) (int, error) {
+	return replayRecords(ctx, pr, opts, func(rec *PacketRecord) error {
+		mh.DoMsgHandler(NewRequest(conn, NewMsgPackage(rec.MsgID, rec.Data)))
+		return nil
+	})
+}
+
+// ReplayToConn sends the recorded frames over conn, e.g. a Client connected
+// to a live server.
+func ReplayToConn(ctx context.Context, pr *PacketReader, conn ziface.IConnection, opts ReplayOptions) (int, error) {
+	return replayRecords(ctx, pr, opts, func(rec *PacketRecord) error {
+		return conn.SendMsg(rec.MsgID, rec.Data)
+	})
+}
+
+func replayRecords(ctx context.Context, pr *PacketReader, opts ReplayOptions, apply func(*PacketRecord) error) (int, error) {
+	var last time.Time
+	replayed := 0
+	for {
+		rec, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+		if rec.Direction != opts.Direction || (opts.Filter != nil && !opts.Filter(rec)) {
+			continue
+		}
+
+		if opts.Speed > 0 && !last.IsZero() {
+			if gap := time.Duration(float64(rec.Time.Sub(last)) / opts.Speed); gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-ctx.Done():
+					return replayed, ctx.Err()
+				}
+			}
+		}
+		last = rec.Time
+
+		select {
+		case <-ctx.Done():
+			return replayed, ctx.Err()
+		default:
+		}
+		if err := apply(rec); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+}
@@ -27,3 +27,12 @@ func (r *Request) GetMsgID() uint32 {
 	}
 	return r.msg.GetMsgID()
 }
+
+// NewRequest builds a request outside the network path, e.g. for replays and
+// unit tests.
+func NewRequest(conn ziface.IConnection, msg ziface.IMessage) ziface.IRequest {
+	return &Request{
+		conn: conn,
+		msg:  msg,
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"zinxplusplus/config"
+	"zinxplusplus/timer"
 	"zinxplusplus/ziface"
 
 	"github.com/cloudwego/netpoll"
@@ -19,71 +21,169 @@ import (
 
 type Server struct {
 	opts       *ServerOptions
-	listener   net.Listener
-	eventLoop  netpoll.EventLoop
+	listeners  []*serverListener
 	msgHandler ziface.IMsgHandler
 	connMgr    ziface.IConnManager
 
 	stateMgr     ziface.IStateManager
 	aoiMgr       ziface.IAoiManager
 	scriptEngine ziface.IScriptEngine
+	sessionMgr   ziface.ISessionManager
+	scheduler    *timer.Scheduler
+	admission    *AdmissionController
+	auth         *authGate
+
+	config *config.Store
+
+	trustedProxies []*net.IPNet
+
+	configWatcher *config.Watcher
+	unsubscribe   []func()
 
 	onConnStart func(ziface.IConnection)
 	onConnStop  func(ziface.IConnection)
 
+	connStopHooks []func(ziface.IConnection)
+	stopHooks     []func(ziface.IServer)
+	hookLock      sync.RWMutex
+
 	nextConnID uint64
 
 	exit chan struct{}
 }
 
+// NewServer builds a server from opts layered as described on resolveConfig.
+// It panics if the resulting config does not validate or the admission or
+// trusted proxy lists do not parse; use config.Validate on a config of your
+// own first when the input is not trusted.
 func NewServer(opts ...Option) ziface.IServer {
 
 	serverOpts := newOptions(opts...)
+	loader, base, cfg, err := resolveConfig(serverOpts)
+	if err != nil {
+		panic(fmt.Sprintf("load server config err: %v", err))
+	}
+	store := config.NewStore(cfg)
 
 	s := &Server{
 		opts:       serverOpts,
-		msgHandler: NewMsgHandle(),
+		config:     store,
+		msgHandler: newMsgHandle(store),
 		connMgr:    NewConnManager(),
+		sessionMgr: NewSessionManager(SessionOptions{
+			Policy:          serverOpts.SessionPolicy,
+			KickMsgID:       serverOpts.SessionKickMsgID,
+			KickNotice:      serverOpts.SessionKickNotice,
+			ResumeGrace:     time.Duration(serverOpts.SessionResumeGraceMs) * time.Millisecond,
+			ReplayBufferLen: serverOpts.SessionReplayBufferLen,
+			OnExpire:        serverOpts.OnSessionExpire,
+		}),
+
+		scheduler: timer.NewScheduler(time.Duration(serverOpts.TickIntervalMs)*time.Millisecond, serverOpts.MaxCatchUpTicks),
 
 		onConnStart: serverOpts.OnConnStart,
 		onConnStop:  serverOpts.OnConnStop,
 		exit:        make(chan struct{}),
 	}
 
-	config.GlobalConfig = &config.Config{
-		Server: config.ServerConfig{
-			Name:                   s.opts.Name,
-			IPVersion:              s.opts.IPVersion,
-			IP:                     s.opts.IP,
-			Port:                   s.opts.Port,
-			MaxConn:                s.opts.MaxConn,
-			MaxPacketSize:          s.opts.MaxPacketSize,
-			WorkerPoolSize:         s.opts.WorkerPoolSize,
-			MaxWorkerTaskLen:       s.opts.MaxWorkerTaskLen,
-			ReadTimeoutMs:          s.opts.ReadTimeoutMs,
-			IdleTimeoutMs:          s.opts.IdleTimeoutMs,
-			WriteTimeoutMs:         s.opts.WriteTimeoutMs,
-			SendMsgTimeoutMs:       s.opts.SendMsgTimeoutMs,
-			SendTaskQueueTimeoutMs: s.opts.SendTaskQueueTimeoutMs,
-			MaxMsgChanLen:          s.opts.MaxMsgChanLen,
-			MaxMsgBuffChanLen:      s.opts.MaxMsgBuffChanLen,
-			NetpollNumLoops:        s.opts.NetpollNumLoops,
-			NetpollLoadBalance:     s.opts.NetpollLoadBalance,
-		},
-
-		Log:       config.GlobalConfig.Log,
-		State:     config.GlobalConfig.State,
-		AOI:       config.GlobalConfig.AOI,
-		Scripting: config.GlobalConfig.Scripting,
-	}
-
-	fmt.Printf("[Server] Config loaded: %+v\n", config.GlobalConfig)
+	admission, err := NewAdmissionController(cfg.Admission)
+	if err != nil {
+		panic(fmt.Sprintf("create admission controller err: %v", err))
+	}
+	s.admission = admission
+	s.scheduler.Every(time.Minute, s.admission.Sweep)
+
+	if s.opts.ProxyProtocol {
+		trusted, err := parseCIDRs(s.opts.TrustedProxyCIDRs)
+		if err != nil {
+			panic(fmt.Sprintf("parse trusted proxy list err: %v", err))
+		}
+		if len(trusted) == 0 {
+			fmt.Println("[Server] Warning: PROXY protocol enabled without trusted proxies, headers will be ignored.")
+		}
+		s.trustedProxies = trusted
+	}
+
+	if serverOpts.OnTickOverrun != nil {
+		s.scheduler.SetOverrunHandler(serverOpts.OnTickOverrun)
+	}
+
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		if err := mh.SetDispatchMode(s.opts.DispatchMode, s.opts.DispatchKeyFunc); err != nil {
+			panic(fmt.Sprintf("set dispatch mode err: %v", err))
+		}
+	}
+
+	names := make(map[string]struct{}, len(s.opts.Listeners))
+	for _, spec := range s.opts.Listeners {
+		if _, dup := names[spec.Name]; dup {
+			panic(fmt.Sprintf("duplicate listener name %q", spec.Name))
+		}
+		names[spec.Name] = struct{}{}
+		s.listeners = append(s.listeners, newServerListener(spec))
+	}
+
+	if s.opts.SessionResumeGraceMs > 0 {
+		s.addControlRouter(MsgIDSessionResume, NewSessionResumeRouter(s.sessionMgr), false)
+	}
+
+	s.auth = newAuthGate(s, serverOpts)
+	if s.auth != nil {
+		for msgID := range s.auth.authMsgIDs {
+			s.addControlRouter(msgID, &authRouter{gate: s.auth}, true)
+		}
+	}
+
+	if s.opts.ConfigReload {
+		if loader == nil || loader.File == "" {
+			panic("config reload needs a config file")
+		}
+		s.configWatcher = store.Watch(loader, base, time.Duration(s.opts.ConfigPollIntervalMs)*time.Millisecond)
+	}
+
+	fmt.Printf("[Server] Config loaded: %+v\n", cfg)
 
 	return s
 }
 
+// resolveConfig applies the single precedence model: built-in defaults <
+// options < config file < environment < flags. The result is written back
+// into opts, so the options and the server's config always agree. base is the
+// options layer, which reloads start from again. The global config is only read,
+// for the sections the options do not cover.
+//
+// Without a loader (no WithConfigLoader, WithConfigReload path or
+// config.DefaultLoader) there is no file, environment or flag layer at all:
+// ZINX_* variables are ignored and the options are only validated.
+func resolveConfig(opts *ServerOptions) (loader *config.Loader, base, cfg *config.Config, err error) {
+	loader = opts.ConfigLoader
+	if loader == nil && opts.ConfigReload && opts.ConfigPath != "" {
+		loader = config.NewLoader(opts.ConfigPath)
+	}
+	if loader == nil {
+		loader = config.DefaultLoader()
+	}
+
+	base = opts.baseConfig()
+	cfg = base
+	if loader != nil {
+		if cfg, err = loader.LoadOnto(base); err != nil {
+			return nil, nil, nil, err
+		}
+		opts.applyServerConfig(cfg.Server)
+		admission := cfg.Admission
+		opts.Admission = &admission
+	} else if err = config.Validate(cfg); err != nil {
+		return nil, nil, nil, err
+	}
+	// Listeners left empty in the config mean the default one on IP:Port.
+	opts.addDefaultListener()
+
+	return loader, base, cfg, nil
+}
+
 func (s *Server) Start() {
-	fmt.Printf("[Server] Starting server [%s] at %s:%d...\n", s.opts.Name, s.opts.IP, s.opts.Port)
+	fmt.Printf("[Server] Starting server [%s] with %d listener(s)...\n", s.opts.Name, len(s.listeners))
 	fmt.Printf("[Server] WorkerPoolSize=%d, MaxConn=%d, MaxPacketSize=%d\n",
 		s.opts.WorkerPoolSize, s.opts.MaxConn, s.opts.MaxPacketSize)
 
@@ -100,35 +200,18 @@ func (s *Server) Start() {
 		s.msgHandler.StartWorkerPool()
 	}
 
-	addr := fmt.Sprintf("%s:%d", s.opts.IP, s.opts.Port)
-	listener, err := netpoll.CreateListener(s.opts.IPVersion, addr)
-	if err != nil {
-		panic(fmt.Sprintf("start net listener err: %v", err))
-	}
-	s.listener = listener
-	fmt.Printf("[Server] Listener created successfully at %s\n", addr)
+	s.scheduler.Start()
 
-	netpollOpts := []netpoll.Option{
-		netpoll.WithReadTimeout(time.Duration(s.opts.ReadTimeoutMs) * time.Millisecond),
-		netpoll.WithIdleTimeout(time.Duration(s.opts.IdleTimeoutMs) * time.Millisecond),
-		netpoll.WithOnPrepare(s.onNetpollPrepare),
+	s.subscribeConfig()
+	if s.configWatcher != nil && s.opts.ConfigPollIntervalMs > 0 {
+		s.configWatcher.Start()
 	}
 
-	eventLoop, err := netpoll.NewEventLoop(s.onNetpollRequest, netpollOpts...)
-	if err != nil {
-		panic(fmt.Sprintf("create netpoll eventloop err: %v", err))
-	}
-	s.eventLoop = eventLoop
-	fmt.Printf("[Server] Netpoll EventLoop created successfully.\n")
-
-	go func() {
-
-		if err := s.eventLoop.Serve(s.listener); err != nil && !errors.Is(err, netpoll.ErrConnClosed) {
-			fmt.Printf("[Server] Netpoll Serve error: %v\n", err)
-
+	for _, l := range s.listeners {
+		if err := l.start(s); err != nil {
+			panic(fmt.Sprintf("start net listener err: %v", err))
 		}
-		fmt.Println("[Server] Netpoll Serve loop exited.")
-	}()
+	}
 
 	fmt.Printf("[Server] Server [%s] started successfully.\n", s.opts.Name)
 
@@ -148,23 +231,37 @@ func (s *Server) Stop() {
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if s.eventLoop != nil {
-		if err := s.eventLoop.Shutdown(shutdownCtx); err != nil {
-			fmt.Printf("[Server] Netpoll Shutdown error: %v\n", err)
-		} else {
-			fmt.Println("[Server] Netpoll EventLoop shutdown.")
-		}
-	} else {
+	for _, l := range s.listeners {
+		l.stop(shutdownCtx)
+	}
 
-		if s.listener != nil {
-			s.listener.Close()
-		}
+	if s.configWatcher != nil && s.opts.ConfigPollIntervalMs > 0 {
+		s.configWatcher.Stop()
+	}
+	for _, unsubscribe := range s.unsubscribe {
+		unsubscribe()
 	}
 
 	if s.connMgr != nil {
 		s.connMgr.ClearConn()
 	}
 
+	s.hookLock.RLock()
+	stopHooks := s.stopHooks
+	s.hookLock.RUnlock()
+	for _, hook := range stopHooks {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Printf("[Hook Call] OnStop panic: %v\n", err)
+				}
+			}()
+			hook(s)
+		}()
+	}
+
+	s.scheduler.Stop()
+
 	if s.msgHandler != nil {
 		s.msgHandler.StopWorkerPool()
 	}
@@ -192,6 +289,40 @@ func (s *Server) AddRouter(msgId uint32, router ziface.IRouter) {
 	}
 }
 
+// AddListenerRouter registers a router on a listener that has its own router
+// set. Listeners sharing the server routers use AddRouter instead.
+func (s *Server) AddListenerRouter(listenerName string, msgId uint32, router ziface.IRouter) error {
+	l := s.findListener(listenerName)
+	if l == nil {
+		return fmt.Errorf("%w: %s", ErrListenerNotFound, listenerName)
+	}
+	if l.routers == nil {
+		return fmt.Errorf("%w: %s", ErrSharedRouters, listenerName)
+	}
+	l.routers.add(msgId, router)
+	return nil
+}
+
+func (s *Server) findListener(name string) *serverListener {
+	for _, l := range s.listeners {
+		if l.spec.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// addControlRouter registers a built-in router on the server and on every
+// listener with its own router set, so isolated listeners keep working.
+func (s *Server) addControlRouter(msgID uint32, router ziface.IRouter, auth bool) {
+	s.msgHandler.AddRouter(msgID, router)
+	for _, l := range s.listeners {
+		if l.routers != nil && !(auth && l.spec.SkipAuth) {
+			l.routers.add(msgID, router)
+		}
+	}
+}
+
 func (s *Server) GetConnMgr() ziface.IConnManager {
 	return s.connMgr
 }
@@ -208,6 +339,18 @@ func (s *Server) SetOnConnStop(hook func(ziface.IConnection)) {
 	s.onConnStop = hook
 }
 
+func (s *Server) AddOnConnStop(hook func(ziface.IConnection)) {
+	s.hookLock.Lock()
+	defer s.hookLock.Unlock()
+	s.connStopHooks = append(s.connStopHooks, hook)
+}
+
+func (s *Server) AddOnStop(hook func(ziface.IServer)) {
+	s.hookLock.Lock()
+	defer s.hookLock.Unlock()
+	s.stopHooks = append(s.stopHooks, hook)
+}
+
 func (s *Server) CallOnConnStart(connection ziface.IConnection) {
 	if s.onConnStart != nil {
 
@@ -237,6 +380,40 @@ func (s *Server) CallOnConnStop(connection ziface.IConnection) {
 			s.onConnStop(connection)
 		}()
 	}
+
+	s.hookLock.RLock()
+	hooks := s.connStopHooks
+	s.hookLock.RUnlock()
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Printf("[Hook Call] OnConnStop panic: %v\n", err)
+				}
+			}()
+			hook(connection)
+		}()
+	}
+
+	if s.sessionMgr != nil {
+		s.sessionMgr.Unbind(connection)
+	}
+}
+
+func (s *Server) releaseConn(conn netpoll.Connection) {
+	if s.admission != nil && !s.fromTrustedProxy(conn.RemoteAddr()) {
+		s.admission.Release(conn.RemoteAddr())
+	}
+}
+
+// fromTrustedProxy reports whether addr must send a PROXY header. Admission
+// for such peers is deferred until the header names the real client.
+func (s *Server) fromTrustedProxy(addr net.Addr) bool {
+	if len(s.trustedProxies) == 0 {
+		return false
+	}
+	ip := addrIP(addr)
+	return ip != nil && containsIP(s.trustedProxies, ip)
 }
 
 func (s *Server) GetStateManager() ziface.IStateManager {
@@ -251,20 +428,63 @@ func (s *Server) GetScriptEngine() ziface.IScriptEngine {
 	return s.scriptEngine
 }
 
+func (s *Server) GetSessionManager() ziface.ISessionManager {
+	return s.sessionMgr
+}
+
+func (s *Server) GetScheduler() ziface.IScheduler {
+	return s.scheduler
+}
+
+func (s *Server) GetAdmissionController() ziface.IAdmissionController {
+	return s.admission
+}
+
 func (s *Server) ServerName() string {
 	return s.opts.Name
 }
 
+// Config returns the store holding this server's settings. Subscribe to it to
+// follow reloads; the global config is not affected by servers.
+func (s *Server) Config() *config.Store {
+	return s.config
+}
+
 func (s *Server) GetListener() net.Listener {
-	return s.listener
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].listener
+}
+
+func (s *Server) GetListeners() []net.Listener {
+	listeners := make([]net.Listener, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		if l.listener != nil {
+			listeners = append(listeners, l.listener)
+		}
+	}
+	return listeners
 }
 
-func (s *Server) onNetpollPrepare(conn netpoll.Connection) context.Context {
+func (s *Server) onNetpollPrepare(l *serverListener, conn netpoll.Connection) context.Context {
 	fmt.Println("进入OnNetpollPrepare  1")
 
-	if s.connMgr != nil && s.connMgr.Len() >= s.opts.MaxConn {
+	// Read per connection so reloaded limits apply to new connections.
+	cfg := s.config.Load().Server
+	if s.connMgr != nil && s.connMgr.Len() >= cfg.MaxConn {
 		fmt.Printf("[Server] Too many connections! Max = %d, Current = %d. Closing new connection from %s\n",
-			s.opts.MaxConn, s.connMgr.Len(), conn.RemoteAddr().String())
+			cfg.MaxConn, s.connMgr.Len(), conn.RemoteAddr().String())
+		conn.Close()
+		return nil
+	}
+	_ = conn.SetReadTimeout(time.Duration(cfg.ReadTimeoutMs) * time.Millisecond)
+	_ = conn.SetIdleTimeout(time.Duration(cfg.IdleTimeoutMs) * time.Millisecond)
+
+	if s.fromTrustedProxy(conn.RemoteAddr()) {
+		// Admitted by the connection once the PROXY header is read.
+	} else if err := s.admission.Admit(conn.RemoteAddr()); err != nil {
+		fmt.Printf("[Server] Connection from %s rejected: %v\n", conn.RemoteAddr().String(), err)
 		conn.Close()
 		return nil
 	}
@@ -277,11 +497,12 @@ func (s *Server) onNetpollPrepare(conn netpoll.Connection) context.Context {
 
 	fmt.Println("进入OnNetpollPrepare  3")
 
-	zConn, err := NewConnection(s, conn, connID, workerID, s.msgHandler)
+	zConn, err := newConnection(s, conn, connID, workerID, s.msgHandler, l)
 
 	fmt.Println("进入OnNetpollPrepare  4")
 	if err != nil {
 		fmt.Printf("[Server] Failed to create Zinx Connection for ConnID %d: %v\n", connID, err)
+		s.releaseConn(conn)
 		conn.Close()
 		return nil
 	}
@@ -307,12 +528,43 @@ func (s *Server) onNetpollRequest(ctx context.Context, connection netpoll.Connec
 func (s *Server) waitForExitSignal() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-
-	select {
-	case <-sig:
-		fmt.Printf("[Server] Received system signal, stopping server...\n")
-		s.Stop()
-	case <-s.exit:
-		fmt.Println("[Server] Exit channel closed, exiting signal listener.")
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case received := <-sig:
+			if received == syscall.SIGHUP && s.configWatcher != nil {
+				fmt.Printf("[Server] Received SIGHUP, reloading config from %s...\n", s.configWatcher.Path())
+				if _, err := s.configWatcher.Reload(); err != nil {
+					fmt.Printf("[Server] Config reload rejected: %v\n", err)
+				}
+				continue
+			}
+			fmt.Printf("[Server] Received system signal, stopping server...\n")
+			s.Stop()
+			return
+		case <-s.exit:
+			fmt.Println("[Server] Exit channel closed, exiting signal listener.")
+			return
+		}
 	}
 }
+
+// subscribeConfig applies reloaded runtime-tunable settings. Values read per
+// use (send timeouts, packet size, write batching) pick up the new config on
+// their own; these need pushing into long-lived components. Scenes follow
+// the AOI view range of the store given with scene.WithConfigStore.
+func (s *Server) subscribeConfig() {
+	s.unsubscribe = append(s.unsubscribe,
+		s.config.OnAdmissionChange(func(_, cfg config.AdmissionConfig) {
+			if err := s.admission.Reload(cfg); err != nil {
+				fmt.Printf("[Server] Admission reload failed: %v\n", err)
+			}
+		}),
+		s.config.OnLogChange(func(old, cfg config.LogConfig) {
+			if old.Level != cfg.Level {
+				fmt.Printf("[Server] Log level changed from %s to %s.\n", old.Level, cfg.Level)
+			}
+		}),
+	)
+}
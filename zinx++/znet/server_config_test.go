@@ -0,0 +1,165 @@
+package znet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zinxplusplus/config"
+	"zinxplusplus/ziface"
+)
+
+func TestResolveConfigWithoutLoaderIgnoresEnv(t *testing.T) {
+	if config.DefaultLoader() != nil {
+		t.Skip("a default loader is installed")
+	}
+	t.Setenv(config.DefaultEnvPrefix+"SERVER_MAX_CONN", "5")
+
+	loader, _, cfg, err := resolveConfig(newOptions(WithMaxConn(9)))
+	if err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+	if loader != nil || cfg.Server.MaxConn != 9 {
+		t.Fatalf("without a loader: loader = %v, maxConn = %d, want nil and 9", loader, cfg.Server.MaxConn)
+	}
+
+	opts := newOptions(WithMaxConn(9), WithConfigLoader(config.NewLoader("")))
+	if _, _, cfg, err = resolveConfig(opts); err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+	if cfg.Server.MaxConn != 5 || opts.MaxConn != 5 {
+		t.Fatalf("with a loader: maxConn = %d, options = %d, want 5", cfg.Server.MaxConn, opts.MaxConn)
+	}
+
+	// Without a loader the options are still validated.
+	if _, _, _, err = resolveConfig(newOptions(WithPort(70000))); err == nil {
+		t.Fatal("resolveConfig accepted port 70000")
+	}
+}
+
+func TestNewServerPanicsOnInvalidConfig(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "server.port") {
+			t.Fatalf("recovered %v, want a panic naming server.port", r)
+		}
+	}()
+	NewServer(WithPort(70000))
+}
+
+func TestServersKeepIndependentConfigs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	fileA := write("a.json", `{"server": {"maxPacketSize": 1000}}`)
+	fileB := write("b.json", `{"server": {"maxPacketSize": 2000}}`)
+
+	start := func(name, file string) *Server {
+		s := NewServer(
+			WithName(name),
+			WithListener(ListenerSpec{Name: name, Network: "tcp", Address: "127.0.0.1:0"}),
+			WithConfigReload(file, 0),
+		).(*Server)
+		s.Start()
+		t.Cleanup(s.Stop)
+		return s
+	}
+	a, b := start("a", fileA), start("b", fileB)
+	globalSize := config.Global().Server.MaxPacketSize
+
+	if got := a.Config().Load().Server.MaxPacketSize; got != 1000 {
+		t.Fatalf("a maxPacketSize = %d, want 1000", got)
+	}
+	if got := b.Config().Load().Server.MaxPacketSize; got != 2000 {
+		t.Fatalf("b maxPacketSize = %d, want 2000", got)
+	}
+
+	var reloadsA, reloadsB int
+	a.Config().OnReload(func(*config.ChangeSet) { reloadsA++ })
+	b.Config().OnReload(func(*config.ChangeSet) { reloadsB++ })
+
+	write("a.json", `{"server": {"maxPacketSize": 1500}, "admission": {"denyCidrs": ["127.0.0.0/8"]}}`)
+	if _, err := a.configWatcher.Reload(); err != nil {
+		t.Fatalf("reload a: %v", err)
+	}
+
+	if got := a.Config().Load().Server.MaxPacketSize; got != 1500 {
+		t.Fatalf("a maxPacketSize after reload = %d, want 1500", got)
+	}
+	if got := b.Config().Load().Server.MaxPacketSize; got != 2000 {
+		t.Fatalf("b maxPacketSize after reloading a = %d, want 2000", got)
+	}
+	if got := config.Global().Server.MaxPacketSize; got != globalSize {
+		t.Fatalf("global maxPacketSize = %d after a server reload, want %d", got, globalSize)
+	}
+	if reloadsA != 1 || reloadsB != 0 {
+		t.Fatalf("reload subscribers ran a=%d b=%d, want 1 and 0", reloadsA, reloadsB)
+	}
+
+	// The admission change reached a's controller only.
+	peer := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	if err := a.admission.Admit(peer); !errors.Is(err, ErrAddrDenied) {
+		t.Fatalf("a Admit after reload = %v, want ErrAddrDenied", err)
+	}
+	if err := b.admission.Admit(peer); err != nil {
+		t.Fatalf("b Admit = %v, want nil", err)
+	}
+	b.admission.Release(peer)
+
+	// Reloading b picks up only b's file.
+	write("b.json", `{"server": {"maxPacketSize": 2500}}`)
+	if _, err := b.configWatcher.Reload(); err != nil {
+		t.Fatalf("reload b: %v", err)
+	}
+	if got := b.Config().Load().Server.MaxPacketSize; got != 2500 {
+		t.Fatalf("b maxPacketSize after reload = %d, want 2500", got)
+	}
+	if got := a.Config().Load().Server.MaxPacketSize; got != 1500 {
+		t.Fatalf("a maxPacketSize after reloading b = %d, want 1500", got)
+	}
+	if reloadsA != 1 || reloadsB != 1 {
+		t.Fatalf("reload subscribers ran a=%d b=%d, want 1 and 1", reloadsA, reloadsB)
+	}
+}
+
+func TestUnnamedConfigListeners(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "zinx.json")
+	if err := os.WriteFile(file, []byte(`{"server": {"listeners": [
+		{"network": "tcp", "address": "127.0.0.1:0"},
+		{"network": "tcp", "address": "127.0.0.1:0", "skipAuth": true}
+	]}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithConfigLoader(config.NewLoader(file))).(*Server)
+	if len(s.listeners) != 2 {
+		t.Fatalf("%d listeners, want 2", len(s.listeners))
+	}
+	for i, l := range s.listeners {
+		if want := config.DefaultListenerName(i); l.spec.Name != want {
+			t.Errorf("listener %d named %q, want %q", i, l.spec.Name, want)
+		}
+	}
+	if !s.listeners[1].spec.SkipAuth {
+		t.Error("second listener lost its settings")
+	}
+
+	// An unnamed listener in the config updates the option listener at the
+	// same index, keeping what only the options can set.
+	router := &BaseRouter{}
+	s = NewServer(
+		WithListener(ListenerSpec{Network: "tcp", Address: ":1", Routers: map[uint32]ziface.IRouter{1: router}}),
+		WithConfigLoader(config.NewLoader(file)),
+	).(*Server)
+	if got := s.listeners[0].spec; got.Address != "127.0.0.1:0" || got.Routers[1] != router {
+		t.Fatalf("listener-0 = %+v, want the config address and the option's routers", got)
+	}
+}
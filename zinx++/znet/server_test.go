@@ -0,0 +1,117 @@
+package znet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+// startServer runs a real server on a loopback port chosen by the kernel and
+// returns it with the address to dial.
+func startServer(t *testing.T, opts ...znet.Option) (*znet.Server, string) {
+	t.Helper()
+	opts = append([]znet.Option{
+		znet.WithName("ZinxTest"),
+		znet.WithListener(znet.ListenerSpec{Name: "test", Network: "tcp", Address: "127.0.0.1:0"}),
+	}, opts...)
+	srv := znet.NewServer(opts...).(*znet.Server)
+	srv.Start()
+	t.Cleanup(srv.Stop)
+	return srv, srv.GetListeners()[0].Addr().String()
+}
+
+type received struct {
+	msgID uint32
+	data  []byte
+}
+
+// inboxRouter queues every message it handles for testClient.recv.
+type inboxRouter struct {
+	znet.BaseRouter
+	inbox chan received
+}
+
+func (r *inboxRouter) Handle(request ziface.IRequest) {
+	r.inbox <- received{msgID: request.GetMsgID(), data: request.GetData()}
+}
+
+// testClient drives a server through a real Client. Replies with one of the
+// msgIDs given to connect are queued in order; the client does not redial.
+type testClient struct {
+	t       *testing.T
+	client  ziface.IClient
+	inbox   chan received
+	stopped chan struct{}
+}
+
+func connect(t *testing.T, network, addr string, replyIDs ...uint32) *testClient {
+	t.Helper()
+	c := &testClient{t: t, inbox: make(chan received, 64), stopped: make(chan struct{})}
+	c.client = znet.NewClient(network, addr,
+		znet.WithReconnect(0, 0),
+		znet.WithClientOnConnStop(func(ziface.IConnection) { close(c.stopped) }),
+	)
+	router := &inboxRouter{inbox: c.inbox}
+	for _, id := range replyIDs {
+		c.client.AddRouter(id, router)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.client.Dial(ctx); err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	t.Cleanup(c.client.Stop)
+	return c
+}
+
+func (c *testClient) send(msgID uint32, data []byte) {
+	c.t.Helper()
+	if err := c.client.SendMsg(msgID, data); err != nil {
+		c.t.Fatalf("send %d: %v", msgID, err)
+	}
+}
+
+// recv returns the next queued reply; ok is false on timeout.
+func (c *testClient) recv(timeout time.Duration) (msgID uint32, data []byte, ok bool) {
+	select {
+	case msg := <-c.inbox:
+		return msg.msgID, msg.data, true
+	case <-time.After(timeout):
+		return 0, nil, false
+	}
+}
+
+func (c *testClient) expect(msgID uint32, timeout time.Duration) []byte {
+	c.t.Helper()
+	got, data, ok := c.recv(timeout)
+	if !ok {
+		c.t.Fatalf("no message %d within %v", msgID, timeout)
+	}
+	if got != msgID {
+		c.t.Fatalf("got message %d (%q), want %d", got, data, msgID)
+	}
+	return data
+}
+
+// closed reports whether the connection went down within timeout.
+func (c *testClient) closed(timeout time.Duration) bool {
+	select {
+	case <-c.stopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// echoRouter replies to every request with msgID+1 and the same payload.
+type echoRouter struct {
+	znet.BaseRouter
+}
+
+func (r *echoRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConnection().SendMsg(request.GetMsgID()+1, request.GetData())
+}
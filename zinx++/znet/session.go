@@ -0,0 +1,525 @@
+package znet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+)
+
+const (
+	SessionPolicyKickOld   = "kick-old"
+	SessionPolicyRejectNew = "reject-new"
+
+	PropKeyUserID = "zinx.userID"
+
+	MsgIDSessionToken  uint32 = 0xFFFFFF01
+	MsgIDSessionResume uint32 = 0xFFFFFF02
+
+	SessionResumeOK        byte = 0
+	SessionResumeNotFound  byte = 1
+	SessionResumeReplayGap byte = 2
+	SessionResumeBadReq    byte = 3
+
+	sessionKickDelay       = 200 * time.Millisecond
+	defaultReplayBufferLen = 256
+)
+
+var (
+	ErrSessionExists       = errors.New("user already has an active session")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionReplayGap    = errors.New("session replay buffer no longer covers requested sequence")
+	ErrSessionBadResumeReq = errors.New("malformed session resume request")
+)
+
+type SessionOptions struct {
+	Policy     string
+	KickMsgID  uint32
+	KickNotice string
+
+	// ResumeGrace > 0 parks sessions on disconnect instead of dropping them.
+	ResumeGrace     time.Duration
+	ReplayBufferLen int
+	TokenMsgID      uint32
+	ResumeMsgID     uint32
+	OnExpire        func(userID uint64)
+}
+
+type replayEntry struct {
+	seq   uint64
+	msgID uint32
+	data  []byte
+}
+
+// Session outlives a single Connection when resume is enabled. seq counts the
+// tracked messages meant for the client since the token was issued: those the
+// link wrote, then those it never got to write and those pushed while the
+// session was parked. A client counting what it received can therefore ask
+// for exactly what it missed.
+type Session struct {
+	userID     uint64
+	token      string
+	conn       ziface.IConnection
+	properties map[string]interface{}
+	parkTimer  *time.Timer
+
+	seq       uint64
+	replay    []replayEntry
+	replayCap int
+	lock      sync.Mutex
+
+	// parkLock orders pushes against parking and resuming. link is where
+	// pushes go while the session is live; once parked they are appended to
+	// the replay log, after whatever parkedConn still had queued.
+	parked     bool
+	link       ziface.IConnection
+	parkedConn *Connection
+	parkLock   sync.Mutex
+}
+
+func (s *Session) record(msgID uint32, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	if s.replayCap <= 0 {
+		return
+	}
+	if len(s.replay) >= s.replayCap {
+		s.replay = s.replay[1:]
+	}
+	s.replay = append(s.replay, replayEntry{seq: s.seq, msgID: msgID, data: data})
+}
+
+// send pushes to the live link, or into the replay log while parked.
+func (s *Session) send(msgID uint32, data []byte) error {
+	s.parkLock.Lock()
+	if !s.parked {
+		link := s.link
+		s.parkLock.Unlock()
+		return link.SendMsg(msgID, data)
+	}
+	defer s.parkLock.Unlock()
+
+	s.absorbUnsentLocked()
+	s.record(msgID, append([]byte(nil), data...))
+	return nil
+}
+
+// absorbUnsentLocked moves what the dropped link still had queued into the
+// replay log, once. Callers hold parkLock.
+func (s *Session) absorbUnsentLocked() {
+	if s.parkedConn == nil {
+		return
+	}
+	s.parkedConn.parkUnsent(s)
+	s.parkedConn = nil
+}
+
+func (s *Session) pendingSince(lastSeq uint64) ([]replayEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if lastSeq > s.seq {
+		return nil, fmt.Errorf("%w: client seq %d ahead of server seq %d", ErrSessionBadResumeReq, lastSeq, s.seq)
+	}
+	if lastSeq == s.seq {
+		return nil, nil
+	}
+	if len(s.replay) == 0 || s.replay[0].seq > lastSeq+1 {
+		return nil, fmt.Errorf("%w: lastSeq=%d", ErrSessionReplayGap, lastSeq)
+	}
+
+	pending := make([]replayEntry, 0, s.seq-lastSeq)
+	for _, e := range s.replay {
+		if e.seq > lastSeq {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+type SessionManager struct {
+	opts SessionOptions
+
+	userConns map[uint64]ziface.IConnection
+	connUsers map[uint64]uint64
+	sessions  map[uint64]*Session
+	tokens    map[string]*Session
+	lock      sync.RWMutex
+}
+
+func NewSessionManager(opts SessionOptions) ziface.ISessionManager {
+	if opts.Policy != SessionPolicyRejectNew {
+		opts.Policy = SessionPolicyKickOld
+	}
+	if opts.ReplayBufferLen <= 0 {
+		opts.ReplayBufferLen = defaultReplayBufferLen
+	}
+	if opts.TokenMsgID == 0 {
+		opts.TokenMsgID = MsgIDSessionToken
+	}
+	if opts.ResumeMsgID == 0 {
+		opts.ResumeMsgID = MsgIDSessionResume
+	}
+	return &SessionManager{
+		opts:      opts,
+		userConns: make(map[uint64]ziface.IConnection),
+		connUsers: make(map[uint64]uint64),
+		sessions:  make(map[uint64]*Session),
+		tokens:    make(map[string]*Session),
+	}
+}
+
+func (sm *SessionManager) resumeEnabled() bool {
+	return sm.opts.ResumeGrace > 0
+}
+
+func (sm *SessionManager) Bind(userID uint64, conn ziface.IConnection) error {
+	if conn == nil {
+		return errors.New("cannot bind user to nil connection")
+	}
+
+	sm.lock.Lock()
+
+	if old, ok := sm.userConns[userID]; ok && old.GetConnID() == conn.GetConnID() {
+		sm.lock.Unlock()
+		return nil
+	}
+
+	var kicked ziface.IConnection
+	if old, ok := sm.userConns[userID]; ok && !old.IsClosed() {
+		if sm.opts.Policy == SessionPolicyRejectNew {
+			sm.lock.Unlock()
+			return fmt.Errorf("%w: userID=%d, ConnID=%d", ErrSessionExists, userID, old.GetConnID())
+		}
+		kicked = old
+		delete(sm.connUsers, old.GetConnID())
+		detachSession(old)
+	}
+
+	if prevUser, ok := sm.connUsers[conn.GetConnID()]; ok && prevUser != userID {
+		delete(sm.userConns, prevUser)
+		sm.dropSessionLocked(prevUser)
+	}
+
+	sm.userConns[userID] = conn
+	sm.connUsers[conn.GetConnID()] = userID
+
+	var sess *Session
+	if sm.resumeEnabled() {
+		sm.dropSessionLocked(userID)
+		sess = &Session{
+			userID:    userID,
+			token:     newSessionToken(),
+			conn:      conn,
+			replayCap: sm.opts.ReplayBufferLen,
+			link:      conn,
+		}
+		sm.sessions[userID] = sess
+		sm.tokens[sess.token] = sess
+	}
+	sm.lock.Unlock()
+
+	conn.SetProperty(PropKeyUserID, userID)
+	fmt.Printf("[SessionManager] Bound userID=%d to ConnID=%d\n", userID, conn.GetConnID())
+
+	if sess != nil {
+		if err := sendControlMsg(conn, sm.opts.TokenMsgID, []byte(sess.token)); err != nil {
+			fmt.Printf("[SessionManager] Send session token to ConnID=%d error: %v\n", conn.GetConnID(), err)
+		}
+		attachSession(conn, sess)
+	}
+
+	if kicked != nil {
+		sm.kick(userID, kicked)
+	}
+	return nil
+}
+
+func (sm *SessionManager) Unbind(conn ziface.IConnection) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	connID := conn.GetConnID()
+	userID, ok := sm.connUsers[connID]
+	if !ok {
+		return
+	}
+	delete(sm.connUsers, connID)
+
+	if current, ok := sm.userConns[userID]; ok && current.GetConnID() == connID {
+		delete(sm.userConns, userID)
+	}
+
+	sess, ok := sm.sessions[userID]
+	if !ok || sess.conn == nil || sess.conn.GetConnID() != connID {
+		fmt.Printf("[SessionManager] Unbound userID=%d from ConnID=%d\n", userID, connID)
+		return
+	}
+
+	// The connection keeps recording into the session until its writer is
+	// done; the first push or resume then takes over its unsent queue.
+	sess.conn = nil
+	sess.parkLock.Lock()
+	sess.parked = true
+	sess.link = nil
+	if c, ok := conn.(*Connection); ok {
+		sess.properties = c.snapshotProperties()
+		sess.parkedConn = c
+	} else {
+		detachSession(conn)
+	}
+	sess.parkLock.Unlock()
+	sess.parkTimer = time.AfterFunc(sm.opts.ResumeGrace, func() {
+		sm.expire(sess)
+	})
+	fmt.Printf("[SessionManager] Parked session of userID=%d from ConnID=%d for %v\n", userID, connID, sm.opts.ResumeGrace)
+}
+
+// Resume re-attaches a parked session to conn. Pushes through SendToUser
+// wait until the reply and replay are queued, and the connection is only
+// published to GetConnByUser after that, so no handler can interleave fresh
+// pushes with the replayed ones.
+func (sm *SessionManager) Resume(token string, lastSeq uint64, conn ziface.IConnection) error {
+	sess, pending, status, err := sm.claim(token, lastSeq, conn)
+	if replyErr := sendControlMsg(conn, sm.opts.ResumeMsgID, []byte{status}); replyErr != nil {
+		fmt.Printf("[SessionManager] Send resume reply to ConnID=%d error: %v\n", conn.GetConnID(), replyErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	replayErr := replayToConn(conn, pending)
+	sess.parked = false
+	sess.link = conn
+	sess.parkLock.Unlock()
+
+	for k, v := range sess.properties {
+		conn.SetProperty(k, v)
+	}
+	conn.SetProperty(PropKeyUserID, sess.userID)
+	attachSession(conn, sess)
+	if c, ok := conn.(*Connection); ok {
+		c.markAuthenticated(sess.userID)
+	}
+
+	sm.lock.Lock()
+	sess.properties = nil
+	sm.userConns[sess.userID] = conn
+	sm.connUsers[conn.GetConnID()] = sess.userID
+	sm.lock.Unlock()
+
+	if conn.IsClosed() {
+		sm.Unbind(conn)
+	}
+	if replayErr != nil {
+		return fmt.Errorf("replay to ConnID=%d failed: %w", conn.GetConnID(), replayErr)
+	}
+
+	fmt.Printf("[SessionManager] Resumed userID=%d on ConnID=%d, replayed %d msgs\n", sess.userID, conn.GetConnID(), len(pending))
+	return nil
+}
+
+// claim returns the session with parkLock held; Resume releases it once the
+// replay is queued.
+func (sm *SessionManager) claim(token string, lastSeq uint64, conn ziface.IConnection) (*Session, []replayEntry, byte, error) {
+	if !sm.resumeEnabled() {
+		return nil, nil, SessionResumeNotFound, errors.New("session resume is disabled")
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	sess, ok := sm.tokens[token]
+	if !ok || sess.conn != nil {
+		return nil, nil, SessionResumeNotFound, fmt.Errorf("%w: no parked session for token", ErrSessionNotFound)
+	}
+	if _, bound := sm.connUsers[conn.GetConnID()]; bound {
+		return nil, nil, SessionResumeBadReq, fmt.Errorf("%w: ConnID=%d is already bound", ErrSessionBadResumeReq, conn.GetConnID())
+	}
+	sess.parkLock.Lock()
+	sess.absorbUnsentLocked()
+	pending, err := sess.pendingSince(lastSeq)
+	if err != nil {
+		sess.parkLock.Unlock()
+		sm.dropSessionLocked(sess.userID)
+		if errors.Is(err, ErrSessionReplayGap) {
+			return nil, nil, SessionResumeReplayGap, err
+		}
+		return nil, nil, SessionResumeBadReq, err
+	}
+
+	if sess.parkTimer != nil {
+		sess.parkTimer.Stop()
+		sess.parkTimer = nil
+	}
+	sess.conn = conn
+	return sess, pending, SessionResumeOK, nil
+}
+
+func (sm *SessionManager) GetConnByUser(userID uint64) (ziface.IConnection, error) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	if conn, ok := sm.userConns[userID]; ok {
+		return conn, nil
+	}
+	return nil, fmt.Errorf("%w: userID=%d", ErrSessionNotFound, userID)
+}
+
+// SendToUser pushes a tracked message to userID. While the user's session is
+// parked the message goes into its replay log and is delivered on resume.
+func (sm *SessionManager) SendToUser(userID uint64, msgID uint32, data []byte) error {
+	sm.lock.RLock()
+	sess := sm.sessions[userID]
+	conn, bound := sm.userConns[userID]
+	sm.lock.RUnlock()
+
+	if sess != nil {
+		return sess.send(msgID, data)
+	}
+	if !bound {
+		return fmt.Errorf("%w: userID=%d", ErrSessionNotFound, userID)
+	}
+	return conn.SendMsg(msgID, data)
+}
+
+func (sm *SessionManager) GetUserByConn(conn ziface.IConnection) (uint64, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	userID, ok := sm.connUsers[conn.GetConnID()]
+	return userID, ok
+}
+
+func (sm *SessionManager) Len() int {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return len(sm.userConns)
+}
+
+func (sm *SessionManager) expire(sess *Session) {
+	sm.lock.Lock()
+	current, ok := sm.sessions[sess.userID]
+	if !ok || current != sess || sess.conn != nil {
+		sm.lock.Unlock()
+		return
+	}
+	sm.dropSessionLocked(sess.userID)
+	sm.lock.Unlock()
+
+	fmt.Printf("[SessionManager] Parked session of userID=%d expired\n", sess.userID)
+	if sm.opts.OnExpire != nil {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					fmt.Printf("[SessionManager] OnExpire panic: %v\n", err)
+				}
+			}()
+			sm.opts.OnExpire(sess.userID)
+		}()
+	}
+}
+
+func (sm *SessionManager) dropSessionLocked(userID uint64) {
+	sess, ok := sm.sessions[userID]
+	if !ok {
+		return
+	}
+	if sess.parkTimer != nil {
+		sess.parkTimer.Stop()
+	}
+	delete(sm.sessions, userID)
+	delete(sm.tokens, sess.token)
+}
+
+func (sm *SessionManager) kick(userID uint64, old ziface.IConnection) {
+	fmt.Printf("[SessionManager] Kicking old session of userID=%d on ConnID=%d\n", userID, old.GetConnID())
+
+	old.RemoveProperty(PropKeyUserID)
+
+	if sm.opts.KickMsgID != 0 {
+		if err := old.SendMsg(sm.opts.KickMsgID, []byte(sm.opts.KickNotice)); err != nil {
+			fmt.Printf("[SessionManager] Send kick notice to ConnID=%d error: %v\n", old.GetConnID(), err)
+		}
+	}
+
+	// Give the writer a moment to flush the notice before the socket goes away.
+	time.AfterFunc(sessionKickDelay, old.Stop)
+}
+
+// SessionResumeRouter handles the client's resume request: 8 bytes
+// little-endian count of tracked messages already received, then the token.
+type SessionResumeRouter struct {
+	BaseRouter
+	mgr *SessionManager
+}
+
+func NewSessionResumeRouter(mgr ziface.ISessionManager) ziface.IRouter {
+	sm, _ := mgr.(*SessionManager)
+	return &SessionResumeRouter{mgr: sm}
+}
+
+func (r *SessionResumeRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	if r.mgr == nil {
+		return
+	}
+
+	data := request.GetData()
+	if len(data) <= 8 {
+		fmt.Printf("[SessionManager] %v: ConnID=%d, len=%d\n", ErrSessionBadResumeReq, conn.GetConnID(), len(data))
+		if err := sendControlMsg(conn, r.mgr.opts.ResumeMsgID, []byte{SessionResumeBadReq}); err != nil {
+			fmt.Printf("[SessionManager] Send resume reply to ConnID=%d error: %v\n", conn.GetConnID(), err)
+		}
+		return
+	}
+	lastSeq := binary.LittleEndian.Uint64(data[:8])
+	token := string(data[8:])
+
+	if err := r.mgr.Resume(token, lastSeq, conn); err != nil {
+		fmt.Printf("[SessionManager] Resume failed on ConnID=%d: %v\n", conn.GetConnID(), err)
+	}
+}
+
+func newSessionToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("generate session token err: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func attachSession(conn ziface.IConnection, sess *Session) {
+	if c, ok := conn.(*Connection); ok {
+		c.session.Store(sess)
+	}
+}
+
+func detachSession(conn ziface.IConnection) {
+	if c, ok := conn.(*Connection); ok {
+		c.session.Store(nil)
+	}
+}
+
+func sendControlMsg(conn ziface.IConnection, msgID uint32, data []byte) error {
+	if c, ok := conn.(*Connection); ok {
+		return c.sendUntrackedMsg(msgID, data)
+	}
+	return conn.SendMsg(msgID, data)
+}
+
+func replayToConn(conn ziface.IConnection, pending []replayEntry) error {
+	for _, e := range pending {
+		if err := sendControlMsg(conn, e.msgID, e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package znet
+
+import "testing"
+
+func TestSessionAbsorbsUnsentQueue(t *testing.T) {
+	dp := NewDataPack()
+	conn := &Connection{connID: 1, dataPack: dp, outQueue: newOutQueue(0, ""), property: map[string]interface{}{}}
+	sess := &Session{userID: 1, conn: conn, link: conn, replayCap: 16}
+	conn.session.Store(sess)
+
+	queue := func(msgID uint32, payload string, untracked bool) {
+		packed, err := dp.Pack(NewMsgPackage(msgID, []byte(payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := &outboundMsg{msgID: msgID, data: packed, priority: PriorityGameplay, untracked: untracked}
+		if _, err := conn.outQueue.push(out, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Written before the drop, so the client has it.
+	sess.record(1, []byte("seen"))
+	queue(2, "queued", false)
+	queue(3, "control", true)
+
+	mgr := NewSessionManager(SessionOptions{ResumeGrace: 1 << 40}).(*SessionManager)
+	mgr.sessions[1] = sess
+	mgr.connUsers[1] = 1
+	mgr.Unbind(conn)
+
+	if err := mgr.SendToUser(1, 4, []byte("parked")); err != nil {
+		t.Fatalf("push while parked: %v", err)
+	}
+	if err := conn.SendBuffMsg(5, []byte("late")); err == nil {
+		t.Fatal("send on a parked connection's queue succeeded")
+	}
+
+	pending, err := sess.pendingSince(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"queued", "parked"}
+	if len(pending) != len(want) {
+		t.Fatalf("pending = %+v, want %v", pending, want)
+	}
+	for i, e := range pending {
+		if string(e.data) != want[i] {
+			t.Fatalf("pending[%d] = %q, want %q", i, e.data, want[i])
+		}
+	}
+	if conn.session.Load() != nil {
+		t.Fatal("parked connection still records into the session")
+	}
+}
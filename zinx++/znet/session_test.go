@@ -0,0 +1,87 @@
+package znet_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"zinxplusplus/znet"
+	"zinxplusplus/ztest"
+)
+
+func newResumeServer(t *testing.T) (*ztest.FakeServer, *znet.SessionManager) {
+	t.Helper()
+	srv := ztest.NewFakeServer()
+	mgr := znet.NewSessionManager(znet.SessionOptions{ResumeGrace: time.Minute}).(*znet.SessionManager)
+	srv.SetSessionManager(mgr)
+	return srv, mgr
+}
+
+func sessionToken(t *testing.T, conn *ztest.FakeConnection) string {
+	t.Helper()
+	msg, ok := conn.WaitFor(znet.MsgIDSessionToken, time.Second)
+	if !ok {
+		t.Fatal("no session token sent on bind")
+	}
+	return string(msg.Data)
+}
+
+func TestSessionPushWhileParkedIsReplayed(t *testing.T) {
+	srv, mgr := newResumeServer(t)
+
+	conn := srv.Connect()
+	if err := mgr.Bind(7, conn); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	token := sessionToken(t, conn)
+	srv.Disconnect(conn)
+
+	if _, err := mgr.GetConnByUser(7); !errors.Is(err, znet.ErrSessionNotFound) {
+		t.Fatalf("parked user still has a connection: %v", err)
+	}
+	for i, payload := range []string{"a", "b", "c"} {
+		if err := mgr.SendToUser(7, uint32(100+i), []byte(payload)); err != nil {
+			t.Fatalf("push %s while parked: %v", payload, err)
+		}
+	}
+
+	resumed := srv.Connect()
+	if err := mgr.Resume(token, 1, resumed); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	sent := resumed.Take()
+	if len(sent) != 3 {
+		t.Fatalf("got %d messages after resume, want reply and 2 replayed: %+v", len(sent), sent)
+	}
+	if sent[0].MsgID != znet.MsgIDSessionResume || sent[0].Data[0] != znet.SessionResumeOK {
+		t.Fatalf("resume reply = %d %v", sent[0].MsgID, sent[0].Data)
+	}
+	for i, want := range []string{"b", "c"} {
+		if got := sent[i+1]; got.MsgID != uint32(101+i) || string(got.Data) != want {
+			t.Fatalf("replayed[%d] = %d %q, want %d %q", i, got.MsgID, got.Data, 101+i, want)
+		}
+	}
+
+	if err := mgr.SendToUser(7, 200, []byte("live")); err != nil {
+		t.Fatalf("push after resume: %v", err)
+	}
+	if _, ok := resumed.WaitFor(200, time.Second); !ok {
+		t.Fatal("push after resume did not reach the new connection")
+	}
+}
+
+func TestSessionResumeUnknownToken(t *testing.T) {
+	srv, mgr := newResumeServer(t)
+
+	conn := srv.Connect()
+	if err := mgr.Resume("nope", 0, conn); !errors.Is(err, znet.ErrSessionNotFound) {
+		t.Fatalf("resume with unknown token: %v", err)
+	}
+	reply, ok := conn.WaitFor(znet.MsgIDSessionResume, time.Second)
+	if !ok || reply.Data[0] != znet.SessionResumeNotFound {
+		t.Fatalf("resume reply = %+v", reply)
+	}
+	if err := mgr.SendToUser(9, 1, nil); !errors.Is(err, znet.ErrSessionNotFound) {
+		t.Fatalf("push to unknown user: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package ztest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// SentMsg is one message a handler sent through a FakeConnection.
+type SentMsg struct {
+	MsgID       uint32
+	Data        []byte
+	Priority    uint8
+	CoalesceKey uint64
+	Buffered    bool
+}
+
+// FakeConnection implements ziface.IConnection without a socket. Everything
+// sent through it is captured in order and can be inspected with Sent,
+// Take or WaitFor.
+type FakeConnection struct {
+	connID     uint64
+	workerID   uint32
+	remoteAddr net.Addr
+	localAddr  net.Addr
+
+	sent     []SentMsg
+	sentCond *sync.Cond
+	sendErr  error
+
+	property map[string]interface{}
+
+	closed        bool
+	closeCallback func(connection ziface.IConnection) error
+
+	authenticated bool
+	identity      interface{}
+	recording     bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	lock   sync.Mutex
+}
+
+func NewFakeConnection(connID uint64) *FakeConnection {
+	c := &FakeConnection{
+		connID:     connID,
+		remoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000 + int(connID%20000)},
+		localAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8999},
+		property:   make(map[string]interface{}),
+	}
+	c.sentCond = sync.NewCond(&c.lock)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *FakeConnection) Start() {}
+
+func (c *FakeConnection) Stop() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	callback := c.closeCallback
+	c.sentCond.Broadcast()
+	c.lock.Unlock()
+
+	if callback != nil {
+		_ = callback(c)
+	}
+	c.cancel()
+}
+
+func (c *FakeConnection) GetConnection() netpoll.Connection {
+	return nil
+}
+
+func (c *FakeConnection) GetConnID() uint64 {
+	return c.connID
+}
+
+func (c *FakeConnection) GetWorkerID() uint32 {
+	return c.workerID
+}
+
+func (c *FakeConnection) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *FakeConnection) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// SetRemoteAddr changes what RemoteAddr reports, e.g. to test IP-based logic.
+func (c *FakeConnection) SetRemoteAddr(addr net.Addr) {
+	c.remoteAddr = addr
+}
+
+// SetSendError makes every following send fail with err; nil restores
+// normal capturing.
+func (c *FakeConnection) SetSendError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sendErr = err
+}
+
+func (c *FakeConnection) SendMsg(msgId uint32, data []byte) error {
+	return c.capture(SentMsg{MsgID: msgId, Data: data, Priority: znet.PriorityGameplay})
+}
+
+func (c *FakeConnection) SendBuffMsg(msgId uint32, data []byte) error {
+	return c.capture(SentMsg{MsgID: msgId, Data: data, Priority: znet.PriorityGameplay, Buffered: true})
+}
+
+func (c *FakeConnection) SendPriorityMsg(priority uint8, msgId uint32, data []byte) error {
+	return c.capture(SentMsg{MsgID: msgId, Data: data, Priority: priority, Buffered: true})
+}
+
+func (c *FakeConnection) SendCoalescedMsg(priority uint8, coalesceKey uint64, msgId uint32, data []byte) error {
+	return c.capture(SentMsg{MsgID: msgId, Data: data, Priority: priority, CoalesceKey: coalesceKey, Buffered: true})
+}
+
+func (c *FakeConnection) capture(msg SentMsg) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return errors.New("connection closed when send msg")
+	}
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	// Copy so handlers reusing their buffers cannot change what was captured.
+	msg.Data = append([]byte(nil), msg.Data...)
+	c.sent = append(c.sent, msg)
+	c.sentCond.Broadcast()
+	return nil
+}
+
+func (c *FakeConnection) GetSendStats() ziface.ConnSendStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := ziface.ConnSendStats{SentMsgs: uint64(len(c.sent))}
+	for _, m := range c.sent {
+		stats.SentBytes += uint64(len(m.Data))
+	}
+	return stats
+}
+
+// Sent returns a copy of everything sent so far.
+func (c *FakeConnection) Sent() []SentMsg {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]SentMsg(nil), c.sent...)
+}
+
+// Take returns everything sent so far and clears the capture buffer.
+func (c *FakeConnection) Take() []SentMsg {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sent := c.sent
+	c.sent = nil
+	return sent
+}
+
+// WaitFor blocks until a message with msgID has been sent or timeout passes,
+// for handlers that reply from another goroutine (scenes, timers). The
+// matching message is removed from the capture buffer.
+func (c *FakeConnection) WaitFor(msgID uint32, timeout time.Duration) (SentMsg, bool) {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		c.lock.Lock()
+		c.sentCond.Broadcast()
+		c.lock.Unlock()
+	})
+	defer timer.Stop()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		for i, m := range c.sent {
+			if m.MsgID == msgID {
+				c.sent = append(c.sent[:i:i], c.sent[i+1:]...)
+				return m, true
+			}
+		}
+		if c.closed || !time.Now().Before(deadline) {
+			return SentMsg{}, false
+		}
+		c.sentCond.Wait()
+	}
+}
+
+func (c *FakeConnection) SetProperty(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.property[key] = value
+}
+
+func (c *FakeConnection) GetProperty(key string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if value, ok := c.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("no property found")
+}
+
+func (c *FakeConnection) RemoveProperty(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.property, key)
+}
+
+func (c *FakeConnection) Context() context.Context {
+	return c.ctx
+}
+
+func (c *FakeConnection) SetReadTimeout(timeout time.Duration) error {
+	return nil
+}
+
+func (c *FakeConnection) SetIdleTimeout(timeout time.Duration) error {
+	return nil
+}
+
+func (c *FakeConnection) SetCloseCallback(callback func(connection ziface.IConnection) error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeCallback = callback
+}
+
+func (c *FakeConnection) IsClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// SetAuthenticated marks the connection as having passed the handshake with
+// the given identity.
+func (c *FakeConnection) SetAuthenticated(identity interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.authenticated = true
+	c.identity = identity
+}
+
+func (c *FakeConnection) IsAuthenticated() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.authenticated
+}
+
+func (c *FakeConnection) GetIdentity() interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.identity
+}
+
+// StartRecording only tracks the flag; the fake already keeps every frame.
+func (c *FakeConnection) StartRecording(path string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.recording = true
+	return nil
+}
+
+func (c *FakeConnection) StopRecording() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.recording = false
+	return nil
+}
+
+func (c *FakeConnection) IsRecording() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.recording
+}
@@ -0,0 +1,34 @@
+// Package ztest runs routers without sockets: a FakeConnection captures what
+// handlers send, a FakeServer wires the real MsgHandle, ConnManager and
+// SessionManager together, and Dispatch pushes one message through them.
+//
+//	srv := ztest.NewFakeServer()
+//	srv.AddRouter(1, &PingRouter{})
+//	conn := srv.Connect()
+//	replies := srv.Dispatch(conn, 1, []byte("ping"))
+//	// replies[0].MsgID, replies[0].Data ...
+package ztest
+
+import (
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+// Dispatch runs msgID/data from conn through mh.DoMsgHandler on the calling
+// goroutine and returns what the handler sent during that call. Earlier
+// captured messages are left in conn untouched.
+func Dispatch(mh ziface.IMsgHandler, conn *FakeConnection, msgID uint32, data []byte) []SentMsg {
+	before := len(conn.Sent())
+	mh.DoMsgHandler(znet.NewRequest(conn, znet.NewMsgPackage(msgID, data)))
+
+	sent := conn.Sent()
+	if len(sent) <= before {
+		return nil
+	}
+	return sent[before:]
+}
+
+// Request builds a request for calling a router's Handle directly.
+func Request(conn ziface.IConnection, msgID uint32, data []byte) ziface.IRequest {
+	return znet.NewRequest(conn, znet.NewMsgPackage(msgID, data))
+}
@@ -0,0 +1,19 @@
+package ztest_test
+
+import (
+	"fmt"
+
+	"zinxplusplus/ztest"
+)
+
+func ExampleFakeServer() {
+	srv := ztest.NewFakeServer()
+	srv.AddRouter(msgPing, &pingRouter{})
+	conn := srv.Connect()
+
+	// The framework logs to stdout, so this example is compiled but has no
+	// checked output; the tests in ztest_test.go run the same flow.
+	for _, reply := range srv.Dispatch(conn, msgPing, []byte("hello")) {
+		fmt.Println(reply.MsgID, string(reply.Data)) // 2 pong:hello
+	}
+}
@@ -0,0 +1,184 @@
+package ztest
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"zinxplusplus/timer"
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+)
+
+// FakeServer implements ziface.IServer around a real MsgHandle, ConnManager
+// and SessionManager but never opens a socket. Requests are dispatched on the
+// calling goroutine, so a test sees every synchronous reply as soon as
+// Dispatch returns.
+type FakeServer struct {
+	name       string
+	msgHandler ziface.IMsgHandler
+	connMgr    ziface.IConnManager
+	sessionMgr ziface.ISessionManager
+	scheduler  *timer.Scheduler
+
+	stateMgr     ziface.IStateManager
+	aoiMgr       ziface.IAoiManager
+	scriptEngine ziface.IScriptEngine
+	admission    ziface.IAdmissionController
+
+	onConnStart   func(ziface.IConnection)
+	onConnStop    func(ziface.IConnection)
+	connStopHooks []func(ziface.IConnection)
+	stopHooks     []func(ziface.IServer)
+
+	nextConnID uint64
+}
+
+func NewFakeServer() *FakeServer {
+	return &FakeServer{
+		name:       "ZinxTestServer",
+		msgHandler: znet.NewMsgHandle(),
+		connMgr:    znet.NewConnManager(),
+		sessionMgr: znet.NewSessionManager(znet.SessionOptions{Policy: znet.SessionPolicyKickOld}),
+		scheduler:  timer.NewScheduler(10*time.Millisecond, 5),
+	}
+}
+
+// Connect creates a FakeConnection, registers it with the ConnManager and
+// runs the OnConnStart hook, like an accepted socket would.
+func (s *FakeServer) Connect() *FakeConnection {
+	conn := NewFakeConnection(atomic.AddUint64(&s.nextConnID, 1))
+	s.connMgr.Add(conn)
+	s.CallOnConnStart(conn)
+	return conn
+}
+
+// Disconnect runs the OnConnStop hook and unregisters conn.
+func (s *FakeServer) Disconnect(conn *FakeConnection) {
+	s.CallOnConnStop(conn)
+	_ = s.connMgr.Remove(conn)
+	conn.Stop()
+}
+
+// Dispatch sends one message from conn through the real MsgHandle and returns
+// the messages the handler sent back synchronously.
+func (s *FakeServer) Dispatch(conn *FakeConnection, msgID uint32, data []byte) []SentMsg {
+	return Dispatch(s.msgHandler, conn, msgID, data)
+}
+
+// Start runs the scheduler so AfterFunc/Every timers fire; nothing listens.
+func (s *FakeServer) Start() {
+	s.scheduler.Start()
+}
+
+func (s *FakeServer) Stop() {
+	s.connMgr.ClearConn()
+	for _, hook := range s.stopHooks {
+		hook(s)
+	}
+	s.scheduler.Stop()
+}
+
+func (s *FakeServer) Serve() {
+	s.Start()
+}
+
+func (s *FakeServer) AddRouter(msgId uint32, router ziface.IRouter) {
+	s.msgHandler.AddRouter(msgId, router)
+}
+
+func (s *FakeServer) GetConnMgr() ziface.IConnManager {
+	return s.connMgr
+}
+
+func (s *FakeServer) GetMsgHandler() ziface.IMsgHandler {
+	return s.msgHandler
+}
+
+func (s *FakeServer) SetOnConnStart(hook func(ziface.IConnection)) {
+	s.onConnStart = hook
+}
+
+func (s *FakeServer) SetOnConnStop(hook func(ziface.IConnection)) {
+	s.onConnStop = hook
+}
+
+func (s *FakeServer) CallOnConnStart(connection ziface.IConnection) {
+	if s.onConnStart != nil {
+		s.onConnStart(connection)
+	}
+}
+
+func (s *FakeServer) CallOnConnStop(connection ziface.IConnection) {
+	if s.onConnStop != nil {
+		s.onConnStop(connection)
+	}
+	for _, hook := range s.connStopHooks {
+		hook(connection)
+	}
+	s.sessionMgr.Unbind(connection)
+}
+
+func (s *FakeServer) AddOnConnStop(hook func(ziface.IConnection)) {
+	s.connStopHooks = append(s.connStopHooks, hook)
+}
+
+func (s *FakeServer) AddOnStop(hook func(ziface.IServer)) {
+	s.stopHooks = append(s.stopHooks, hook)
+}
+
+func (s *FakeServer) SetStateManager(mgr ziface.IStateManager) {
+	s.stateMgr = mgr
+}
+
+func (s *FakeServer) GetStateManager() ziface.IStateManager {
+	return s.stateMgr
+}
+
+func (s *FakeServer) SetAoiManager(mgr ziface.IAoiManager) {
+	s.aoiMgr = mgr
+}
+
+func (s *FakeServer) GetAoiManager() ziface.IAoiManager {
+	return s.aoiMgr
+}
+
+func (s *FakeServer) SetScriptEngine(engine ziface.IScriptEngine) {
+	s.scriptEngine = engine
+}
+
+func (s *FakeServer) GetScriptEngine() ziface.IScriptEngine {
+	return s.scriptEngine
+}
+
+func (s *FakeServer) SetSessionManager(mgr ziface.ISessionManager) {
+	s.sessionMgr = mgr
+}
+
+func (s *FakeServer) GetSessionManager() ziface.ISessionManager {
+	return s.sessionMgr
+}
+
+func (s *FakeServer) GetScheduler() ziface.IScheduler {
+	return s.scheduler
+}
+
+func (s *FakeServer) SetAdmissionController(ac ziface.IAdmissionController) {
+	s.admission = ac
+}
+
+func (s *FakeServer) GetAdmissionController() ziface.IAdmissionController {
+	return s.admission
+}
+
+func (s *FakeServer) ServerName() string {
+	return s.name
+}
+
+func (s *FakeServer) GetListener() net.Listener {
+	return nil
+}
+
+func (s *FakeServer) GetListeners() []net.Listener {
+	return nil
+}
@@ -0,0 +1,122 @@
+package ztest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"zinxplusplus/ziface"
+	"zinxplusplus/znet"
+	"zinxplusplus/ztest"
+)
+
+const (
+	msgPing  uint32 = 1
+	msgPong  uint32 = 2
+	msgLogin uint32 = 3
+	msgLater uint32 = 4
+)
+
+type pingRouter struct {
+	znet.BaseRouter
+}
+
+func (r *pingRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConnection().SendMsg(msgPong, append([]byte("pong:"), request.GetData()...))
+}
+
+// loginRouter binds the connection to the user ID in the payload.
+type loginRouter struct {
+	znet.BaseRouter
+	sessions ziface.ISessionManager
+}
+
+func (r *loginRouter) Handle(request ziface.IRequest) {
+	var userID uint64
+	fmt.Sscan(string(request.GetData()), &userID)
+	if err := r.sessions.Bind(userID, request.GetConnection()); err != nil {
+		_ = request.GetConnection().SendMsg(msgLogin, []byte(err.Error()))
+		return
+	}
+	_ = request.GetConnection().SendMsg(msgLogin, []byte("ok"))
+}
+
+// laterRouter replies from another goroutine, like a scene or timer would.
+type laterRouter struct {
+	znet.BaseRouter
+}
+
+func (r *laterRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	time.AfterFunc(10*time.Millisecond, func() {
+		_ = conn.SendMsg(msgLater, []byte("done"))
+	})
+}
+
+func TestDispatchReturnsSynchronousReplies(t *testing.T) {
+	srv := ztest.NewFakeServer()
+	srv.AddRouter(msgPing, &pingRouter{})
+	conn := srv.Connect()
+
+	replies := srv.Dispatch(conn, msgPing, []byte("a"))
+	if len(replies) != 1 || replies[0].MsgID != msgPong || string(replies[0].Data) != "pong:a" {
+		t.Fatalf("replies = %+v", replies)
+	}
+	// Each Dispatch only returns what that call sent.
+	replies = srv.Dispatch(conn, msgPing, []byte("b"))
+	if len(replies) != 1 || string(replies[0].Data) != "pong:b" {
+		t.Fatalf("second replies = %+v", replies)
+	}
+	if sent := conn.Sent(); len(sent) != 2 {
+		t.Fatalf("captured %d messages, want 2", len(sent))
+	}
+	if replies := srv.Dispatch(conn, 99, nil); replies != nil {
+		t.Fatalf("unrouted message got replies %+v", replies)
+	}
+}
+
+func TestSessionsThroughFakeServer(t *testing.T) {
+	srv := ztest.NewFakeServer()
+	srv.AddRouter(msgLogin, &loginRouter{sessions: srv.GetSessionManager()})
+
+	var stopped []uint64
+	srv.AddOnConnStop(func(conn ziface.IConnection) {
+		stopped = append(stopped, conn.GetConnID())
+	})
+
+	first := srv.Connect()
+	if replies := srv.Dispatch(first, msgLogin, []byte("7")); len(replies) != 1 || string(replies[0].Data) != "ok" {
+		t.Fatalf("login replies = %+v", replies)
+	}
+	if conn, err := srv.GetSessionManager().GetConnByUser(7); err != nil || conn != first {
+		t.Fatalf("GetConnByUser = %v, %v", conn, err)
+	}
+
+	srv.Disconnect(first)
+	if len(stopped) != 1 || stopped[0] != first.GetConnID() {
+		t.Fatalf("OnConnStop hooks saw %v", stopped)
+	}
+	if _, err := srv.GetSessionManager().GetConnByUser(7); err == nil {
+		t.Fatal("user still bound after disconnect")
+	}
+	if srv.GetConnMgr().Len() != 0 {
+		t.Fatalf("%d connections left after disconnect", srv.GetConnMgr().Len())
+	}
+}
+
+func TestWaitForAsyncReply(t *testing.T) {
+	srv := ztest.NewFakeServer()
+	srv.AddRouter(msgPing, &laterRouter{})
+	conn := srv.Connect()
+
+	if replies := srv.Dispatch(conn, msgPing, nil); len(replies) != 0 {
+		t.Fatalf("async handler replied synchronously: %+v", replies)
+	}
+	msg, ok := conn.WaitFor(msgLater, time.Second)
+	if !ok || string(msg.Data) != "done" {
+		t.Fatalf("WaitFor = %+v, %v", msg, ok)
+	}
+	if _, ok := conn.WaitFor(msgLater, 20*time.Millisecond); ok {
+		t.Fatal("WaitFor returned the same message twice")
+	}
+}